@@ -18,7 +18,9 @@ import (
 	"math/big"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -71,158 +73,128 @@ func autoRenewDynamoCredentials(svc **dynamodb.DynamoDB, region string) {
 	}
 }
 
-// Lists all the objects in an S3 Bucket prefix
-// client is the S3 client object. Used to connect to the S3 service
-// region is a string annotating the AWS region to be used. Example: "us-east-2"
-// bucket is a string annotating the S3 bucket to be used. Example "ryans-test-bucket675"
-// prefix is a string annotating the prefix within the bucket to be targeting
-// maxKeys is an int64 to set the max number of objects to return
-func getObjects(client *s3.S3, bucket string, prefix string, page int, pageSize int) ([]string, error) {
-
-	var final []string // Holds the final value for the return
-	lowerBound := page * pageSize
-	upperBound := (page * pageSize) + pageSize
-
-	// List objects in the bucket + prefix
-	objects, err := client.ListObjects(&s3.ListObjectsInput{
-		Bucket:  &bucket,
-		Prefix:  &prefix,
-		MaxKeys: aws.Int64(10000),
-	})
-	if err != nil {
-		return []string{}, err
-	}
+// Takes list of thumbnails in the S3 prefix and gets pre-signed urls for them and their previews
+// Returns the thumbnails along with when the first of their urls expires
+// presigner is the cache of pre-signed urls
+// keys is a slice of the thumbnail keys in an S3 bucket prefix
+// previews is the preview keys that exist, from shootPreviews. Photos without one get no preview url
+func createUrls(presigner *PresignCache, keys []string, previews map[string]bool) ([]Thumbnail, time.Time, error) {
 
-	// Append the object keys to a slice to return
-	for i, key := range objects.Contents {
-		if *key.Size > 0 {
-			if i >= lowerBound && i < upperBound {
-				final = append(final, *key.Key)
-			}
+	objects := make([]string, 0, len(keys)*2)
+	objects = append(objects, keys...)
+	for _, key := range keys {
+		if previews[previewKey(key)] {
+			objects = append(objects, previewKey(key))
 		}
 	}
-	return final, nil
-}
-
-// Used to get all of a user's shoots for use in the home page
-func getShoots(tableName string, username string, svc *dynamodb.DynamoDB) (string, error) {
-
-	key := map[string]*dynamodb.AttributeValue{
-		"username": {
-			S: aws.String(username),
-		},
-	}
-
-	attributeToGet := "shoots"
-
-	input := &dynamodb.GetItemInput{
-		TableName:            aws.String(tableName),
-		Key:                  key,
-		ProjectionExpression: aws.String(attributeToGet),
-	}
 
-	result, err := svc.GetItem(input)
+	urls, expires, err := presigner.URLs(objects)
 	if err != nil {
-		return "", errors.New("there was an error getting the shoots")
+		return nil, time.Time{}, err
 	}
 
-	var final []Shoot
-	var shoots map[string]Shoot
-	err = dynamodbattribute.UnmarshalMap(result.Item, &final)
-
-	if result.Item != nil {
-		// Unmarshal the DynamoDB item into the Item struct
-		err := dynamodbattribute.UnmarshalMap(result.Item["shoots"].M, &shoots)
-		if err != nil {
-			fmt.Println("Error unmarshalling item:", err)
-			return "", errors.New("error unmarshalling item")
+	final := make([]Thumbnail, 0, len(keys))
+	next := len(keys) // Preview urls follow the thumbnails, in the same order
+	for i, key := range keys {
+		thumbnail := Thumbnail{Key: photoKey(key), Url: urls[i]}
+		if previews[previewKey(key)] {
+			thumbnail.Preview = urls[next]
+			next++
 		}
-	} else {
-		fmt.Println("Item not found")
+		final = append(final, thumbnail)
 	}
 
-	shootsJSON, _ := json.Marshal(shoots)
-
-	return string(shootsJSON), nil
-
+	return final, expires, nil
 }
 
-// Takes list of objects in the S3 prefix and creates pre-signed urls for them
-// Returns a string slice containing the urls
-// client is the S3 client object. Used to connect to the S3 service
-// bucket is a string annotating the S3 bucket to be used. Example "ryans-test-bucket675"
-// keys is a slice of the object keys in an S3 bucket prefix
-// minutes is the number of minutes the pre-signed urls should be good for
-func createUrls(client *s3.S3, bucket string, keys []string, minutes int64) ([]Thumbnail, error) {
+// grace is the deadline grace period, used to show whether a shoot's selections are locked
+// Returns the tiles, in the order the user chose, along with when the first of their thumbnail urls expires
+func generateTiles(user User, presigner *PresignCache, grace time.Duration) ([]HomePageTile, time.Time, error) {
+
+	var final []HomePageTile
+	var thumbnails []string
 
-	var final []Thumbnail
+	ids := orderedShootIDs(user)
+	for _, key := range ids {
+		thumbnails = append(thumbnails, user.Shoots[key].Thumbnail)
+	}
 
-	// iterate through objects keys from the bucket + prefix
-	for _, key := range keys {
+	urls, expires, err := presigner.URLs(thumbnails)
+	if err != nil {
+		log.Printf("could not presign thumbnails: %v", err)
+		return make([]HomePageTile, 0), time.Time{}, errors.New("could not generate thumbnail url")
+	}
 
-		urlStr, err := createS3Presigned(bucket, key, minutes, client)
-		if err != nil {
-			return []Thumbnail{}, nil
-		}
+	for i, key := range ids {
 
-		// Append the url to final for return
-		key = key[strings.LastIndex(key, "/")+1 : strings.LastIndex(key, "_thumb")]
-		final = append(final, Thumbnail{Key: key, Url: urlStr})
+		value := user.Shoots[key]
 
+		final = append(final, HomePageTile{
+			ID:        key,
+			Name:      shootDisplayName(key, value),
+			Date:      value.Date,
+			Thumbnail: urls[i],
+			Deadline:  value.Deadline,
+			Locked:    selectionsLocked(value, grace),
+		})
 	}
 
-	return final, nil
+	return final, expires, nil
+
 }
 
-// Create the request object using the key + bucket
-func createS3Presigned(bucket string, key string, minutes int64, client *s3.S3) (string, error) {
-	// Create the request object using the key + bucket
-	req, _ := client.GetObjectRequest(&s3.GetObjectInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(key),
-	})
+// Takes in the gallery page data and generates the html page to send to the user
+// Returns the HTML as a string
+// page holds the pre-signed urls to be used in the gallery along with the shoot's deadline
+func createHTML(page GalleryPage) (string, error) {
 
-	// Generate pre-signed url for x minutes using the request object
-	urlStr, err := req.Presign(time.Duration(minutes) * time.Minute)
+	tmpl, err := template.ParseFiles("./static/html/gallery.html")
 	if err != nil {
+		log.Printf("Could not parse gallery.html")
 		return "", err
 	}
 
-	return urlStr, nil
+	var final bytes.Buffer
+	err = tmpl.Execute(&final, page)
+	if err != nil {
+		log.Printf("Could not execute html template: %v", err)
+		return "", err
+	}
 
+	return final.String(), nil
 }
 
-func generateTiles(inputMAP map[string]Shoot, bucket string, client *s3.S3) ([]HomePageTile, error) {
-
-	var final []HomePageTile
-
-	for key, value := range inputMAP {
-
-		thumbnail, err := createS3Presigned(bucket, value.Thumbnail, 30, client)
-		if err != nil {
-			return make([]HomePageTile, 0), errors.New("could not generate thumbnail url")
-		}
+// Renders the gallery people see through a share link
+func createShareHTML(page SharePage) (string, error) {
 
-		final = append(final, HomePageTile{Name: key, Thumbnail: thumbnail})
+	tmpl, err := template.ParseFiles("./static/html/share.html")
+	if err != nil {
+		log.Printf("Could not parse share.html")
+		return "", err
 	}
 
-	return final, nil
+	var final bytes.Buffer
+	err = tmpl.Execute(&final, page)
+	if err != nil {
+		log.Printf("Could not execute html template: %v", err)
+		return "", err
+	}
 
+	return final.String(), nil
 }
 
-// Takes in a string slice of pre-signed urls and generates the html page to send to the user
-// Returns the HTML as a string
-// keys is a slice of the pre-signed urls to be used in the gallery
-func createHTML(keys []Thumbnail) (string, error) {
+// Renders the signup page
+// The form is only shown when there is an invite token or when creating the first account
+func createSignupHTML(page SignupPage) (string, error) {
 
-	tmpl, err := template.ParseFiles("./static/html/gallery.html")
+	tmpl, err := template.ParseFiles("./static/html/signup.html")
 	if err != nil {
-		log.Printf("Could not parse gallery.html")
+		log.Printf("Could not parse signup.html")
 		return "", err
 	}
 
 	var final bytes.Buffer
-	err = tmpl.Execute(&final, keys)
+	err = tmpl.Execute(&final, page)
 	if err != nil {
 		log.Printf("Could not execute html template: %v", err)
 		return "", err
@@ -248,6 +220,9 @@ func generateSessionToken() (string, error) {
 	return token, nil
 }
 
+// Returned by getUser when there is no user with the username
+var errUserNotFound = errors.New("user does not exist")
+
 func getUser(tableName string, username string, svc *dynamodb.DynamoDB) (User, error) {
 	result, err := svc.GetItem(&dynamodb.GetItemInput{
 		TableName: aws.String(tableName),
@@ -268,7 +243,7 @@ func getUser(tableName string, username string, svc *dynamodb.DynamoDB) (User, e
 	}
 
 	if final.Username == "" {
-		return User{}, errors.New("user does not exist")
+		return User{}, errUserNotFound
 	}
 
 	return final, nil
@@ -277,8 +252,10 @@ func getUser(tableName string, username string, svc *dynamodb.DynamoDB) (User, e
 func createUser(tableName string, user User, svc *dynamodb.DynamoDB) error {
 
 	user.Username = strings.ToLower(user.Username) //Ensure username is all lowercase
-	user.Shoots = make(map[string]Shoot)           //Make sure the property is initialized
-	user.Shoots["placeholder"] = Shoot{}
+	if len(user.Shoots) == 0 {
+		user.Shoots = make(map[string]Shoot) //Make sure the property is initialized
+		user.Shoots[placeholderShoot] = Shoot{}
+	}
 
 	_, err := getUser(tableName, user.Username, svc)
 	if err == nil {
@@ -291,11 +268,15 @@ func createUser(tableName string, user User, svc *dynamodb.DynamoDB) error {
 	}
 
 	input := &dynamodb.PutItemInput{
-		Item:      av,
-		TableName: aws.String(tableName),
+		Item:                av,
+		TableName:           aws.String(tableName),
+		ConditionExpression: aws.String("attribute_not_exists(username)"), // Two signups for the same name at once
 	}
 
 	_, err = svc.PutItem(input)
+	if conditionFailed(err) {
+		return errors.New("user already exists")
+	}
 	if err != nil {
 		return fmt.Errorf("got error calling PutItem: %s", err)
 	}
@@ -303,6 +284,34 @@ func createUser(tableName string, user User, svc *dynamodb.DynamoDB) error {
 	return nil
 }
 
+// Returns every user in the table
+// Used by background jobs that need to look at all shoots
+func scanUsers(tableName string, svc *dynamodb.DynamoDB) ([]User, error) {
+
+	var final []User
+	var unmarshalErr error
+
+	err := svc.ScanPages(&dynamodb.ScanInput{
+		TableName: aws.String(tableName),
+	}, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		var users []User
+		unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &users)
+		if unmarshalErr != nil {
+			return false
+		}
+		final = append(final, users...)
+		return true
+	})
+	if err != nil {
+		return []User{}, err
+	}
+	if unmarshalErr != nil {
+		return []User{}, fmt.Errorf("could not unmarshal users: %v", unmarshalErr)
+	}
+
+	return final, nil
+}
+
 func setToken(r *redis.Client, username string, token string) {
 	err := r.Set(username, token, time.Minute*30).Err()
 	if err != nil {
@@ -384,10 +393,35 @@ func fileExists(filename string) bool {
 	return false // Error occurred (e.g., permission denied)
 }
 
+// Logs a user out everywhere by deleting their session token
+func clearSessions(redClient *redis.Client, username string) {
+	err := redClient.Del(username).Err()
+	if err != nil {
+		log.Printf("there was a problem clearing the sessions for %v: %v", username, err)
+	}
+}
+
 func resetTokenTimeout(redClient *redis.Client, username string, redisTimeout int) {
 	_ = redClient.Expire(username, time.Minute*time.Duration(redisTimeout))
 }
 
+// Salts and hashes a password using bcrypt
+// Returns the hash and the salt that was used
+func hashPassword(password string) (string, string, error) {
+
+	salt, err := generateSalt(32)
+	if err != nil {
+		return "", "", fmt.Errorf("could not generate salt: %v", err)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password+salt), bcrypt.DefaultCost)
+	if err != nil {
+		return "", "", fmt.Errorf("could not hash the password: %v", err)
+	}
+
+	return string(hash), salt, nil
+}
+
 func verifyPassword(hashedPassword string, inputPassword string, salt string) bool {
 	// Compare the hashed password with the input password
 	inputPassword = inputPassword + salt
@@ -395,7 +429,44 @@ func verifyPassword(hashedPassword string, inputPassword string, salt string) bo
 	return err == nil
 }
 
-func checkToken(c *gin.Context, redClient *redis.Client) (bool, string) {
+// Shortest password an account can have, on signup and on reset
+const minPasswordLength = 8
+
+// Usernames are keys in Redis and DynamoDB, so they are kept to characters no key prefix or separator uses
+// Example: "jane.smith"
+var usernamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{2,31}$`)
+
+// Fixed Redis keys a username would clash with, since sessions are stored under the bare username
+var reservedUsernames = []string{"lockout-events"}
+
+// Checks a username for a new account
+// username should already be lowercase
+func checkUsername(username string) error {
+
+	if !usernamePattern.MatchString(username) {
+		return errors.New("username must be 3 to 32 lowercase letters, numbers, dots, dashes or underscores and start with a letter or number")
+	}
+	if containsString(reservedUsernames, username) {
+		return errors.New("that username is not available")
+	}
+
+	return nil
+}
+
+// Checks a new password against the password policy
+func checkPassword(password string) error {
+
+	if len(password) < minPasswordLength {
+		return fmt.Errorf("password must be at least %v characters", minPasswordLength)
+	}
+
+	return nil
+}
+
+// Checks the authToken cookie against the session stored in Redis
+// Cookies that fail signature verification are rejected without touching Redis
+// Returns whether the user is logged in and their username
+func checkToken(c *gin.Context, redClient *redis.Client, cookies *CookieCodec) (bool, string) {
 
 	cookie, err := c.Cookie("authToken")
 	if err != nil {
@@ -404,9 +475,9 @@ func checkToken(c *gin.Context, redClient *redis.Client) (bool, string) {
 	}
 
 	var cookieValue map[string]string
-	err = json.Unmarshal([]byte(cookie), &cookieValue)
+	err = cookies.Decode("authToken", cookie, &cookieValue)
 	if err != nil {
-		log.Printf("could not unmarshal cookie value: %v", err)
+		log.Printf("rejected authToken cookie from %v: %v", c.ClientIP(), err)
 		return false, ""
 	}
 
@@ -423,7 +494,37 @@ func checkToken(c *gin.Context, redClient *redis.Client) (bool, string) {
 	}
 }
 
-func updatePicks(tableName string, username string, shootName string, newValue Picks, svc *dynamodb.DynamoDB) error {
+// Logs a user in by creating a session token and sending it to the client in the authToken cookie
+// Returns the session token
+func startSession(c *gin.Context, redClient *redis.Client, cookies *CookieCodec, username string) (string, error) {
+
+	token, err := generateSessionToken()
+	if err != nil {
+		return "", fmt.Errorf("could not generate token for %v: %v", username, err)
+	}
+	setToken(redClient, username, token)
+
+	weekInSeconds := 604800
+	authJson := map[string]string{"username": username, "token": token}
+	authValue, err := cookies.Encode("authToken", authJson, time.Duration(weekInSeconds)*time.Second)
+	if err != nil {
+		return "", err
+	}
+
+	// Create a new cookie
+	authCookie := &http.Cookie{
+		Name:     "authToken",
+		Value:    authValue,
+		HttpOnly: true,
+	}
+
+	c.SetSameSite(http.SameSiteLaxMode) // Lax so links to the site from emails still arrive logged in
+	c.SetCookie(authCookie.Name, authCookie.Value, weekInSeconds, "/", c.Request.Host, true, true)
+
+	return token, nil
+}
+
+func updatePicks(tableName string, username string, shootID string, newValue Picks, svc *dynamodb.DynamoDB) error {
 
 	// Define the key to identify the item you want to update
 	key := map[string]*dynamodb.AttributeValue{
@@ -433,7 +534,8 @@ func updatePicks(tableName string, username string, shootName string, newValue P
 	}
 
 	// Define the update expression to set the new property value
-	updateExpression := "SET shoots." + shootName + ".picks = :newValue"
+	path, names := shootPath(shootID, "picks")
+	updateExpression := "SET " + path + " = :newValue"
 
 	newPicksMap, _ := dynamodbattribute.MarshalMap(newValue)
 
@@ -449,6 +551,7 @@ func updatePicks(tableName string, username string, shootName string, newValue P
 		TableName:                 aws.String(tableName),
 		Key:                       key,
 		UpdateExpression:          aws.String(updateExpression),
+		ExpressionAttributeNames:  names,
 		ExpressionAttributeValues: expressionAttributeValues,
 		ReturnValues:              aws.String("UPDATED_NEW"), // If you want to return the updated item
 	}
@@ -570,14 +673,16 @@ func deletePlaceHolder(svc *dynamodb.DynamoDB, username string, tableName string
 	}
 
 	// Define the update expression to set the new property value
-	updateExpression := "REMOVE shoots.placeholder"
+	path, names := shootPath(placeholderShoot)
+	updateExpression := "REMOVE " + path
 
 	// Configure the update input
 	updateInput := &dynamodb.UpdateItemInput{
-		TableName:        aws.String(tableName),
-		Key:              key,
-		UpdateExpression: aws.String(updateExpression),
-		ReturnValues:     aws.String("UPDATED_NEW"), // If you want to return the updated item
+		TableName:                aws.String(tableName),
+		Key:                      key,
+		UpdateExpression:         aws.String(updateExpression),
+		ExpressionAttributeNames: names,
+		ReturnValues:             aws.String("UPDATED_NEW"), // If you want to return the updated item
 	}
 
 	// Perform the update operation
@@ -594,6 +699,10 @@ func main() {
 	region := env("REGION")       // AWS region to be used
 	bucket := env("BUCKET")       // S3 bucket to be referenced
 	tableName := env("TABLENAME") // DynamoDB table to use
+	dataTable := env("DATA_TABLENAME")
+	if dataTable == "" {
+		dataTable = tableName + "-data" // Table for everything that is not a user, such as share link lookups
+	}
 	protocol := strings.ToLower(env("PROTOCOL"))
 	debug := strings.ToLower(env("DEBUG"))
 	scyllaUrl := env("SCYLLA_URL")
@@ -601,6 +710,32 @@ func main() {
 	minutes, _ = strconv.ParseInt(env("MINUTES"), 10, 64) // Number of minutes the pre-signed urls will be good for
 	staticFiles := cacheStaticFiles()
 	maxPics, _ := strconv.Atoi(env("MAXPICS"))
+	if maxPics < 1 {
+		maxPics = 20 // Thumbnails on each page of a gallery
+	}
+	maxSelections := parseMaxSelections()                            // Most photos a client can pick on one shoot
+	grace := parseGracePeriod(env("DEADLINE_GRACE"))                 // How long after a deadline picks can still be changed
+	reminderOffsets := parseReminderOffsets(env("REMINDER_OFFSETS")) // How long before a deadline to send reminders
+	mailer := newMailer()
+	baseURL := strings.TrimSuffix(env("BASE_URL"), "/")                   // Public address of the site, used for links in emails
+	photographerEmail := env("PHOTOGRAPHER_EMAIL")                        // Where to send notifications about client picks
+	staffTwoFactor := strings.ToLower(env("REQUIRE_STAFF_2FA")) == "true" // Photographers and admins must use 2FA
+	loginIPLimit := parseRateLimit("LOGIN_IP", 20, 10)                    // Login attempts allowed from one IP
+	loginUserLimit := parseRateLimit("LOGIN_USER", 5, 2)                  // Login attempts allowed against one username
+	shareIPLimit := parseRateLimit("SHARE_IP", 10, 5)                     // Share link passwords that can be tried from one IP
+	guestIPLimit := parseRateLimit("GUEST_IP", 5, 2)                      // Guest sign ins allowed from one IP
+	guestLinkLimit := parseRateLimit("GUEST_LINK", 20, 5)                 // Guest sign ins allowed on one share link
+	lockoutPolicy := parseLockoutPolicy("LOCKOUT", 5, 15)                 // When to lock an account after failed logins
+	shareLockout := parseLockoutPolicy("SHARE_LOCKOUT", 20, 15)           // When a share link stops taking passwords
+	dummyHash, _, _ := hashPassword("not a real password")                // Compared against for unknown users to keep timing uniform
+	totpIssuer := env("TOTP_ISSUER")                                      // Name shown in authenticator apps
+	if totpIssuer == "" {
+		totpIssuer = "Client Photos"
+	}
+	cookies, err := newCookieCodec(env("COOKIE_KEYS")) // Signs and verifies cookie values
+	if err != nil {
+		log.Fatalf("Invalid COOKIE_KEYS: %v", err)
+	}
 
 	//Ensure valid protocol env entry
 	if protocol != "http" && protocol != "https" {
@@ -652,6 +787,11 @@ func main() {
 	//deleteInput := &dynamodb.DeleteTableInput{TableName: &tableName}
 	//svc.DeleteTable(deleteInput)
 
+	err = createDataTable(dataTable, svc)
+	if err != nil {
+		log.Fatalf("Something went wrong with the database connection: %v", err)
+	}
+
 	createInput := &dynamodb.CreateTableInput{
 		AttributeDefinitions: []*dynamodb.AttributeDefinition{
 			{
@@ -679,6 +819,29 @@ func main() {
 		log.Fatalf("Something went wrong with the database connection: %v", err)
 	}
 
+	// Deployments from before roles existed have no admin, ADMIN_USERNAME names a user to make one
+	if adminUsername := env("ADMIN_USERNAME"); adminUsername != "" {
+		err = promoteAdmin(tableName, adminUsername, svc)
+		if err != nil {
+			log.Printf("could not make ADMIN_USERNAME an admin: %v", err)
+		}
+	}
+
+	// Fill the storage index purges check for shared photos, only does anything on the first start
+	err = indexShootStorage(tableName, dataTable, svc)
+	if err != nil {
+		log.Printf("shoot storage index did not finish, it will be retried on the next start: %v", err)
+	}
+
+	// Move shoots created before shoot ids existed over to generated ids
+	err = migrateShootIDs(tableName, dataTable, svc)
+	if err != nil {
+		log.Printf("shoot id migration did not finish, it will be retried on the next start: %v", err)
+	}
+
+	// Lock shoots past their deadline and send reminder emails in the background
+	go autoProcessDeadlines(&svc, tableName, mailer, baseURL, reminderOffsets, grace)
+
 	// Create the Redis client
 	redisHost := fmt.Sprintf("%v:6379", env("REDIS_HOST"))
 	redClient := redis.NewClient(&redis.Options{
@@ -694,14 +857,51 @@ func main() {
 		log.Fatalf("Could not connect to Redis: %v", err)
 	}
 
+	// Capture times used to share one hash per bucket that was never pruned, they now have a key per photo
+	redClient.Del("captured:" + bucket)
+
+	// Reuse pre-signed urls until they get close to expiring
+	// Set PRESIGN_CACHE to "redis" to share them between servers
+	var presignRedis *redis.Client
+	if strings.ToLower(env("PRESIGN_CACHE")) == "redis" {
+		presignRedis = redClient
+	}
+	presigner := newPresignCache(client, bucket, minutes, presignRedis)
+
 	// Initialize Gin
-	gin.SetMode(gin.ReleaseMode)      // Turn off debugging mode
-	r := gin.Default()                // Initialize Gin
+	gin.SetMode(gin.ReleaseMode) // Turn off debugging mode
+	r := gin.Default()           // Initialize Gin
+
+	// Only proxies in TRUSTED_PROXIES can set the client IP with X-Forwarded-For
+	// Trusting everyone would let any client pick its own IP and step around every per IP rate limit
+	err = r.SetTrustedProxies(parseTrustedProxies(env("TRUSTED_PROXIES")))
+	if err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
+
 	r.Use(StaticHandler(staticFiles)) // Cache and serve static files
+	r.Use(CSRFHandler(cookies))       // Require a CSRF token on every state changing request
 	if debug == "true" {
 		r.Use(nocache.NoCache()) // Sets gin to disable browser caching
 	}
 
+	// Versioned JSON API and its OpenAPI document under /api/v1
+	api := &APIv1{
+		TableName:     tableName,
+		DataTable:     dataTable,
+		Bucket:        bucket,
+		Presigner:     presigner,
+		Grace:         grace,
+		MaxSelections: maxSelections,
+		BaseURL:       baseURL,
+		Svc:           &svc,
+		Client:        client,
+		Redis:         redClient,
+		Cookies:       cookies,
+		Mailer:        mailer,
+	}
+	api.register(r)
+
 	//Route for health check
 	r.GET("/ping", func(c *gin.Context) {
 
@@ -714,7 +914,7 @@ func main() {
 	// Route to request either login or home page for the user
 	r.GET("/", func(c *gin.Context) {
 
-		auth, _ := checkToken(c, redClient)
+		auth, _ := checkToken(c, redClient, cookies)
 
 		if !auth {
 			c.Redirect(302, "/login")
@@ -727,22 +927,19 @@ func main() {
 
 	r.GET("/home", func(c *gin.Context) {
 
-		auth, userName := checkToken(c, redClient)
+		auth, userName := checkToken(c, redClient, cookies)
 		if !auth {
 			c.Redirect(302, "/login")
 			return
 		}
 
-		shoots, err := getShoots(tableName, userName, svc)
+		user, err := getUser(tableName, userName, svc)
 		if err != nil {
 			abortWithError(http.StatusInternalServerError, err, c)
 			return
 		}
 
-		var shootsMap map[string]Shoot
-		_ = json.Unmarshal([]byte(shoots), &shootsMap)
-
-		tiles, err := generateTiles(shootsMap, bucket, client)
+		tiles, expires, err := generateTiles(user, presigner, grace)
 		if err != nil {
 			abortWithError(http.StatusInternalServerError, err, c)
 			return
@@ -756,74 +953,130 @@ func main() {
 		}
 
 		var final bytes.Buffer
-		err = tmpl.Execute(&final, tiles)
+		err = tmpl.Execute(&final, HomePage{Tiles: tiles, Order: tileOrder(user), Photographer: isPhotographer(user), CSRFToken: csrfToken(c)})
 		if err != nil {
 			log.Printf("Could not execute html template: %v", err)
 			c.Data(http.StatusInternalServerError, "text/plain", []byte("Could not parse template"))
 			return
 		}
 
-		// Allow browser to cache for up to one hour
-		c.Header("Cache-Control", "max-age=1800")
+		// Allow the browser to cache the page for as long as the thumbnail urls still work
+		c.Header("Cache-Control", presignedCacheControl(expires))
 		c.Data(http.StatusOK, "text/html", []byte(final.String()))
 
 	})
 
 	r.GET("/login", func(c *gin.Context) {
 
-		auth, _ := checkToken(c, redClient)
+		auth, _ := checkToken(c, redClient, cookies)
 
 		if auth {
 			c.Redirect(302, "/home")
 			return
 		}
 
-		html, _ := os.ReadFile("./static/html/login.html")
-		c.Data(http.StatusOK, "text/html", html)
+		servePage(c, "login.html")
 	})
 
 	r.GET("/shoot/:shoot/:page", func(c *gin.Context) {
 
-		auth, username := checkToken(c, redClient)
+		auth, username := checkToken(c, redClient, cookies)
 		if !auth {
 			c.Redirect(302, "/login")
 			return
 		}
 
-		page, _ := strconv.Atoi(c.Param("page"))
 		shoot := c.Param("shoot")
+		page, err := strconv.Atoi(c.Param("page"))
+		if err != nil || page < 0 {
+			c.Redirect(http.StatusFound, "/shoot/"+url.PathEscape(shoot)+"/0")
+			return
+		}
 
 		data, err := getUser(tableName, username, svc)
 		if err != nil {
 			log.Printf("could not get shoot data: %v", err)
 			abortWithError(http.StatusNotFound, err, c)
+			return
 		}
 
-		prefix := data.Shoots[shoot].Prefix
-		if prefix == "" {
+		shootData, exists := data.Shoots[shoot]
+		if !exists || shoot == placeholderShoot || (shootData.Prefix == "" && len(shootData.Files) == 0) {
 			log.Printf("shoot did not exist")
-			abortWithError(http.StatusNotFound, err, c)
+			abortWithError(http.StatusNotFound, errors.New("shoot does not exist"), c)
 			return
 		}
 
-		objects, err := getObjects(client, bucket, prefix, page, maxPics) // Get the prefix objects
+		view, err := parseGalleryView(c.Request.URL.Query())
 		if err != nil {
-			log.Print(err.Error())
 			abortWithError(http.StatusBadRequest, err, c)
+			return
 		}
-		urls, err := createUrls(client, bucket, objects, minutes) // Generate the pre-signed urls
+
+		all, err := shootThumbnails(redClient, client, bucket, shootData)
 		if err != nil {
 			log.Print(err.Error())
-			abortWithError(http.StatusBadRequest, err, c)
+			abortWithError(http.StatusInternalServerError, err, c)
+			return
+		}
+		favorites, err := viewFavorites(dataTable, username, shoot, view, svc)
+		if err != nil {
+			log.Print(err.Error())
+			abortWithError(http.StatusInternalServerError, err, c)
+			return
+		}
+		thumbnails := applyView(redClient, client, bucket, shootData, all, favorites, view)
+		totalPages := pageCount(len(thumbnails), maxPics)
+		if page >= totalPages {
+			location := "/shoot/" + url.PathEscape(shoot) + "/" + strconv.Itoa(totalPages-1)
+			if c.Request.URL.RawQuery != "" {
+				location += "?" + c.Request.URL.RawQuery
+			}
+			c.Redirect(http.StatusFound, location)
+			return
+		}
+		// The first batch is rendered here, the infinite scroll carries on from nextCursor
+		objects, nextCursor := galleryBatch(thumbnails, page*maxPics, maxPics)
+		previews, err := shootPreviews(redClient, client, bucket, shootData)
+		if err != nil {
+			log.Print(err.Error())
+			abortWithError(http.StatusInternalServerError, err, c)
+			return
+		}
+		urls, expires, err := createUrls(presigner, objects, previews) // Get the pre-signed urls
+		if err != nil {
+			log.Print(err.Error())
+			abortWithError(http.StatusInternalServerError, err, c)
+			return
 		}
-		html, err := createHTML(urls) // Generate the HTML
+		galleryPage := GalleryPage{
+			Thumbnails: urls,
+			Deadline:   data.Shoots[shoot].Deadline,
+			Locked:     selectionsLocked(data.Shoots[shoot], grace),
+			CSRFToken:  csrfToken(c),
+			ShootID:    shoot,
+			Page:       page,
+			Offset:     page * maxPics,
+			NextCursor: nextCursor,
+			Total:      len(thumbnails),
+			Downloads:  data.Shoots[shoot].Downloads,
+			Delivered:  data.Shoots[shoot].DeliveredAt != "",
+			View:       view,
+			Categories: shootCategories(shootData, all),
+		}
+		html, err := createHTML(galleryPage) // Generate the HTML
 		if err != nil {
 			log.Print(err.Error())
 			abortWithError(http.StatusBadRequest, err, c)
 		}
 
-		// Allow browser to cache for up to one hour
-		c.Header("Cache-Control", "max-age=1800")
+		// Allow the browser to cache the page for as long as the thumbnail urls still work
+		// Filtered pages change as the client picks and rates, so those are never cached
+		if view.changesWithSelections() {
+			c.Header("Cache-Control", "no-store")
+		} else {
+			c.Header("Cache-Control", presignedCacheControl(expires))
+		}
 		c.Header("Content-Encoding", "gzip")
 
 		// gzip the html
@@ -832,388 +1085,2320 @@ func main() {
 		c.Data(http.StatusOK, "text/html; charset-utf-8", htmlGzipBytes) // Send the gzip HTML to the client
 	})
 
-	r.GET("/shoot/:shoot", func(c *gin.Context) {
-		shoot := c.Param("shoot")
-		c.Redirect(http.StatusFound, "/shoot/"+shoot+"/0")
-	})
-
-	r.GET("/shoot/:shoot/getPicks", func(c *gin.Context) {
-
-		shootName := c.Param("shoot")
+	// Returns a batch of photos for the gallery's infinite scroll
+	// cursor comes from the gallery page or the previous batch, limit is how many photos to return
+	// sort, filter, category, stack and rating pick the same view of the shoot as on the gallery page
+	r.GET("/shoot/:shoot/photos", func(c *gin.Context) {
 
-		auth, username := checkToken(c, redClient)
+		auth, username := checkToken(c, redClient, cookies)
 		if !auth {
-			c.Redirect(302, "/login")
+			abortWithError(http.StatusUnauthorized, errors.New("not logged in"), c)
 			return
 		}
 
-		// Create an Item struct to hold the retrieved data
-		var picks Picks
-
-		key := map[string]*dynamodb.AttributeValue{
-			"username": {
-				S: aws.String(username),
-			},
-		}
-
-		// Define which attribute(s) you want to retrieve
-		projectionExpression := fmt.Sprintf("shoots.%v.picks", shootName)
+		shootID := c.Param("shoot")
 
-		// Create a GetItemInput object
-		input := &dynamodb.GetItemInput{
-			TableName:            aws.String(tableName),
-			Key:                  key,
-			ProjectionExpression: aws.String(projectionExpression),
+		user, err := getUser(tableName, username, svc)
+		if err != nil {
+			log.Printf("could not get user: %v : %v", username, err)
+			abortWithError(http.StatusNotFound, err, c)
+			return
 		}
 
-		// Perform the GetItem operation
-		result, err := svc.GetItem(input)
-		if err != nil {
-			fmt.Println("Error getting item:", err)
+		shoot, exists := user.Shoots[shootID]
+		if !exists || shootID == placeholderShoot {
+			abortWithError(http.StatusNotFound, errors.New("shoot does not exist"), c)
 			return
 		}
 
-		// Check if the item was found
-		if result.Item != nil {
-			// Unmarshal the DynamoDB item into the Item struct
-			err := dynamodbattribute.UnmarshalMap(result.Item["shoots"].M[shootName].M["picks"].M, &picks)
-			if err != nil {
-				fmt.Println("Error unmarshalling item:", err)
+		limit := maxPics
+		if value := c.Query("limit"); value != "" {
+			limit, err = strconv.Atoi(value)
+			if err != nil || limit < 1 || limit > maxGalleryBatch {
+				abortWithError(http.StatusBadRequest, fmt.Errorf("limit must be a whole number from 1 to %v", maxGalleryBatch), c)
 				return
 			}
-		} else {
-			fmt.Println("Item not found")
 		}
 
-		picksJSON, _ := json.Marshal(picks)
-
-		c.Data(http.StatusOK, "application/json", picksJSON)
-	})
-
-	r.GET("/shoot/:shoot/updatePicksCookie", func(c *gin.Context) {
-
-		shootName := c.Param("shoot")
-
-		auth, username := checkToken(c, redClient)
-		if !auth {
-			c.Redirect(302, "/login")
+		view, err := parseGalleryView(c.Request.URL.Query())
+		if err != nil {
+			abortWithError(http.StatusBadRequest, err, c)
 			return
 		}
 
-		// Create an Item struct to hold the retrieved data
-		var picks Picks
-
-		key := map[string]*dynamodb.AttributeValue{
-			"username": {
-				S: aws.String(username),
-			},
+		thumbnails, err := shootThumbnails(redClient, client, bucket, shoot)
+		if err != nil {
+			log.Print(err.Error())
+			abortWithError(http.StatusInternalServerError, err, c)
+			return
 		}
-
-		// Define which attribute(s) you want to retrieve
-		projectionExpression := fmt.Sprintf("shoots.%v.picks", shootName)
-
-		// Create a GetItemInput object
-		input := &dynamodb.GetItemInput{
-			TableName:            aws.String(tableName),
-			Key:                  key,
-			ProjectionExpression: aws.String(projectionExpression),
+		favorites, err := viewFavorites(dataTable, username, shootID, view, svc)
+		if err != nil {
+			log.Print(err.Error())
+			abortWithError(http.StatusInternalServerError, err, c)
+			return
 		}
+		thumbnails = applyView(redClient, client, bucket, shoot, thumbnails, favorites, view)
 
-		// Perform the GetItem operation
-		result, err := svc.GetItem(input)
+		start, err := cursorStart(thumbnails, c.Query("cursor"))
 		if err != nil {
-			fmt.Println("Error getting item:", err)
+			abortWithError(http.StatusBadRequest, err, c)
 			return
 		}
 
-		// Check if the item was found
-		if result.Item != nil {
-			// Unmarshal the DynamoDB item into the Item struct
-			err := dynamodbattribute.UnmarshalMap(result.Item["shoots"].M[shootName].M["picks"].M, &picks)
-			if err != nil {
-				fmt.Println("Error unmarshalling item:", err)
-				return
-			}
-		} else {
-			fmt.Println("Item not found")
+		previews, err := shootPreviews(redClient, client, bucket, shoot)
+		if err != nil {
+			log.Print(err.Error())
+			abortWithError(http.StatusInternalServerError, err, c)
+			return
+		}
+		keys, nextCursor := galleryBatch(thumbnails, start, limit)
+		urls, expires, err := createUrls(presigner, keys, previews)
+		if err != nil {
+			log.Print(err.Error())
+			abortWithError(http.StatusInternalServerError, err, c)
+			return
 		}
 
-		picksJSON, _ := json.Marshal(picks)
+		picked := make(map[string]bool)
+		for _, key := range shoot.Picks.Picks {
+			picked[key] = true
+		}
+		comments := make(map[string]int)
+		for _, comment := range shoot.Comments {
+			comments[comment.Photo]++
+		}
 
-		// Create a new cookie
-		picksCookie := &http.Cookie{
-			Name:     "picks",
-			Value:    string(picksJSON),
-			Secure:   true,
-			HttpOnly: false,
+		batch := GalleryBatch{
+			Photos:     make([]GalleryPhoto, 0, len(keys)),
+			NextCursor: nextCursor,
+			Total:      len(thumbnails),
+			Expires:    expires.Format(time.RFC3339),
+		}
+		for i, url := range urls {
+			photo := url.Key
+			batch.Photos = append(batch.Photos, GalleryPhoto{
+				Key:        photo,
+				Position:   start + i,
+				Renditions: map[string]string{"thumbnail": url.Url, "preview": url.Preview},
+				Picked:     picked[photo],
+				Comments:   comments[photo],
+				Rating:     shoot.Ratings[photo].Rating,
+				Label:      shoot.Ratings[photo].Label,
+			})
 		}
 
-		weekInSeconds := 604800
-		c.SetCookie(picksCookie.Name, picksCookie.Value, weekInSeconds, "/", c.Request.Host, picksCookie.Secure, picksCookie.HttpOnly)
+		if view.changesWithSelections() {
+			c.Header("Cache-Control", "no-store")
+		} else {
+			c.Header("Cache-Control", presignedCacheControl(expires))
+		}
+		c.JSON(http.StatusOK, batch)
+	})
 
-		c.Data(http.StatusOK, "application/json", picksJSON)
+	r.GET("/shoot/:shoot", func(c *gin.Context) {
+		shoot := c.Param("shoot")
+		c.Redirect(http.StatusFound, "/shoot/"+shoot+"/0")
 	})
 
-	r.POST("/shoot/add/:shootName", func(c *gin.Context) {
+	// Downloads a zip of the originals in a shoot
+	// scope is "picks" for the photos the client picked, "delivered" for every photo once the shoot has been
+	// delivered, or "category" for one folder of the shoot, named by the category query parameter
+	r.GET("/shoot/:shoot/download", func(c *gin.Context) {
 
-		auth, username := checkToken(c, redClient)
+		shootID := c.Param("shoot")
+
+		auth, username := checkToken(c, redClient, cookies)
+		if !auth {
+			c.Redirect(302, "/login")
+			return
+		}
+
+		user, err := getUser(tableName, username, svc)
+		if err != nil {
+			log.Printf("could not get user: %v : %v", username, err)
+			abortWithError(http.StatusNotFound, err, c)
+			return
+		}
+
+		shoot, exists := user.Shoots[shootID]
+		if !exists || shootID == placeholderShoot {
+			abortWithError(http.StatusNotFound, errors.New("shoot does not exist"), c)
+			return
+		}
+
+		scope := c.DefaultQuery("scope", downloadPicks)
+		entries, err := planDownload(client, bucket, shoot, scope, c.Query("category"))
+		var downloadErr *DownloadError
+		if errors.As(err, &downloadErr) {
+			abortWithError(downloadErr.Status, downloadErr, c)
+			return
+		}
+		if err != nil {
+			log.Printf("could not plan download of %v for %v: %v", shootID, username, err)
+			abortWithError(http.StatusInternalServerError, err, c)
+			return
+		}
+
+		err = streamDownload(c, client, bucket, shootID, scope, entries)
+		if err != nil {
+			log.Printf("download of %v for %v failed: %v", shootID, username, err)
+		}
+	})
+
+	r.GET("/shoot/:shoot/getPicks", func(c *gin.Context) {
+
+		shootID := c.Param("shoot")
+
+		auth, username := checkToken(c, redClient, cookies)
+		if !auth {
+			c.Redirect(302, "/login")
+			return
+		}
+
+		picks, err := getPicks(tableName, username, shootID, svc)
+		if err != nil {
+			log.Printf("could not get picks for %v: %v", shootID, err)
+			abortWithError(http.StatusInternalServerError, err, c)
+			return
+		}
+
+		picksJSON, _ := json.Marshal(picks)
+
+		c.Data(http.StatusOK, "application/json", picksJSON)
+	})
+
+	// Returns the star ratings and color labels on a shoot's photos, by photo key
+	r.GET("/shoot/:shoot/ratings", func(c *gin.Context) {
+
+		shootID := c.Param("shoot")
+
+		auth, username := checkToken(c, redClient, cookies)
+		if !auth {
+			abortWithError(http.StatusUnauthorized, errors.New("not logged in"), c)
+			return
+		}
+
+		user, err := getUser(tableName, username, svc)
+		if err != nil {
+			log.Printf("could not get user: %v : %v", username, err)
+			abortWithError(http.StatusNotFound, err, c)
+			return
+		}
+
+		shoot, exists := user.Shoots[shootID]
+		if !exists || shootID == placeholderShoot {
+			abortWithError(http.StatusNotFound, errors.New("shoot does not exist"), c)
+			return
+		}
+
+		ratings := shoot.Ratings
+		if ratings == nil {
+			ratings = map[string]PhotoRating{}
+		}
+
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusOK, ratings)
+	})
+
+	// Sets the star rating and color label of one photo
+	// A rating of 0 with no label clears them
+	r.POST("/shoot/:shoot/rate", func(c *gin.Context) {
+
+		shootID := c.Param("shoot")
+
+		auth, username := checkToken(c, redClient, cookies)
+		if !auth {
+			abortWithError(http.StatusUnauthorized, errors.New("not logged in"), c)
+			return
+		}
+
+		user, err := getUser(tableName, username, svc)
+		if err != nil {
+			log.Printf("could not get user: %v : %v", username, err)
+			abortWithError(http.StatusNotFound, err, c)
+			return
+		}
+
+		shoot, exists := user.Shoots[shootID]
+		if !exists || shootID == placeholderShoot {
+			abortWithError(http.StatusNotFound, errors.New("shoot does not exist"), c)
+			return
+		}
+
+		if !canRate(user, shoot, grace) {
+			abortWithError(http.StatusForbidden, errors.New("selections for this shoot are locked"), c)
+			return
+		}
+
+		var body RatingRequest
+		err = c.ShouldBindJSON(&body)
+		if err != nil {
+			abortWithError(http.StatusBadRequest, errors.New("body must be a JSON object with a photo key, rating and label"), c)
+			return
+		}
+
+		rating, err := checkRating(PhotoRating{Rating: body.Rating, Label: body.Label})
+		if err != nil {
+			abortWithError(http.StatusBadRequest, err, c)
+			return
+		}
+
+		available, err := shootPhotoKeys(redClient, client, bucket, shoot)
+		if err != nil {
+			log.Printf("could not list photos in %v: %v", shootID, err)
+			abortWithError(http.StatusInternalServerError, err, c)
+			return
+		}
+		if !available[body.Key] {
+			abortWithError(http.StatusUnprocessableEntity, errors.New("photo is not in this shoot"), c)
+			return
+		}
+
+		err = setPhotoRating(tableName, username, shootID, body.Key, rating, svc)
+		if err != nil {
+			log.Printf("could not rate %v in %v: %v", body.Key, shootID, err)
+			abortWithError(http.StatusInternalServerError, err, c)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status": "success",
+			"rating": rating,
+		})
+	})
+
+	// Adds a shoot to the logged in photographer's account
+	// shootName is the display name, the shoot is stored under a generated id which is sent back
+	r.POST("/shoot/add/:shootName", func(c *gin.Context) {
+
+		auth, username := checkToken(c, redClient, cookies)
 		if !auth {
 			c.Redirect(302, "/login")
 			return
 		}
 
-		shootName := c.Param("shootName")
-		body, err := io.ReadAll(c.Request.Body)
+		user, err := getUser(tableName, username, svc)
+		if err != nil {
+			log.Printf("could not get user: %v : %v", username, err)
+			abortWithError(http.StatusNotFound, err, c)
+			return
+		}
+		// The shoot's prefix decides which photos it shows and downloads, so clients can not make their own
+		if !isPhotographer(user) {
+			abortWithError(http.StatusForbidden, errors.New("only photographers can create shoots"), c)
+			return
+		}
+
+		shootName := strings.TrimSpace(c.Param("shootName"))
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			log.Printf("could not read request body: %v", err)
+			abortWithError(http.StatusBadRequest, err, c)
+			return
+		}
+
+		// The body is optional, the name in the url is all a shoot needs
+		var request ShootRequest
+		if len(bytes.TrimSpace(body)) > 0 {
+			err = json.Unmarshal(body, &request)
+			if err != nil {
+				abortWithError(http.StatusBadRequest, errors.New("body must be a JSON shoot"), c)
+				return
+			}
+		}
+		request.Name = shootName
+
+		shoot, err := newShoot(request)
+		if err != nil {
+			abortWithError(http.StatusBadRequest, err, c)
+			return
+		}
+		shootName = shoot.Name
+
+		err = recordShootStorage(dataTable, username, shoot, svc)
+		if err != nil {
+			log.Println(err)
+			abortWithError(http.StatusInternalServerError, errors.New("could not create shoot"), c)
+			return
+		}
+
+		shootID := newShootID(shootName, user.Shoots)
+		err = addShoot(tableName, username, shootID, shoot, svc)
+		if err != nil {
+			log.Printf("Could not add shoot: %v", err)
+			abortWithError(http.StatusBadRequest, err, c)
+			return
+		}
+		err = deletePlaceHolder(svc, username, tableName)
+		if err != nil {
+			log.Println(err)
+		}
+
+		// Let the client know their gallery is ready
+		notify(mailer, user.Email, "Your photos from "+shootName+" are ready", "shoot_ready.html", newEmailData(baseURL, user, shootID, shoot))
+
+		c.JSON(http.StatusOK, gin.H{
+			"status": "success",
+			"id":     shootID,
+		})
+	})
+
+	// Renames one of the logged in user's shoots, changes its date or sets the photo on its tile
+	// Fields left out of the body are not changed
+	r.POST("/shoot/:shoot/update", func(c *gin.Context) {
+
+		auth, username := checkToken(c, redClient, cookies)
+		if !auth {
+			abortWithError(http.StatusUnauthorized, errors.New("not logged in"), c)
+			return
+		}
+
+		shootID := c.Param("shoot")
+
+		user, err := getUser(tableName, username, svc)
+		if err != nil {
+			log.Printf("could not get user: %v : %v", username, err)
+			abortWithError(http.StatusNotFound, err, c)
+			return
+		}
+
+		shoot, exists := user.Shoots[shootID]
+		if !exists || shootID == placeholderShoot {
+			abortWithError(http.StatusNotFound, errors.New("shoot does not exist"), c)
+			return
+		}
+
+		var update ShootUpdate
+		err = c.ShouldBindJSON(&update)
+		if err != nil {
+			abortWithError(http.StatusBadRequest, errors.New("send a name, date or cover to change"), c)
+			return
+		}
+
+		shoot, err = updateShoot(tableName, redClient, client, bucket, username, shootID, shoot, update, isPhotographer(user), grace, svc)
+		var shootErr *ShootError
+		if errors.As(err, &shootErr) {
+			abortWithError(shootErr.Status, shootErr, c)
+			return
+		}
+		if err != nil {
+			log.Printf("could not update shoot %v for %v: %v", shootID, username, err)
+			abortWithError(http.StatusInternalServerError, err, c)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status": "success",
+			"shoot":  newShootResource(shootID, shoot, grace),
+		})
+	})
+
+	// Deletes one of the logged in user's shoots
+	// Photographers only, the same as DELETE /api/v1/shoots/:id. purge also deletes the photos stored for it
+	r.POST("/shoot/:shoot/delete", func(c *gin.Context) {
+
+		auth, username := checkToken(c, redClient, cookies)
+		if !auth {
+			abortWithError(http.StatusUnauthorized, errors.New("not logged in"), c)
+			return
+		}
+
+		shootID := c.Param("shoot")
+
+		var request struct {
+			Purge bool `json:"purge"`
+		}
+		err := c.ShouldBindJSON(&request)
+		if err != nil {
+			abortWithError(http.StatusBadRequest, errors.New("body must be JSON"), c)
+			return
+		}
+
+		user, err := getUser(tableName, username, svc)
+		if err != nil {
+			log.Printf("could not get user: %v : %v", username, err)
+			abortWithError(http.StatusNotFound, err, c)
+			return
+		}
+		if !isPhotographer(user) {
+			abortWithError(http.StatusForbidden, errors.New("only photographers can delete shoots"), c)
+			return
+		}
+
+		shoot, exists := user.Shoots[shootID]
+		if !exists || shootID == placeholderShoot {
+			abortWithError(http.StatusNotFound, errors.New("shoot does not exist"), c)
+			return
+		}
+
+		deleted, err := deleteShoot(tableName, dataTable, redClient, client, bucket, username, shootID, shoot, request.Purge, svc)
+		var shootErr *ShootError
+		if errors.As(err, &shootErr) {
+			abortWithError(shootErr.Status, shootErr, c)
+			return
+		}
+		if err != nil {
+			log.Printf("could not delete shoot %v for %v: %v", shootID, username, err)
+			abortWithError(http.StatusInternalServerError, err, c)
+			return
+		}
+
+		log.Printf("%v deleted shoot %v along with %v stored objects", username, shootID, deleted)
+
+		c.JSON(http.StatusOK, gin.H{
+			"status":  "success",
+			"deleted": deleted,
+		})
+	})
+
+	// Sets how the logged in user's home page orders their shoots
+	r.POST("/shoots/order", func(c *gin.Context) {
+
+		auth, username := checkToken(c, redClient, cookies)
+		if !auth {
+			abortWithError(http.StatusUnauthorized, errors.New("not logged in"), c)
+			return
+		}
+
+		var order ShootOrder
+		err := c.ShouldBindJSON(&order)
+		if err != nil {
+			abortWithError(http.StatusBadRequest, errors.New("order needs to be date, name or custom"), c)
+			return
+		}
+
+		user, err := getUser(tableName, username, svc)
+		if err != nil {
+			log.Printf("could not get user: %v : %v", username, err)
+			abortWithError(http.StatusNotFound, err, c)
+			return
+		}
+
+		order, err = checkShootOrder(order, user.Shoots)
+		var shootErr *ShootError
+		if errors.As(err, &shootErr) {
+			abortWithError(shootErr.Status, shootErr, c)
+			return
+		}
+
+		err = setShootOrder(tableName, username, order, svc)
+		if err != nil {
+			log.Printf("could not order shoots for %v: %v", username, err)
+			abortWithError(http.StatusInternalServerError, err, c)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status": "success",
+		})
+	})
+
+	// Marks a shoot as delivered and lets the client know their photos are ready
+	// Photographers only, since delivering releases every original for download
+	// owner names the client whose shoot it is, defaulting to the photographer's own account
+	r.POST("/shoot/deliver/:shootID", func(c *gin.Context) {
+
+		auth, username := checkToken(c, redClient, cookies)
+		if !auth {
+			c.Redirect(302, "/login")
+			return
+		}
+
+		shootID := c.Param("shootID")
+
+		photographer, err := getUser(tableName, username, svc)
+		if err != nil {
+			log.Printf("could not get user: %v : %v", username, err)
+			abortWithError(http.StatusNotFound, err, c)
+			return
+		}
+		if !isPhotographer(photographer) {
+			abortWithError(http.StatusForbidden, errors.New("only photographers can deliver shoots"), c)
+			return
+		}
+
+		user := photographer
+		if owner := strings.ToLower(c.Query("owner")); owner != "" && owner != username {
+			user, err = getUser(tableName, owner, svc)
+			if err != nil {
+				abortWithError(http.StatusNotFound, errors.New("user does not exist"), c)
+				return
+			}
+		}
+
+		shoot, exists := user.Shoots[shootID]
+		if !exists {
+			abortWithError(http.StatusNotFound, errors.New("shoot does not exist"), c)
+			return
+		}
+
+		shoot.DeliveredAt = time.Now().Format(time.RFC3339)
+		err = setShootProperty(tableName, user.Username, shootID, "deliveredAt", &dynamodb.AttributeValue{S: aws.String(shoot.DeliveredAt)}, svc)
+		if err != nil {
+			log.Printf("could not mark shoot %v as delivered: %v", shootID, err)
+			abortWithError(http.StatusInternalServerError, err, c)
+			return
+		}
+
+		notify(mailer, user.Email, "Your photos from "+shootDisplayName(shootID, shoot)+" have been delivered", "delivered.html", newEmailData(baseURL, user, shootID, shoot))
+
+		c.JSON(http.StatusOK, gin.H{
+			"status": "success",
+		})
+	})
+
+	// Called when the user sends their shoot picks in via the front end
+	r.POST("/shoot/:shoot/:page/savePicks", func(c *gin.Context) {
+
+		auth, username := checkToken(c, redClient, cookies)
+		if !auth {
+			c.Redirect(302, "/login")
+			return
+		}
+
+		shoot := c.Param("shoot")
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			log.Printf("could not read request body: %v", err)
+			abortWithError(http.StatusBadRequest, err, c)
+			return
+		}
+
+		user, err := getUser(tableName, username, svc)
+		if err != nil {
+			log.Printf("could not get user: %v : %v", username, err)
+			abortWithError(http.StatusNotFound, err, c)
+			return
+		}
+
+		shootData, exists := user.Shoots[shoot]
+		if !exists || shoot == placeholderShoot {
+			abortWithError(http.StatusNotFound, errors.New("shoot does not exist"), c)
+			return
+		}
+
+		// Picks can not be changed once the deadline and grace period have passed
+		if selectionsLocked(shootData, grace) {
+			abortWithError(http.StatusForbidden, errors.New("selections for this shoot are locked"), c)
+			return
+		}
+
+		var submitted Picks
+		err = json.Unmarshal(body, &submitted)
+		if err != nil {
+			log.Printf("could not unmarshal picks: %v", err)
+			abortWithError(http.StatusBadRequest, errors.New("picks must be a JSON object with a list of photo keys"), c)
+			return
+		}
+
+		picks, err := checkPicks(redClient, client, bucket, shootData, submitted.Picks, maxSelections)
+		var picksErr *PicksError
+		if errors.As(err, &picksErr) {
+			c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{
+				"status":   picksErr.Error(),
+				"rejected": picksErr.Rejected,
+				"limit":    picksErr.Limit,
+				"count":    picksErr.Count,
+			})
+			return
+		}
+		if err != nil {
+			log.Printf("could not check picks for %v: %v", shoot, err)
+			abortWithError(http.StatusInternalServerError, err, c)
+			return
+		}
+
+		err = updatePicks(tableName, username, shoot, picks, svc)
+		if err != nil {
+			log.Printf("could not edit picks: %v", err)
+			abortWithError(http.StatusInternalServerError, err, c)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status": "success",
+			"picks":  picks,
+		})
+
+	})
+
+	// Called when the user is done making their picks
+	// Lets both the client and the photographer know the picks are in
+	r.POST("/shoot/:shoot/submit", func(c *gin.Context) {
+
+		auth, username := checkToken(c, redClient, cookies)
+		if !auth {
+			c.Redirect(302, "/login")
+			return
+		}
+
+		shootID := c.Param("shoot")
+
+		user, err := getUser(tableName, username, svc)
+		if err != nil {
+			log.Printf("could not get user: %v : %v", username, err)
+			abortWithError(http.StatusNotFound, err, c)
+			return
+		}
+
+		shoot, exists := user.Shoots[shootID]
+		if !exists {
+			abortWithError(http.StatusNotFound, errors.New("shoot does not exist"), c)
+			return
+		}
+
+		if selectionsLocked(shoot, grace) {
+			abortWithError(http.StatusForbidden, errors.New("selections for this shoot are locked"), c)
+			return
+		}
+
+		shoot.SubmittedAt = time.Now().Format(time.RFC3339)
+		err = setShootProperty(tableName, username, shootID, "submittedAt", &dynamodb.AttributeValue{S: aws.String(shoot.SubmittedAt)}, svc)
+		if err != nil {
+			log.Printf("could not mark picks for %v as submitted: %v", shootID, err)
+			abortWithError(http.StatusInternalServerError, err, c)
+			return
+		}
+
+		emailData := newEmailData(baseURL, user, shootID, shoot)
+		notify(mailer, user.Email, "We got your selections for "+shootDisplayName(shootID, shoot), "picks_submitted.html", emailData)
+		notify(mailer, photographerEmail, user.Username+" submitted picks for "+shootDisplayName(shootID, shoot), "picks_received.html", emailData)
+
+		c.JSON(http.StatusOK, gin.H{
+			"status": "success",
+		})
+	})
+
+	// Returns the photos guests on share links favorited, most favorited first, and how many guests there are
+	r.GET("/shoot/:shoot/favorites", func(c *gin.Context) {
+
+		auth, username := checkToken(c, redClient, cookies)
+		if !auth {
+			abortWithError(http.StatusUnauthorized, errors.New("not logged in"), c)
+			return
+		}
+
+		shootID := c.Param("shoot")
+
+		user, err := getUser(tableName, username, svc)
+		if err != nil {
+			log.Printf("could not get user: %v : %v", username, err)
+			abortWithError(http.StatusNotFound, err, c)
+			return
+		}
+
+		if _, exists := user.Shoots[shootID]; !exists || shootID == placeholderShoot {
+			abortWithError(http.StatusNotFound, errors.New("shoot does not exist"), c)
+			return
+		}
+
+		favorites, err := shootFavorites(dataTable, username, shootID, svc)
+		if err != nil {
+			log.Printf("could not get favorites on %v: %v", shootID, err)
+			abortWithError(http.StatusInternalServerError, err, c)
+			return
+		}
+
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusOK, gin.H{
+			"photos": favoriteResources(favorites),
+			"guests": len(favorites),
+		})
+	})
+
+	// Lists the share links on one of the logged in user's shoots
+	r.GET("/shoot/:shoot/shares", func(c *gin.Context) {
+
+		auth, username := checkToken(c, redClient, cookies)
+		if !auth {
+			abortWithError(http.StatusUnauthorized, errors.New("not logged in"), c)
+			return
+		}
+
+		shootID := c.Param("shoot")
+
+		user, err := getUser(tableName, username, svc)
+		if err != nil {
+			log.Printf("could not get user: %v : %v", username, err)
+			abortWithError(http.StatusNotFound, err, c)
+			return
+		}
+
+		shoot, exists := user.Shoots[shootID]
+		if !exists || shootID == placeholderShoot {
+			abortWithError(http.StatusNotFound, errors.New("shoot does not exist"), c)
+			return
+		}
+
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusOK, gin.H{
+			"shares": shareResources(shoot),
+		})
+	})
+
+	// Makes a share link for one of the logged in user's shoots
+	// The link is in the response and can not be shown again
+	r.POST("/shoot/:shoot/shares", func(c *gin.Context) {
+
+		auth, username := checkToken(c, redClient, cookies)
+		if !auth {
+			abortWithError(http.StatusUnauthorized, errors.New("not logged in"), c)
+			return
+		}
+
+		shootID := c.Param("shoot")
+
+		user, err := getUser(tableName, username, svc)
+		if err != nil {
+			log.Printf("could not get user: %v : %v", username, err)
+			abortWithError(http.StatusNotFound, err, c)
+			return
+		}
+
+		shoot, exists := user.Shoots[shootID]
+		if !exists || shootID == placeholderShoot {
+			abortWithError(http.StatusNotFound, errors.New("shoot does not exist"), c)
+			return
+		}
+
+		var request ShareRequest
+		err = c.ShouldBindJSON(&request)
+		if err != nil {
+			abortWithError(http.StatusBadRequest, errors.New("share link needs a name and permissions"), c)
+			return
+		}
+
+		link, err := newShareLink(request, username, shoot, time.Now())
+		var shareErr *ShareError
+		if errors.As(err, &shareErr) {
+			abortWithError(shareErr.Status, shareErr, c)
+			return
+		}
+		if err != nil {
+			log.Printf("could not make share link for %v: %v", shootID, err)
+			abortWithError(http.StatusInternalServerError, err, c)
+			return
+		}
+
+		token, id, err := addShareLink(tableName, dataTable, username, shootID, link, svc)
+		if err != nil {
+			log.Printf("could not make share link for %v: %v", shootID, err)
+			abortWithError(http.StatusInternalServerError, err, c)
+			return
+		}
+
+		log.Printf("%v made share link %v for %v with permissions %v", username, id, shootID, link.Permissions)
+
+		share := newShareResource(id, link)
+		share.URL = shareURL(baseURL, token)
+
+		c.JSON(http.StatusOK, gin.H{
+			"status": "success",
+			"share":  share,
+		})
+	})
+
+	// Revokes a share link on one of the logged in user's shoots
+	r.POST("/shoot/:shoot/shares/:id/revoke", func(c *gin.Context) {
+
+		auth, username := checkToken(c, redClient, cookies)
+		if !auth {
+			abortWithError(http.StatusUnauthorized, errors.New("not logged in"), c)
+			return
+		}
+
+		shootID, id := c.Param("shoot"), c.Param("id")
+
+		revoked, err := revokeShareLink(tableName, dataTable, username, shootID, id, svc)
+		if err != nil {
+			log.Printf("could not revoke share link %v on %v: %v", id, shootID, err)
+			abortWithError(http.StatusInternalServerError, err, c)
+			return
+		}
+		if !revoked {
+			abortWithError(http.StatusNotFound, errors.New("share link does not exist"), c)
+			return
+		}
+
+		log.Printf("%v revoked share link %v on %v", username, id, shootID)
+
+		c.JSON(http.StatusOK, gin.H{
+			"status": "success",
+		})
+	})
+
+	// The gallery people see through a share link, without logging in
+	// Links with a password show a form for it until it has been entered in this browser
+	r.GET("/s/:token", func(c *gin.Context) {
+
+		owner, shootID, shoot, id, link, err := resolveShareLink(tableName, dataTable, c.Param("token"), svc)
+		var shareErr *ShareError
+		if errors.As(err, &shareErr) {
+			abortWithError(shareErr.Status, shareErr, c)
+			return
+		}
+
+		page := SharePage{
+			Token:     c.Param("token"),
+			Name:      shootDisplayName(shootID, shoot),
+			Locked:    !shareUnlocked(c, cookies, id, link),
+			Download:  shareAllows(link, shareDownload) && shoot.Downloads && shoot.DeliveredAt != "",
+			Favorites: shareAllows(link, shareFavorite),
+			CSRFToken: csrfToken(c),
+		}
+
+		if !page.Locked {
+			thumbnails, err := shootThumbnails(redClient, client, bucket, shoot)
+			if err != nil {
+				log.Print(err.Error())
+				abortWithError(http.StatusInternalServerError, err, c)
+				return
+			}
+
+			objects, nextCursor := galleryBatch(thumbnails, 0, maxPics)
+			previews, err := shootPreviews(redClient, client, bucket, shoot)
+			if err != nil {
+				log.Print(err.Error())
+				abortWithError(http.StatusInternalServerError, err, c)
+				return
+			}
+			page.Thumbnails, _, err = createUrls(presigner, objects, previews) // Photos are still pre-signed, the link never exposes the bucket
+			if err != nil {
+				log.Print(err.Error())
+				abortWithError(http.StatusInternalServerError, err, c)
+				return
+			}
+			page.NextCursor = nextCursor
+			page.Total = len(thumbnails)
+
+			err = recordShareView(tableName, owner.Username, shootID, id, svc)
+			if err != nil {
+				log.Print(err.Error())
+			}
+		}
+
+		html, err := createShareHTML(page)
+		if err != nil {
+			abortWithError(http.StatusInternalServerError, err, c)
+			return
+		}
+
+		// Every load is a view, and the token in the url should not leak to other sites
+		c.Header("Cache-Control", "no-store")
+		c.Header("Referrer-Policy", "no-referrer")
+		c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(html))
+	})
+
+	// Checks the password of a share link and remembers it in this browser
+	r.POST("/s/:token/unlock", func(c *gin.Context) {
+
+		if !allowRequest(redClient, "share-ip:"+c.ClientIP(), shareIPLimit) {
+			abortWithError(http.StatusTooManyRequests, errors.New("too many attempts, please try again later"), c)
+			return
+		}
+
+		_, _, _, id, link, err := resolveShareLink(tableName, dataTable, c.Param("token"), svc)
+		var shareErr *ShareError
+		if errors.As(err, &shareErr) {
+			abortWithError(shareErr.Status, shareErr, c)
+			return
+		}
+
+		if link.PasswordHash != "" {
+			if shareLockedOut(redClient, id, shareLockout) {
+				abortWithError(http.StatusTooManyRequests, errors.New("too many attempts, please try again later"), c)
+				return
+			}
+
+			var body struct {
+				Password string `json:"password"`
+			}
+			_ = c.ShouldBindJSON(&body)
+
+			if !verifyPassword(link.PasswordHash, body.Password, link.Salt) {
+				recordShareFailure(redClient, id, shareLockout)
+				abortWithError(http.StatusUnauthorized, errors.New("wrong password"), c)
+				return
+			}
+
+			err = unlockShare(c, cookies, id, link)
+			if err != nil {
+				log.Printf("could not unlock share link %v: %v", id, err)
+				abortWithError(http.StatusInternalServerError, err, c)
+				return
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status": "success",
+		})
+	})
+
+	// Returns a batch of photos for the infinite scroll of a share link's gallery
+	// Only the photos are sent, the client's picks, ratings and comments stay private
+	r.GET("/s/:token/photos", func(c *gin.Context) {
+
+		_, _, shoot, id, link, err := resolveShareLink(tableName, dataTable, c.Param("token"), svc)
+		var shareErr *ShareError
+		if errors.As(err, &shareErr) {
+			abortWithError(shareErr.Status, shareErr, c)
+			return
+		}
+		if !shareUnlocked(c, cookies, id, link) {
+			abortWithError(http.StatusUnauthorized, errors.New("this link needs a password"), c)
+			return
+		}
+
+		thumbnails, err := shootThumbnails(redClient, client, bucket, shoot)
+		if err != nil {
+			log.Print(err.Error())
+			abortWithError(http.StatusInternalServerError, err, c)
+			return
+		}
+
+		start, err := cursorStart(thumbnails, c.Query("cursor"))
+		if err != nil {
+			abortWithError(http.StatusBadRequest, err, c)
+			return
+		}
+
+		previews, err := shootPreviews(redClient, client, bucket, shoot)
+		if err != nil {
+			log.Print(err.Error())
+			abortWithError(http.StatusInternalServerError, err, c)
+			return
+		}
+		keys, nextCursor := galleryBatch(thumbnails, start, maxPics)
+		urls, expires, err := createUrls(presigner, keys, previews)
+		if err != nil {
+			log.Print(err.Error())
+			abortWithError(http.StatusInternalServerError, err, c)
+			return
+		}
+
+		batch := GalleryBatch{
+			Photos:     make([]GalleryPhoto, 0, len(keys)),
+			NextCursor: nextCursor,
+			Total:      len(thumbnails),
+			Expires:    expires.Format(time.RFC3339),
+		}
+		for i, url := range urls {
+			batch.Photos = append(batch.Photos, GalleryPhoto{
+				Key:        url.Key,
+				Position:   start + i,
+				Renditions: map[string]string{"thumbnail": url.Url, "preview": url.Preview},
+			})
+		}
+
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusOK, batch)
+	})
+
+	// Signs a guest in on a share link that allows favorites, so they can keep a list of their own
+	// Guests give a name and email, which only tell the photographer who they are, and are remembered by a cookie
+	r.POST("/s/:token/guest", func(c *gin.Context) {
+
+		if !allowRequest(redClient, "guest-ip:"+c.ClientIP(), guestIPLimit) {
+			abortWithError(http.StatusTooManyRequests, errors.New("too many attempts, please try again later"), c)
+			return
+		}
+
+		owner, shootID, _, id, link, err := resolveShareLink(tableName, dataTable, c.Param("token"), svc)
+		var shareErr *ShareError
+		if errors.As(err, &shareErr) {
+			abortWithError(shareErr.Status, shareErr, c)
+			return
+		}
+
+		// Limited per link too, so one visitor with many addresses can not take every guest slot on a shoot
+		if !allowRequest(redClient, "guest-link:"+id, guestLinkLimit) {
+			abortWithError(http.StatusTooManyRequests, errors.New("too many people are signing in on this link, please try again later"), c)
+			return
+		}
+		if !shareUnlocked(c, cookies, id, link) {
+			abortWithError(http.StatusUnauthorized, errors.New("this link needs a password"), c)
+			return
+		}
+		if !shareAllows(link, shareFavorite) {
+			abortWithError(http.StatusForbidden, errors.New("this link can not keep favorites"), c)
+			return
+		}
+
+		var body struct {
+			Name  string `json:"name"`
+			Email string `json:"email"`
+		}
+		err = c.ShouldBindJSON(&body)
+		if err != nil {
+			abortWithError(http.StatusBadRequest, errors.New("please give your name and email"), c)
+			return
+		}
+
+		// Guests already signed in on this browser keep their id, giving the form again just changes their name or email
+		existing, _ := currentGuest(c, cookies, id)
+		guest, err := newGuestSession(existing.ID, body.Name, body.Email)
+		if errors.As(err, &shareErr) {
+			abortWithError(shareErr.Status, shareErr, c)
+			return
+		}
+		if err != nil {
+			log.Printf("could not start guest session on share link %v: %v", id, err)
+			abortWithError(http.StatusInternalServerError, err, c)
+			return
+		}
+
+		favorites, err := addGuest(dataTable, owner.Username, shootID, id, guest, time.Now(), svc)
+		if errors.As(err, &shareErr) {
+			abortWithError(shareErr.Status, shareErr, c)
+			return
+		}
+		if err != nil {
+			log.Printf("could not save guest %v on %v: %v", guest.ID, shootID, err)
+			abortWithError(http.StatusInternalServerError, err, c)
+			return
+		}
+
+		err = startGuestSession(c, cookies, id, guest)
+		if err != nil {
+			log.Printf("could not start guest session on share link %v: %v", id, err)
+			abortWithError(http.StatusInternalServerError, err, c)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status":    "success",
+			"name":      favorites.Name,
+			"favorites": favorites.Photos,
+		})
+	})
+
+	// Returns the favorites of the guest signed in on a share link
+	r.GET("/s/:token/favorites", func(c *gin.Context) {
+
+		owner, shootID, _, id, link, err := resolveShareLink(tableName, dataTable, c.Param("token"), svc)
+		var shareErr *ShareError
+		if errors.As(err, &shareErr) {
+			abortWithError(shareErr.Status, shareErr, c)
+			return
+		}
+		if !shareUnlocked(c, cookies, id, link) || !shareAllows(link, shareFavorite) {
+			abortWithError(http.StatusForbidden, errors.New("this link can not keep favorites"), c)
+			return
+		}
+
+		guest, ok := currentGuest(c, cookies, id)
+		if !ok {
+			abortWithError(http.StatusUnauthorized, errors.New("please give your name and email first"), c)
+			return
+		}
+
+		favorites, exists, err := getGuestFavorites(dataTable, owner.Username, shootID, guest.ID, svc)
+		if err != nil {
+			log.Printf("could not get favorites of guest %v on %v: %v", guest.ID, shootID, err)
+			abortWithError(http.StatusInternalServerError, err, c)
+			return
+		}
+		if !exists {
+			abortWithError(http.StatusUnauthorized, errors.New("please give your name and email first"), c)
+			return
+		}
+
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusOK, gin.H{
+			"name":      favorites.Name,
+			"favorites": favorites.Photos,
+		})
+	})
+
+	// Adds a photo to the signed in guest's favorites, or takes it off when favorite is false
+	r.POST("/s/:token/favorite", func(c *gin.Context) {
+
+		owner, shootID, shoot, id, link, err := resolveShareLink(tableName, dataTable, c.Param("token"), svc)
+		var shareErr *ShareError
+		if errors.As(err, &shareErr) {
+			abortWithError(shareErr.Status, shareErr, c)
+			return
+		}
+		if !shareUnlocked(c, cookies, id, link) || !shareAllows(link, shareFavorite) {
+			abortWithError(http.StatusForbidden, errors.New("this link can not keep favorites"), c)
+			return
+		}
+
+		guest, ok := currentGuest(c, cookies, id)
+		if !ok {
+			abortWithError(http.StatusUnauthorized, errors.New("please give your name and email first"), c)
+			return
+		}
+
+		var body struct {
+			Key      string `json:"key"`
+			Favorite bool   `json:"favorite"`
+		}
+		err = c.ShouldBindJSON(&body)
+		if err != nil || body.Key == "" {
+			abortWithError(http.StatusBadRequest, errors.New("body must be a JSON object with a photo key and favorite"), c)
+			return
+		}
+
+		available, err := shootPhotoKeys(redClient, client, bucket, shoot)
+		if err != nil {
+			log.Printf("could not list photos in %v: %v", shootID, err)
+			abortWithError(http.StatusInternalServerError, err, c)
+			return
+		}
+		if !available[body.Key] {
+			abortWithError(http.StatusUnprocessableEntity, errors.New("photo is not in this shoot"), c)
+			return
+		}
+
+		favorites, err := setFavorite(dataTable, owner.Username, shootID, guest.ID, body.Key, body.Favorite, time.Now(), svc)
+		if errors.As(err, &shareErr) {
+			abortWithError(shareErr.Status, shareErr, c)
+			return
+		}
+		if err != nil {
+			log.Printf("could not save favorites of guest %v on %v: %v", guest.ID, shootID, err)
+			abortWithError(http.StatusInternalServerError, err, c)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status":    "success",
+			"favorites": favorites,
+		})
+	})
+
+	// Downloads a zip of the delivered originals through a share link that allows it
+	// scope is "delivered" for every photo or "category" for one folder, the client's picks can not be downloaded
+	r.GET("/s/:token/download", func(c *gin.Context) {
+
+		_, shootID, shoot, id, link, err := resolveShareLink(tableName, dataTable, c.Param("token"), svc)
+		var shareErr *ShareError
+		if errors.As(err, &shareErr) {
+			abortWithError(shareErr.Status, shareErr, c)
+			return
+		}
+		if !shareUnlocked(c, cookies, id, link) {
+			abortWithError(http.StatusUnauthorized, errors.New("this link needs a password"), c)
+			return
+		}
+		if !shareAllows(link, shareDownload) {
+			abortWithError(http.StatusForbidden, errors.New("this link can not download photos"), c)
+			return
+		}
+
+		scope := c.DefaultQuery("scope", downloadDelivered)
+		if scope == downloadPicks {
+			abortWithError(http.StatusForbidden, errors.New("share links can only download delivered photos"), c)
+			return
+		}
+
+		entries, err := planDownload(client, bucket, shoot, scope, c.Query("category"))
+		var downloadErr *DownloadError
+		if errors.As(err, &downloadErr) {
+			abortWithError(downloadErr.Status, downloadErr, c)
+			return
+		}
+		if err != nil {
+			log.Printf("could not plan download of %v through share link %v: %v", shootID, id, err)
+			abortWithError(http.StatusInternalServerError, err, c)
+			return
+		}
+
+		err = streamDownload(c, client, bucket, shootID, scope, entries)
+		if err != nil {
+			log.Printf("download of %v through share link %v failed: %v", shootID, id, err)
+		}
+	})
+
+	// Used when a user is creating an account
+	// Signup is invite only, so without an invite this just says so
+	// The very first account can be created without an invite
+	r.GET("/signup", func(c *gin.Context) {
+
+		empty, err := tableIsEmpty(tableName, svc)
+		if err != nil {
+			log.Printf("could not check for existing users: %v", err)
+		}
+
+		html, err := createSignupHTML(SignupPage{Bootstrap: empty, CSRFToken: csrfToken(c)})
+		if err != nil {
+			c.Data(http.StatusInternalServerError, "text/plain", []byte("Could not parse template"))
+			return
+		}
+		c.Data(http.StatusOK, "text/html", []byte(html))
+	})
+
+	// Signup page reached from an invite link
+	r.GET("/signup/:token", func(c *gin.Context) {
+
+		token := c.Param("token")
+		invite, err := getInvite(redClient, token)
+		if err != nil {
+			token = "" // Falls back to the invite only message
+		}
+
+		html, err := createSignupHTML(SignupPage{Token: token, Email: invite.Email, CSRFToken: csrfToken(c)})
+		if err != nil {
+			c.Data(http.StatusInternalServerError, "text/plain", []byte("Could not parse template"))
+			return
+		}
+		c.Data(http.StatusOK, "text/html", []byte(html))
+	})
+
+	// Activates an account from the link in the verification email
+	r.GET("/verify/:token", func(c *gin.Context) {
+
+		username, err := consumeVerifyToken(redClient, c.Param("token"))
+		if err != nil {
+			c.Redirect(http.StatusFound, "/login?verified=false")
+			return
+		}
+
+		err = markVerified(tableName, username, svc)
+		if err != nil {
+			log.Printf("could not verify %v: %v", username, err)
+			abortWithError(http.StatusInternalServerError, err, c)
+			return
+		}
+
+		user, err := getUser(tableName, username, svc)
+		if err == nil {
+			notify(mailer, user.Email, "Welcome to Client Photos", "welcome.html", newEmailData(baseURL, user, "", Shoot{}))
+		}
+
+		c.Redirect(http.StatusFound, "/login?verified=true")
+	})
+
+	// Page photographers use to invite new clients
+	r.GET("/invite", func(c *gin.Context) {
+
+		auth, username := checkToken(c, redClient, cookies)
+		if !auth {
+			c.Redirect(302, "/login")
+			return
+		}
+
+		user, err := getUser(tableName, username, svc)
+		if err != nil || !isPhotographer(user) {
+			c.Redirect(302, "/home")
+			return
+		}
+
+		servePage(c, "invite.html")
+	})
+
+	// Creates an invite and emails the signup link to the invited address
+	// Shoots in the invite are added to the account when it is created
+	r.POST("/invite", func(c *gin.Context) {
+
+		auth, username := checkToken(c, redClient, cookies)
+		if !auth {
+			c.Redirect(302, "/login")
+			return
+		}
+
+		user, err := getUser(tableName, username, svc)
+		if err != nil {
+			abortWithError(http.StatusNotFound, err, c)
+			return
+		}
+		if !isPhotographer(user) {
+			abortWithError(http.StatusForbidden, errors.New("only photographers can invite users"), c)
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			abortWithError(http.StatusBadRequest, err, c)
+			return
+		}
+
+		var invite Invite
+		err = json.Unmarshal(body, &invite)
+		if err != nil || invite.Email == "" {
+			abortWithError(http.StatusBadRequest, errors.New("invite needs an email address"), c)
+			return
+		}
+
+		if invite.Role == "" {
+			invite.Role = roleClient
+		}
+		if !validRole(invite.Role) {
+			abortWithError(http.StatusBadRequest, fmt.Errorf("unknown role %v", invite.Role), c)
+			return
+		}
+		if invite.Role != roleClient && user.Role != roleAdmin {
+			abortWithError(http.StatusForbidden, errors.New("only admins can invite photographers"), c)
+			return
+		}
+
+		for name, shoot := range invite.Shoots {
+			if _, err := time.Parse(time.RFC3339, shoot.Deadline); shoot.Deadline != "" && err != nil {
+				abortWithError(http.StatusBadRequest, fmt.Errorf("deadline for %v must be an RFC 3339 timestamp", name), c)
+				return
+			}
+		}
+
+		invite.InvitedBy = user.Username
+		invite.Created = time.Now().Format(time.RFC3339)
+
+		token, err := createInvite(redClient, invite)
+		if err != nil {
+			log.Printf("could not create invite for %v: %v", invite.Email, err)
+			abortWithError(http.StatusInternalServerError, err, c)
+			return
+		}
+
+		emailData := newEmailData(baseURL, User{Email: invite.Email}, "", Shoot{})
+		emailData.Link = baseURL + "/signup/" + token
+		notify(mailer, invite.Email, "You're invited to view your photos", "invite.html", emailData)
+
+		c.JSON(http.StatusOK, gin.H{
+			"status": "success",
+			"link":   emailData.Link,
+		})
+	})
+
+	// Returns the picks from a user's shoot
+	r.GET("/getSelections/:shoot", func(c *gin.Context) {
+
+		shoot := c.Param("shoot")
+		shoot = strings.ToLower(shoot)
+
+		auth, username := checkToken(c, redClient, cookies)
+
+		if !auth {
+			c.Redirect(http.StatusFound, "/login")
+			return
+		}
+
+		data, err := getUser(tableName, username, svc)
+		if err != nil {
+			log.Printf("could not get shoot data: %v", err)
+			abortWithError(http.StatusNotFound, err, c)
+			return
+		}
+
+		results, err := json.Marshal(data.Shoots[shoot].Picks)
+		if err != nil {
+			log.Printf("could not marshal shoot json data: %v", err)
+			abortWithError(http.StatusNotFound, err, c)
+			return
+		}
+
+		c.Data(http.StatusOK, "application/json", results)
+
+	})
+
+	// Page to request a password reset link
+	r.GET("/forgot", func(c *gin.Context) {
+		servePage(c, "forgot.html")
+	})
+
+	// Emails a password reset link to the user
+	// Always reports success so it can not be used to find out which accounts exist
+	r.POST("/forgot", func(c *gin.Context) {
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			abortWithError(http.StatusInternalServerError, err, c)
+			return
+		}
+
+		var request map[string]string
+		_ = json.Unmarshal(body, &request)
+
+		if !allowRequest(redClient, "forgot-ip:"+c.ClientIP(), loginIPLimit) {
+			abortWithError(http.StatusTooManyRequests, errors.New("too many requests, please try again later"), c)
+			return
+		}
+
+		// Failures are only logged, an error here would only ever be sent for accounts that exist
+		user, err := findUser(tableName, request["login"], svc)
+		if err == nil {
+			token, err := createResetToken(redClient, user.Username)
+			if err != nil {
+				log.Printf("could not create reset token for %v: %v", user.Username, err)
+			} else {
+				emailData := newEmailData(baseURL, user, "", Shoot{})
+				emailData.Link = baseURL + "/reset/" + token
+				notify(mailer, user.Email, "Reset your password", "reset_password.html", emailData)
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status": "if the account exists, a reset link has been sent to its email address",
+		})
+	})
+
+	// Page to choose a new password from a reset link
+	r.GET("/reset/:token", func(c *gin.Context) {
+
+		_, err := checkResetToken(redClient, c.Param("token"))
+		if err != nil {
+			c.Redirect(http.StatusFound, "/forgot?expired=true")
+			return
+		}
+
+		servePage(c, "reset.html")
+	})
+
+	// Sets a new password from a reset link
+	// The link can only be used once and every existing session for the user is logged out
+	r.POST("/reset/:token", func(c *gin.Context) {
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			abortWithError(http.StatusInternalServerError, err, c)
+			return
+		}
+
+		var request map[string]string
+		_ = json.Unmarshal(body, &request)
+		err = checkPassword(request["password"])
+		if err != nil {
+			abortWithError(http.StatusBadRequest, err, c)
+			return
+		}
+
+		username, err := consumeResetToken(redClient, c.Param("token"))
+		if err != nil {
+			abortWithError(http.StatusNotFound, err, c)
+			return
+		}
+
+		hash, salt, err := hashPassword(request["password"])
+		if err != nil {
+			abortWithError(http.StatusInternalServerError, err, c)
+			return
+		}
+
+		err = updatePassword(tableName, username, hash, salt, svc)
+		if err != nil {
+			log.Printf("could not reset password for %v: %v", username, err)
+			abortWithError(http.StatusInternalServerError, err, c)
+			return
+		}
+
+		clearSessions(redClient, username)
+
+		user, err := getUser(tableName, username, svc)
+		if err == nil {
+			notify(mailer, user.Email, "Your password was changed", "password_changed.html", newEmailData(baseURL, user, "", Shoot{}))
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status": "success",
+		})
+	})
+
+	// Get a user from the DB
+	// Only works in debug mode
+	if debug == "true" {
+		r.GET("/user/:username", func(c *gin.Context) {
+			username := c.Param("username")
+			result, err := getUser(tableName, username, svc)
+			if err != nil {
+				abortWithError(404, err, c)
+				return
+			}
+			c.JSON(http.StatusOK, result)
+		})
+	}
+
+	// Creates a new user in the database
+	r.POST("/createUser", func(c *gin.Context) {
+
+		// Read the request body into body variable
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			abortWithError(http.StatusInternalServerError, err, c)
+			return
+		}
+		if string(body) == "" {
+			err = errors.New("body is empty")
+			abortWithError(http.StatusBadRequest, err, c)
+			return
+		}
+
+		// Unmarshal the body json into a user struct along with the invite token
+		var request struct {
+			User
+			Invite string `json:"invite"`
+		}
+		_ = json.Unmarshal(body, &request)
+		user := request.User
+		user.Username = strings.ToLower(user.Username)
+		if user.Username == "" || user.Password == "" {
+			abortWithError(http.StatusBadRequest, errors.New("username and password are required"), c)
+			return
+		}
+		err = checkUsername(user.Username)
+		if err != nil {
+			abortWithError(http.StatusBadRequest, err, c)
+			return
+		}
+		err = checkPassword(user.Password)
+		if err != nil {
+			abortWithError(http.StatusBadRequest, err, c)
+			return
+		}
+
+		// The first account ever created does not need an invite and becomes the admin
+		// Every other account needs a valid invite
+		empty, err := tableIsEmpty(tableName, svc)
+		if err != nil {
+			abortWithError(http.StatusInternalServerError, err, c)
+			return
+		}
+
+		// The empty check alone is not enough, two signups could both see an empty table
+		// Only the one that claims the bootstrap marker becomes admin, the other needs an invite like everyone else
+		bootstrap := false
+		if empty {
+			bootstrap, err = claimBootstrap(dataTable, user.Username, svc)
+			if err != nil {
+				abortWithError(http.StatusInternalServerError, err, c)
+				return
+			}
+		}
+
+		var invite Invite
+		if bootstrap {
+			invite = Invite{Role: roleAdmin}
+		} else {
+			invite, err = getInvite(redClient, request.Invite)
+			if err != nil {
+				abortWithError(http.StatusForbidden, err, c)
+				return
+			}
+			if _, err := getUser(tableName, user.Username, svc); err == nil {
+				abortWithError(http.StatusConflict, errors.New("user already exists"), c)
+				return
+			}
+			invite, err = consumeInvite(redClient, request.Invite)
+			if err != nil {
+				abortWithError(http.StatusForbidden, err, c)
+				return
+			}
+		}
+
+		// Only the invite decides the shoots and role, never the request body
+		// Following the invite link proves the invited address, any other address has to be verified
+		user.Shoots = shootsByID(invite.Shoots)
+		user.Role = invite.Role
+		user.TOTPSecret, user.TOTPEnabled, user.RecoveryCodes = "", false, nil
+		user.APITokens = nil
+		user.Unverified = invite.Email == "" || !strings.EqualFold(user.Email, invite.Email)
+
+		//Convert the password from the request body into a salted hash using bcrypt
+		user.Password, user.Salt, err = hashPassword(user.Password)
+		if err != nil {
+			abortWithError(http.StatusInternalServerError, err, c)
+			return
+		}
+
+		for _, shoot := range user.Shoots {
+			err = recordShootStorage(dataTable, user.Username, shoot, svc)
+			if err != nil {
+				break
+			}
+		}
+
+		// Create the user in DynamoDB
+		if err == nil {
+			err = createUser(tableName, user, svc)
+		}
+		if err != nil {
+			if bootstrap {
+				releaseBootstrap(dataTable, svc)
+			}
+			abortWithError(http.StatusInternalServerError, err, c)
+			return
+		}
+
+		if user.Unverified {
+			token, err := createVerifyToken(redClient, user.Username)
+			if err != nil {
+				log.Printf("could not create verification token for %v: %v", user.Username, err)
+				abortWithError(http.StatusInternalServerError, errors.New("account created but the verification email could not be sent"), c)
+				return
+			}
+			emailData := newEmailData(baseURL, user, "", Shoot{})
+			emailData.Link = baseURL + "/verify/" + token
+			notify(mailer, user.Email, "Verify your email address", "verify_email.html", emailData)
+		} else {
+			notify(mailer, user.Email, "Welcome to Client Photos", "welcome.html", newEmailData(baseURL, user, "", Shoot{}))
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status":   "success",
+			"verified": !user.Unverified,
+		})
+	})
+
+	// User sign in. Sends an auth token cookie to the front end
+	// Also sends a json with the auth token
+	r.POST("/signin", func(c *gin.Context) {
+
+		// Read the request body into body variable
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			abortWithError(http.StatusInternalServerError, err, c)
+			return
+		}
+		if string(body) == "" {
+			err = errors.New("body is empty")
+			abortWithError(http.StatusBadRequest, err, c)
+			return
+		}
+
+		var providedCredentials map[string]string
+
+		_ = json.Unmarshal(body, &providedCredentials)
+		username := strings.ToLower(providedCredentials["username"])
+		ip := c.ClientIP()
+
+		// Throttle by IP and by username before doing any bcrypt work
+		// Locked out and throttled requests get the same response so neither reveals whether the user exists
+		if !allowRequest(redClient, "login-ip:"+ip, loginIPLimit) || !allowRequest(redClient, "login-user:"+username, loginUserLimit) || isLockedOut(redClient, username) {
+			abortWithError(http.StatusTooManyRequests, errors.New("too many login attempts, please try again later"), c)
+			return
+		}
+
+		user, err := getUser(tableName, username, svc)
+		userExists := err == nil
+		if !userExists {
+			log.Printf("There was a problem fetching a user from the DB: %v", err)
+			user = User{Password: dummyHash} // Still run bcrypt so unknown users take as long as wrong passwords
+		}
+
+		authBool := verifyPassword(user.Password, providedCredentials["password"], user.Salt) && userExists
+
+		if !authBool {
+			recordLoginFailure(redClient, username, ip, lockoutPolicy)
+			c.Data(http.StatusUnauthorized, "text/plain", []byte("incorrect username or password"))
+			return
+		}
+		clearLoginFailures(redClient, username)
+
+		if authBool && user.Unverified {
+			abortWithError(http.StatusForbidden, errors.New("please verify your email address before logging in"), c)
+			return
+		}
+
+		// Users with 2FA get a challenge instead of a session and finish signing in at /signin/2fa
+		// Users who are required to have 2FA but have not set it up have to enroll first
+		if authBool && twoFactorRequired(user, staffTwoFactor) {
+			purpose := "2fa:"
+			if !user.TOTPEnabled {
+				purpose = "2fa-enroll:"
+			}
+
+			challenge, err := createTwoFactorChallenge(redClient, purpose, user.Username)
+			if err != nil {
+				log.Printf("could not create 2FA challenge for %v: %v", user.Username, err)
+				abortWithError(http.StatusInternalServerError, err, c)
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{"twoFactor": user.TOTPEnabled, "enroll": !user.TOTPEnabled, "challenge": challenge})
+			return
+		}
+
+		if authBool {
+			token, err := startSession(c, redClient, cookies, user.Username)
+			if err != nil {
+				log.Println(err)
+				abortWithError(http.StatusInternalServerError, err, c)
+				return
+			}
+
+			c.JSON(http.StatusAccepted, gin.H{"accepted": authBool, "token": token})
+		}
+
+	})
+
+	// Second step of signing in for users with 2FA
+	// Takes the challenge from /signin along with a TOTP or recovery code
+	r.POST("/signin/2fa", func(c *gin.Context) {
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			abortWithError(http.StatusInternalServerError, err, c)
+			return
+		}
+
+		var request map[string]string
+		_ = json.Unmarshal(body, &request)
+
+		if !allowRequest(redClient, "login-ip:"+c.ClientIP(), loginIPLimit) {
+			abortWithError(http.StatusTooManyRequests, errors.New("too many login attempts, please try again later"), c)
+			return
+		}
+
+		username, err := checkOneTimeToken(redClient, "2fa:", request["challenge"])
+		if err != nil {
+			abortWithError(http.StatusUnauthorized, errors.New("sign in has expired, please enter your password again"), c)
+			return
+		}
+
+		user, err := getUser(tableName, username, svc)
+		if err != nil {
+			abortWithError(http.StatusUnauthorized, err, c)
+			return
+		}
+
+		if !verifySecondFactor(tableName, user, request["code"], redClient, svc) {
+			if twoFactorAttemptsExceeded(redClient, request["challenge"]) {
+				_, _ = consumeOneTimeToken(redClient, "2fa:", request["challenge"])
+				abortWithError(http.StatusUnauthorized, errors.New("too many incorrect codes, please enter your password again"), c)
+				return
+			}
+			abortWithError(http.StatusUnauthorized, errors.New("incorrect code"), c)
+			return
+		}
+
+		_, err = consumeOneTimeToken(redClient, "2fa:", request["challenge"])
+		if err != nil {
+			abortWithError(http.StatusUnauthorized, err, c)
+			return
+		}
+
+		token, err := startSession(c, redClient, cookies, user.Username)
+		if err != nil {
+			log.Println(err)
+			abortWithError(http.StatusInternalServerError, err, c)
+			return
+		}
+
+		c.JSON(http.StatusAccepted, gin.H{"accepted": true, "token": token})
+	})
+
+	// Page to set up or turn off 2FA
+	r.GET("/2fa", func(c *gin.Context) {
+		servePage(c, "2fa.html")
+	})
+
+	// Reports whether the user has 2FA turned on and whether they are allowed to turn it off
+	r.GET("/2fa/status", func(c *gin.Context) {
+
+		username, _, ok := twoFactorUsername(c, redClient, cookies)
+		if !ok {
+			abortWithError(http.StatusUnauthorized, errors.New("not logged in"), c)
+			return
+		}
+
+		user, err := getUser(tableName, username, svc)
+		if err != nil {
+			abortWithError(http.StatusNotFound, err, c)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"enabled":       user.TOTPEnabled,
+			"required":      staffTwoFactor && isPhotographer(user),
+			"recoveryCodes": len(user.RecoveryCodes),
+		})
+	})
+
+	// Starts 2FA enrollment by generating a new secret
+	// The secret is not saved to the account until a code from it is confirmed at /2fa/enable
+	// Refused while 2FA is on, so a stolen session can not swap in its own authenticator
+	r.POST("/2fa/setup", func(c *gin.Context) {
+
+		username, _, ok := twoFactorUsername(c, redClient, cookies)
+		if !ok {
+			abortWithError(http.StatusUnauthorized, errors.New("not logged in"), c)
+			return
+		}
+
+		user, err := getUser(tableName, username, svc)
+		if err != nil {
+			abortWithError(http.StatusNotFound, err, c)
+			return
+		}
+		if user.TOTPEnabled {
+			abortWithError(http.StatusConflict, errTwoFactorEnabled, c)
+			return
+		}
+
+		secret, err := generateTOTPSecret()
+		if err != nil {
+			abortWithError(http.StatusInternalServerError, err, c)
+			return
+		}
+
+		err = redClient.Set("totp-setup:"+username, secret, time.Minute*10).Err()
+		if err != nil {
+			abortWithError(http.StatusInternalServerError, err, c)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"secret": secret,
+			"uri":    totpURI(secret, username, totpIssuer),
+		})
+	})
+
+	// QR code of the secret being enrolled, rendered as a PNG
+	r.GET("/2fa/qr", func(c *gin.Context) {
+
+		username, _, ok := twoFactorUsername(c, redClient, cookies)
+		if !ok {
+			abortWithError(http.StatusUnauthorized, errors.New("not logged in"), c)
+			return
+		}
+
+		secret, err := redClient.Get("totp-setup:" + username).Result()
 		if err != nil {
-			log.Printf("could not read request body: %v", err)
-			abortWithError(http.StatusBadRequest, err, c)
+			abortWithError(http.StatusNotFound, errors.New("2FA setup has not been started or has expired"), c)
+			return
 		}
 
-		// Define the key to identify the item you want to update
-		key := map[string]*dynamodb.AttributeValue{
-			"username": {
-				S: aws.String(username),
-			},
+		png, err := qrCodePNG(totpURI(secret, username, totpIssuer))
+		if err != nil {
+			abortWithError(http.StatusInternalServerError, err, c)
+			return
 		}
 
-		// Define the update expression to set the new property value
-		updateExpression := "SET shoots." + shootName + " = :newValue"
+		c.Header("Cache-Control", "no-store")
+		c.Data(http.StatusOK, "image/png", png)
+	})
+
+	// Finishes 2FA enrollment once the user has entered a code from their authenticator app
+	// Returns the recovery codes, which are only ever shown this once
+	r.POST("/2fa/enable", func(c *gin.Context) {
 
-		var shoot Shoot
-		_ = json.Unmarshal(body, &shoot)
-		newShoot, err := dynamodbattribute.MarshalMap(shoot)
+		username, enrolling, ok := twoFactorUsername(c, redClient, cookies)
+		if !ok {
+			abortWithError(http.StatusUnauthorized, errors.New("not logged in"), c)
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
 		if err != nil {
-			log.Printf("Could not marshal json: %v", err)
-			abortWithError(http.StatusBadRequest, err, c)
+			abortWithError(http.StatusInternalServerError, err, c)
+			return
 		}
 
-		// Define expression attribute values
-		expressionAttributeValues := map[string]*dynamodb.AttributeValue{
-			":newValue": {
-				M: newShoot,
-			},
+		var request map[string]string
+		_ = json.Unmarshal(body, &request)
+
+		secret, err := redClient.Get("totp-setup:" + username).Result()
+		if err != nil {
+			abortWithError(http.StatusNotFound, errors.New("2FA setup has not been started or has expired"), c)
+			return
 		}
 
-		// Configure the update input
-		updateInput := &dynamodb.UpdateItemInput{
-			TableName:                 aws.String(tableName),
-			Key:                       key,
-			UpdateExpression:          aws.String(updateExpression),
-			ExpressionAttributeValues: expressionAttributeValues,
-			ReturnValues:              aws.String("UPDATED_NEW"), // If you want to return the updated item
+		if !verifyTOTP(redClient, username, secret, request["code"]) {
+			abortWithError(http.StatusBadRequest, errors.New("incorrect code"), c)
+			return
 		}
 
-		// Perform the update operation
-		_, err = svc.UpdateItem(updateInput)
+		codes, hashes, err := generateRecoveryCodes()
 		if err != nil {
-			log.Printf("Could not add shoot: %v", err)
-			abortWithError(http.StatusBadRequest, err, c)
+			abortWithError(http.StatusInternalServerError, err, c)
+			return
+		}
+
+		err = enableTwoFactor(tableName, username, secret, hashes, svc)
+		if errors.Is(err, errTwoFactorEnabled) {
+			abortWithError(http.StatusConflict, err, c)
+			return
 		}
-		err = deletePlaceHolder(svc, username, tableName)
 		if err != nil {
 			log.Println(err)
+			abortWithError(http.StatusInternalServerError, err, c)
+			return
+		}
+		_ = redClient.Del("totp-setup:" + username)
+
+		// Users who had to enroll before signing in are signed in now
+		if enrolling {
+			_, _ = consumeOneTimeToken(redClient, "2fa-enroll:", c.Query("challenge"))
+			_, err = startSession(c, redClient, cookies, username)
+			if err != nil {
+				log.Println(err)
+			}
 		}
 
+		c.JSON(http.StatusOK, gin.H{
+			"status":        "success",
+			"recoveryCodes": codes,
+		})
 	})
 
-	// Called when the user sends their shoot picks in via the front end
-	r.POST("/shoot/:shoot/:page/savePicks", func(c *gin.Context) {
+	// Turns off 2FA. Needs a current code so a stolen session can not turn it off
+	r.POST("/2fa/disable", func(c *gin.Context) {
 
-		auth, username := checkToken(c, redClient)
+		auth, username := checkToken(c, redClient, cookies)
 		if !auth {
-			c.Redirect(302, "/login")
+			abortWithError(http.StatusUnauthorized, errors.New("not logged in"), c)
 			return
 		}
 
-		shoot := c.Param("shoot")
-
 		body, err := io.ReadAll(c.Request.Body)
 		if err != nil {
-			log.Printf("could not read request body: %v", err)
-			abortWithError(http.StatusBadRequest, err, c)
+			abortWithError(http.StatusInternalServerError, err, c)
+			return
 		}
 
+		var request map[string]string
+		_ = json.Unmarshal(body, &request)
+
 		user, err := getUser(tableName, username, svc)
 		if err != nil {
-			log.Printf("could not get user: %v : %v", username, err)
 			abortWithError(http.StatusNotFound, err, c)
+			return
 		}
 
-		var picks Picks
-		err = json.Unmarshal(body, &picks)
-		if err != nil {
-			fmt.Printf("could not unmarshal json: %v", err)
-			abortWithError(http.StatusInternalServerError, err, c)
+		if staffTwoFactor && isPhotographer(user) {
+			abortWithError(http.StatusForbidden, errors.New("2FA is required for your account"), c)
+			return
 		}
 
-		modifiedShoot := user.Shoots[shoot]
-		modifiedShoot.Picks = picks
-		user.Shoots[shoot] = modifiedShoot
+		if !user.TOTPEnabled || !verifySecondFactor(tableName, user, request["code"], redClient, svc) {
+			abortWithError(http.StatusBadRequest, errors.New("incorrect code"), c)
+			return
+		}
 
-		err = updatePicks(tableName, username, shoot, picks, svc)
+		err = disableTwoFactor(tableName, username, svc)
 		if err != nil {
-			fmt.Printf("could not edit picks: %v", err)
+			log.Println(err)
 			abortWithError(http.StatusInternalServerError, err, c)
+			return
 		}
 
 		c.JSON(http.StatusOK, gin.H{
 			"status": "success",
 		})
-
 	})
 
-	// Used when a user is creating an account
-	r.GET("/signup", func(c *gin.Context) {
-		html, _ := os.ReadFile("./static/html/signup.html")
-		c.Data(http.StatusOK, "text/html", html)
-	})
+	// Account page where users manage their API tokens
+	r.GET("/account", func(c *gin.Context) {
 
-	// Returns the picks from a user's shoot
-	r.GET("/getSelections/:shoot", func(c *gin.Context) {
+		auth, _ := checkToken(c, redClient, cookies)
+		if !auth {
+			c.Redirect(302, "/login")
+			return
+		}
 
-		shoot := c.Param("shoot")
-		shoot = strings.ToLower(shoot)
+		servePage(c, "account.html")
+	})
 
-		auth, username := checkToken(c, redClient)
+	// Lists the logged in user's API tokens
+	r.GET("/account/tokens", func(c *gin.Context) {
 
+		auth, username := checkToken(c, redClient, cookies)
 		if !auth {
-			c.Redirect(http.StatusFound, "/login")
+			abortWithError(http.StatusUnauthorized, errors.New("not logged in"), c)
 			return
 		}
 
-		data, err := getUser(tableName, username, svc)
+		user, err := getUser(tableName, username, svc)
 		if err != nil {
-			log.Printf("could not get shoot data: %v", err)
 			abortWithError(http.StatusNotFound, err, c)
 			return
 		}
 
-		results, err := json.Marshal(data.Shoots[shoot].Picks)
+		c.JSON(http.StatusOK, gin.H{
+			"tokens": apiTokenResources(user),
+			"scopes": allScopes,
+			"admin":  isPhotographer(user),
+		})
+	})
+
+	// Creates an API token for the logged in user
+	// The token is in the response and can not be shown again
+	// Tokens can only be made from a browser session, never with another token
+	r.POST("/account/tokens", func(c *gin.Context) {
+
+		auth, username := checkToken(c, redClient, cookies)
+		if !auth {
+			abortWithError(http.StatusUnauthorized, errors.New("not logged in"), c)
+			return
+		}
+
+		user, err := getUser(tableName, username, svc)
 		if err != nil {
-			log.Printf("could not marshal shoot json data: %v", err)
 			abortWithError(http.StatusNotFound, err, c)
 			return
 		}
 
-		c.Data(http.StatusOK, "application/json", results)
-
-	})
+		var request struct {
+			Name   string   `json:"name"`
+			Scopes []string `json:"scopes"`
+		}
+		err = c.ShouldBindJSON(&request)
+		if err != nil {
+			abortWithError(http.StatusBadRequest, errors.New("token needs a name and scopes"), c)
+			return
+		}
 
-	// Get a user from the DB
-	// Only works in debug mode
-	if debug == "true" {
-		r.GET("/user/:username", func(c *gin.Context) {
-			username := c.Param("username")
-			result, err := getUser(tableName, username, svc)
-			if err != nil {
-				abortWithError(404, err, c)
+		request.Name = strings.TrimSpace(request.Name)
+		if request.Name == "" || len(request.Name) > 100 {
+			abortWithError(http.StatusBadRequest, errors.New("token needs a name of at most 100 characters"), c)
+			return
+		}
+		if len(request.Scopes) == 0 {
+			abortWithError(http.StatusBadRequest, errors.New("token needs at least one scope"), c)
+			return
+		}
+		for _, scope := range request.Scopes {
+			if !validScope(scope) {
+				abortWithError(http.StatusBadRequest, fmt.Errorf("unknown scope %v", scope), c)
 				return
 			}
-			c.JSON(http.StatusOK, result)
+			if scope == scopeAdmin && !isPhotographer(user) {
+				abortWithError(http.StatusForbidden, errors.New("only photographers and admins can make admin tokens"), c)
+				return
+			}
+		}
+		if len(user.APITokens) >= maxAPITokens {
+			abortWithError(http.StatusConflict, fmt.Errorf("you can have at most %v tokens, revoke one first", maxAPITokens), c)
+			return
+		}
+
+		token, id, err := createAPIToken(tableName, user, request.Name, request.Scopes, svc)
+		if err != nil {
+			log.Printf("could not create API token for %v: %v", username, err)
+			abortWithError(http.StatusInternalServerError, err, c)
+			return
+		}
+
+		log.Printf("%v created API token %v with scopes %v", username, id, request.Scopes)
+
+		c.JSON(http.StatusOK, gin.H{
+			"id":    id,
+			"token": token,
 		})
-	}
+	})
 
-	// Creates a new user in the database
-	r.POST("/createUser", func(c *gin.Context) {
+	// Revokes one of the logged in user's API tokens
+	r.POST("/account/tokens/:id/revoke", func(c *gin.Context) {
 
-		// Read the request body into body variable
-		body, err := io.ReadAll(c.Request.Body)
+		auth, username := checkToken(c, redClient, cookies)
+		if !auth {
+			abortWithError(http.StatusUnauthorized, errors.New("not logged in"), c)
+			return
+		}
+
+		id := c.Param("id")
+		err := revokeAPIToken(tableName, username, id, svc)
 		if err != nil {
-			abortWithError(http.StatusInternalServerError, err, c)
+			abortWithError(http.StatusNotFound, errors.New("token does not exist"), c)
 			return
 		}
-		if string(body) == "" {
-			err = errors.New("body is empty")
-			abortWithError(http.StatusBadRequest, err, c)
+
+		log.Printf("%v revoked API token %v", username, id)
+
+		c.JSON(http.StatusOK, gin.H{
+			"status": "success",
+		})
+	})
+
+	// Lists recent account lockouts for admins
+	r.GET("/admin/lockouts", func(c *gin.Context) {
+
+		auth, adminName := checkToken(c, redClient, cookies)
+		if !auth {
+			abortWithError(http.StatusUnauthorized, errors.New("not logged in"), c)
 			return
 		}
 
-		// Unmarshal the body json into a user struct
-		var user User
-		_ = json.Unmarshal(body, &user)
-		user.Salt, _ = generateSalt(32)
-		user.Password = user.Password + user.Salt
+		admin, err := getUser(tableName, adminName, svc)
+		if err != nil || admin.Role != roleAdmin {
+			abortWithError(http.StatusForbidden, errors.New("only admins can view lockouts"), c)
+			return
+		}
 
-		//Convert the password from the request body into a salted hash using bcrypt
-		var hash []byte
-		hash, err = bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+		events, err := getLockoutEvents(redClient)
 		if err != nil {
-			err = fmt.Errorf("could not hash the password: %v", err)
 			abortWithError(http.StatusInternalServerError, err, c)
 			return
+		}
+
+		c.JSON(http.StatusOK, events)
+	})
+
+	// Lets an admin lift a lockout before it expires
+	r.POST("/user/:username/unlock", func(c *gin.Context) {
 
+		auth, adminName := checkToken(c, redClient, cookies)
+		if !auth {
+			abortWithError(http.StatusUnauthorized, errors.New("not logged in"), c)
+			return
 		}
-		user.Password = string(hash)
 
-		// Create the user in DynamoDB
-		err = createUser(tableName, user, svc)
+		admin, err := getUser(tableName, adminName, svc)
+		if err != nil || admin.Role != roleAdmin {
+			abortWithError(http.StatusForbidden, errors.New("only admins can unlock users"), c)
+			return
+		}
+
+		username := strings.ToLower(c.Param("username"))
+		err = unlockUser(redClient, username)
 		if err != nil {
 			abortWithError(http.StatusInternalServerError, err, c)
 			return
 		}
 
+		log.Printf("%v unlocked %v", adminName, username)
+
 		c.JSON(http.StatusOK, gin.H{
 			"status": "success",
 		})
 	})
 
-	// User sign in. Sends an auth token cookie to the front end
-	// Also sends a json with the auth token
-	r.POST("/signin", func(c *gin.Context) {
+	// Lets a photographer turn zip downloads of a client's shoot on or off
+	// Expects a JSON body like {"enabled": true}
+	r.POST("/user/:username/shoot/:shootID/downloads", func(c *gin.Context) {
 
-		// Read the request body into body variable
-		body, err := io.ReadAll(c.Request.Body)
+		auth, photographerName := checkToken(c, redClient, cookies)
+		if !auth {
+			abortWithError(http.StatusUnauthorized, errors.New("not logged in"), c)
+			return
+		}
+
+		photographer, err := getUser(tableName, photographerName, svc)
+		if err != nil || !isPhotographer(photographer) {
+			abortWithError(http.StatusForbidden, errors.New("only photographers can change downloads"), c)
+			return
+		}
+
+		var body struct {
+			Enabled bool `json:"enabled"`
+		}
+		err = c.ShouldBindJSON(&body)
+		if err != nil {
+			abortWithError(http.StatusBadRequest, errors.New("body must be a JSON object like {\"enabled\": true}"), c)
+			return
+		}
+
+		username := strings.ToLower(c.Param("username"))
+		shootID := c.Param("shootID")
+
+		user, err := getUser(tableName, username, svc)
+		if err != nil {
+			abortWithError(http.StatusNotFound, errors.New("user does not exist"), c)
+			return
+		}
+		if _, exists := user.Shoots[shootID]; !exists || shootID == placeholderShoot {
+			abortWithError(http.StatusNotFound, errors.New("shoot does not exist"), c)
+			return
+		}
+
+		err = setShootProperty(tableName, username, shootID, "downloads", &dynamodb.AttributeValue{BOOL: aws.Bool(body.Enabled)}, svc)
 		if err != nil {
+			log.Printf("could not change downloads for %v of %v: %v", shootID, username, err)
 			abortWithError(http.StatusInternalServerError, err, c)
 			return
 		}
-		if string(body) == "" {
-			err = errors.New("body is empty")
-			abortWithError(http.StatusBadRequest, err, c)
+
+		log.Printf("%v set downloads of %v for %v to %v", photographerName, shootID, username, body.Enabled)
+
+		c.JSON(http.StatusOK, gin.H{
+			"status":  "success",
+			"enabled": body.Enabled,
+		})
+	})
+
+	// Lets a photographer change one of a client's shoots, such as moving its deadline or unlocking it
+	// Takes the same body as /shoot/:shoot/update along with deadline and locked. Example: {"deadline": "2024-07-01T17:00:00Z", "locked": false}
+	r.POST("/user/:username/shoot/:shootID/update", func(c *gin.Context) {
+
+		auth, photographerName := checkToken(c, redClient, cookies)
+		if !auth {
+			abortWithError(http.StatusUnauthorized, errors.New("not logged in"), c)
 			return
 		}
 
-		var providedCredentials map[string]string
+		photographer, err := getUser(tableName, photographerName, svc)
+		if err != nil || !isPhotographer(photographer) {
+			abortWithError(http.StatusForbidden, errors.New("only photographers can change clients' shoots"), c)
+			return
+		}
 
-		_ = json.Unmarshal(body, &providedCredentials)
-		providedCredentials["username"] = strings.ToLower(providedCredentials["username"])
-		user, err := getUser(tableName, providedCredentials["username"], svc)
+		var update ShootUpdate
+		err = c.ShouldBindJSON(&update)
 		if err != nil {
-			log.Printf("There was a problem fetching a user from the DB: %v", err)
-			abortWithError(http.StatusNotFound, err, c)
+			abortWithError(http.StatusBadRequest, errors.New("send a name, date, cover, deadline or lock to change"), c)
 			return
 		}
 
-		authBool := verifyPassword(user.Password, providedCredentials["password"], user.Salt)
+		username := strings.ToLower(c.Param("username"))
+		shootID := c.Param("shootID")
 
-		if authBool {
-			token, err := generateSessionToken()
-			if err != nil {
-				log.Printf("Could not generate token for %v: %v", providedCredentials["username"], err)
-			}
-			setToken(redClient, providedCredentials["username"], token)
+		user, err := getUser(tableName, username, svc)
+		if err != nil {
+			abortWithError(http.StatusNotFound, errors.New("user does not exist"), c)
+			return
+		}
+		shoot, exists := user.Shoots[shootID]
+		if !exists || shootID == placeholderShoot {
+			abortWithError(http.StatusNotFound, errors.New("shoot does not exist"), c)
+			return
+		}
 
-			authJson := map[string]string{"username": user.Username, "token": token}
-			authJsonBytes, err := json.Marshal(authJson)
-			if err != nil {
-				log.Printf("could not marshal json: %v", err)
-			}
+		shoot, err = updateShoot(tableName, redClient, client, bucket, username, shootID, shoot, update, true, grace, svc)
+		var shootErr *ShootError
+		if errors.As(err, &shootErr) {
+			abortWithError(shootErr.Status, shootErr, c)
+			return
+		}
+		if err != nil {
+			log.Printf("could not update shoot %v for %v: %v", shootID, username, err)
+			abortWithError(http.StatusInternalServerError, err, c)
+			return
+		}
 
-			// Create a new cookie
-			authCookie := &http.Cookie{
-				Name:     "authToken",
-				Value:    string(authJsonBytes),
-				HttpOnly: true,
-			}
+		log.Printf("%v updated %v for %v", photographerName, shootID, username)
+
+		c.JSON(http.StatusOK, gin.H{
+			"status": "success",
+			"shoot":  newShootResource(shootID, shoot, grace),
+		})
+	})
 
-			weekInSeconds := 604800
-			c.SetCookie(authCookie.Name, authCookie.Value, weekInSeconds, "/", c.Request.Host, true, true)
+	// Lets an admin turn off 2FA for a user who has lost their authenticator and recovery codes
+	// The user is logged out everywhere
+	r.POST("/user/:username/reset2fa", func(c *gin.Context) {
 
-			c.JSON(http.StatusAccepted, gin.H{"accepted": authBool, "token": token})
-		} else if !authBool {
-			c.Data(http.StatusNotFound, "text/plain", []byte("incorrect username or password"))
+		auth, adminName := checkToken(c, redClient, cookies)
+		if !auth {
+			abortWithError(http.StatusUnauthorized, errors.New("not logged in"), c)
+			return
+		}
+
+		admin, err := getUser(tableName, adminName, svc)
+		if err != nil || admin.Role != roleAdmin {
+			abortWithError(http.StatusForbidden, errors.New("only admins can reset 2FA"), c)
+			return
+		}
+
+		username := strings.ToLower(c.Param("username"))
+		if _, err := getUser(tableName, username, svc); err != nil {
+			abortWithError(http.StatusNotFound, err, c)
+			return
+		}
+
+		err = disableTwoFactor(tableName, username, svc)
+		if err != nil {
+			log.Println(err)
+			abortWithError(http.StatusInternalServerError, err, c)
+			return
 		}
+		clearSessions(redClient, username)
 
+		log.Printf("%v reset 2FA for %v", adminName, username)
+
+		c.JSON(http.StatusOK, gin.H{
+			"status": "success",
+		})
 	})
 
 	fmt.Printf("Listening for %v on port %v...\n", protocol, port) //Notifies that server is running on X port
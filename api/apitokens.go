@@ -0,0 +1,286 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+)
+
+// Scopes an API token can be given
+const (
+	scopeReadShoots  = "shoots:read"  // List and look at shoots, photos, picks and comments
+	scopeWritePicks  = "picks:write"  // Change picks and leave comments
+	scopeWriteShoots = "shoots:write" // Rename shoots, change their dates and covers, and reorder them
+	scopeWriteShares = "shares:write" // Make and revoke share links, which can give anyone with the link the photos
+	scopeAdmin       = "admin"        // Everything else a photographer or admin can do through the API
+)
+
+// Every scope, for cookie sessions which can do anything the user can
+var allScopes = []string{scopeReadShoots, scopeWritePicks, scopeWriteShoots, scopeWriteShares, scopeAdmin}
+
+// Start of every API token so they are easy to spot in logs and secret scanners
+const apiTokenPrefix = "cpat"
+
+// Most tokens one user can have
+const maxAPITokens = 20
+
+// How often a token's last used time is written, so busy scripts do not write on every request
+const lastUsedResolution = time.Minute
+
+// Reports whether a scope is one tokens can have
+func validScope(scope string) bool {
+	for _, each := range allScopes {
+		if scope == each {
+			return true
+		}
+	}
+	return false
+}
+
+// Reports whether a list of scopes includes the one a route needs
+func hasScope(scopes []string, needed string) bool {
+	return needed == "" || containsString(scopes, needed)
+}
+
+// Hashes a token secret for storage
+// Secrets are 32 random bytes so a fast hash is enough, unlike passwords
+func hashAPITokenSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// Builds the token handed to the user
+// The username is part of the token so it can be found without scanning every user
+// Example: cpat.c21pdGg.Q3J5cHRv.<secret>
+func formatAPIToken(username string, id string, secret string) string {
+	return strings.Join([]string{
+		apiTokenPrefix,
+		base64.RawURLEncoding.EncodeToString([]byte(username)),
+		id,
+		secret,
+	}, ".")
+}
+
+// Splits a token into the username, token id and secret
+func parseAPIToken(token string) (string, string, string, error) {
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 4 || parts[0] != apiTokenPrefix {
+		return "", "", "", errors.New("malformed API token")
+	}
+
+	username, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || len(username) == 0 || parts[2] == "" || parts[3] == "" {
+		return "", "", "", errors.New("malformed API token")
+	}
+
+	return string(username), parts[2], parts[3], nil
+}
+
+// Creates a new API token for a user
+// Returns the token, which is only ever shown this once, and its id
+func createAPIToken(tableName string, user User, name string, scopes []string, svc *dynamodb.DynamoDB) (string, string, error) {
+
+	id, err := generateURLToken()
+	if err != nil {
+		return "", "", err
+	}
+	id = id[:12]
+
+	secret, err := generateURLToken()
+	if err != nil {
+		return "", "", err
+	}
+
+	token := APIToken{
+		Name:    name,
+		Hash:    hashAPITokenSecret(secret),
+		Scopes:  scopes,
+		Created: time.Now().Format(time.RFC3339),
+	}
+
+	value, err := dynamodbattribute.Marshal(token)
+	if err != nil {
+		return "", "", fmt.Errorf("could not marshal API token: %v", err)
+	}
+
+	key := map[string]*dynamodb.AttributeValue{
+		"username": {
+			S: aws.String(user.Username),
+		},
+	}
+
+	// The tokens map has to exist before a token can be set inside it
+	_, err = svc.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName:                aws.String(tableName),
+		Key:                      key,
+		UpdateExpression:         aws.String("SET #tokens = if_not_exists(#tokens, :empty)"),
+		ExpressionAttributeNames: map[string]*string{"#tokens": aws.String("apiTokens")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":empty": {M: map[string]*dynamodb.AttributeValue{}},
+		},
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("could not create API token: %v", err)
+	}
+
+	_, err = svc.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName:        aws.String(tableName),
+		Key:              key,
+		UpdateExpression: aws.String("SET #tokens.#id = :token"),
+		ExpressionAttributeNames: map[string]*string{
+			"#tokens": aws.String("apiTokens"),
+			"#id":     aws.String(id),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":token": value,
+		},
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("could not create API token: %v", err)
+	}
+
+	return formatAPIToken(user.Username, id, secret), id, nil
+}
+
+// Deletes one of a user's API tokens
+func revokeAPIToken(tableName string, username string, id string, svc *dynamodb.DynamoDB) error {
+
+	_, err := svc.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"username": {
+				S: aws.String(username),
+			},
+		},
+		UpdateExpression:    aws.String("REMOVE #tokens.#id"),
+		ConditionExpression: aws.String("attribute_exists(#tokens.#id)"),
+		ExpressionAttributeNames: map[string]*string{
+			"#tokens": aws.String("apiTokens"),
+			"#id":     aws.String(id),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("could not revoke API token: %v", err)
+	}
+
+	return nil
+}
+
+// Records when a token was last used
+func touchAPIToken(tableName string, username string, id string, svc *dynamodb.DynamoDB) error {
+
+	_, err := svc.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"username": {
+				S: aws.String(username),
+			},
+		},
+		UpdateExpression:    aws.String("SET #tokens.#id.#lastUsed = :now"),
+		ConditionExpression: aws.String("attribute_exists(#tokens.#id)"),
+		ExpressionAttributeNames: map[string]*string{
+			"#tokens":   aws.String("apiTokens"),
+			"#id":       aws.String(id),
+			"#lastUsed": aws.String("lastUsed"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":now": {S: aws.String(time.Now().Format(time.RFC3339))},
+		},
+	})
+
+	return err
+}
+
+// Checks an API token and returns the user it belongs to along with the token's scopes
+func verifyAPIToken(tableName string, token string, svc *dynamodb.DynamoDB) (User, []string, error) {
+
+	username, id, secret, err := parseAPIToken(token)
+	if err != nil {
+		return User{}, nil, err
+	}
+
+	user, err := getUser(tableName, username, svc)
+	if err != nil {
+		return User{}, nil, errors.New("invalid API token")
+	}
+
+	stored, exists := user.APITokens[id]
+	if !exists || subtle.ConstantTimeCompare([]byte(stored.Hash), []byte(hashAPITokenSecret(secret))) != 1 {
+		return User{}, nil, errors.New("invalid API token")
+	}
+
+	lastUsed, err := time.Parse(time.RFC3339, stored.LastUsed)
+	if err != nil || time.Since(lastUsed) > lastUsedResolution {
+		err = touchAPIToken(tableName, username, id, svc)
+		if err != nil {
+			log.Printf("could not record use of API token %v for %v: %v", id, username, err)
+		}
+	}
+
+	return user, stored.Scopes, nil
+}
+
+// Returns the bearer token a request was sent with, if any
+func bearerToken(c *gin.Context) (string, bool) {
+
+	header := c.GetHeader("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return "", false
+	}
+
+	return strings.TrimSpace(strings.TrimPrefix(header, "Bearer ")), true
+}
+
+// Works out who made a request and what they are allowed to do
+// Requests with an Authorization: Bearer header are checked against the user's API tokens and never
+// fall back to the session cookie. Everything else goes through checkToken and gets every scope
+func authenticate(c *gin.Context, tableName string, r *redis.Client, cookies *CookieCodec, svc *dynamodb.DynamoDB) (User, []string, error) {
+
+	if token, ok := bearerToken(c); ok {
+		return verifyAPIToken(tableName, token, svc)
+	}
+
+	auth, username := checkToken(c, r, cookies)
+	if !auth {
+		return User{}, nil, errors.New("not logged in")
+	}
+
+	user, err := getUser(tableName, username, svc)
+	if err != nil {
+		return User{}, nil, errors.New("not logged in")
+	}
+
+	return user, allScopes, nil
+}
+
+// Lists a user's API tokens without their hashes, newest first
+func apiTokenResources(user User) []APITokenResource {
+
+	final := make([]APITokenResource, 0, len(user.APITokens))
+	for id, token := range user.APITokens {
+		final = append(final, APITokenResource{
+			ID:       id,
+			Name:     token.Name,
+			Scopes:   token.Scopes,
+			Created:  token.Created,
+			LastUsed: token.LastUsed,
+		})
+	}
+	sort.Slice(final, func(i, j int) bool { return final[i].Created > final[j].Created })
+
+	return final
+}
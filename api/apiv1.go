@@ -0,0 +1,1188 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+
+	"main/export"
+)
+
+// Where version 1 of the JSON API is mounted
+const apiV1Path = "/api/v1"
+
+// Default and largest page sizes for paginated API routes
+const (
+	defaultPerPage = 50
+	maxPerPage     = 200
+)
+
+// Longest comment that can be left on a shoot
+const maxCommentLength = 2000
+
+// Largest photo that can be uploaded through the API
+const maxUploadBytes = 50 << 20
+
+// Everything the JSON API handlers need from main
+type APIv1 struct {
+	TableName     string
+	DataTable     string // Table for everything that is not a user
+	Bucket        string
+	Presigner     *PresignCache
+	Grace         time.Duration
+	MaxSelections int
+	BaseURL       string
+	Svc           **dynamodb.DynamoDB // Pointer to main's client since it gets renewed in the background
+	Client        *s3.S3
+	Redis         *redis.Client
+	Cookies       *CookieCodec
+	Mailer        Mailer
+}
+
+// One route of the JSON API
+// The same table registers the routes and generates the OpenAPI document
+type apiRoute struct {
+	Name     string // operationId in the OpenAPI document. Example: "listShoots"
+	Tag      string
+	Method   string
+	Path     string // Path under /api/v1 in gin syntax. Example: "/shoots/:id"
+	Summary  string
+	Auth     bool       // Whether the caller has to be logged in
+	Scope    string     // Scope an API token needs to use the route, cookie sessions have every scope
+	Request  any        // Zero value of the request body type, nil if there is no body
+	Response any        // Zero value of the response data type, nil if nothing is returned
+	List     bool       // Response is a paginated list of Response
+	Upload   bool       // Request is a multipart form with the file in the "file" field
+	Produces []string   // Content types of a file the route sends instead of JSON
+	Status   int        // Status code on success, defaults to 200
+	Query    []apiParam // Query parameters other than pagination
+	Errors   []int      // Error status codes the route can return other than 401 and 500
+	Handler  func(c *gin.Context, user User, scopes []string)
+}
+
+// Query parameter photographers use to act on one of their client's shoots
+var ownerParam = apiParam{Name: "owner", Type: "string", Description: "Username of the client whose shoots to use. Photographers only, and needs the " + scopeAdmin + " scope when it is not the caller. Defaults to the caller"}
+
+// Whether deleting a shoot also deletes the photos stored for it
+var purgeParam = apiParam{Name: "purge", Type: "boolean", Description: "true to also delete the originals, thumbnails and previews stored for the shoot. Photographers only, defaults to false"}
+
+// Query parameters of the picks export
+var exportParams = []apiParam{
+	{Name: "format", Type: "string", Description: "One of " + strings.Join(export.Formats, ", ") + ". filenames is a line to paste into Lightroom's " +
+		"Filename contains filter, csv has a row per pick, xmp is a zip of sidecars to put next to the RAW files. Defaults to filenames"},
+	{Name: "rating", Type: "integer", Description: "Stars the xmp sidecars give picks the client did not rate, 0 to leave it out. Defaults to " + strconv.Itoa(export.DefaultRating)},
+	{Name: "label", Type: "string", Description: "Color label the xmp sidecars give picks the client did not label, empty to leave it out. One of " +
+		strings.Join(export.Labels, ", ") + ". Defaults to " + export.DefaultLabel},
+}
+
+// Query parameters that sort and filter the photos in a shoot, the same ones the gallery page takes
+var viewParams = []apiParam{
+	{Name: "sort", Type: "string", Description: "One of " + sortName + ", " + sortCaptured + " (oldest first, photos whose capture time is still being read come last), " + sortRating + " (most stars first) or " +
+		sortFavorites + " (most favorited by guests first). Defaults to the order of the shoot"},
+	{Name: "filter", Type: "string", Description: "One of " + filterPicked + ", " + filterUnpicked + ", " + filterCommented + " or " + filterFavorited + ". Defaults to every photo"},
+	{Name: "category", Type: "string", Description: "Only list photos in this folder of the shoot. Example: ceremony"},
+	{Name: "stack", Type: "string", Description: "Key of a photo. Only lists the frames taken in the same burst as it, oldest first"},
+	{Name: "rating", Type: "integer", Description: "Only list photos rated at least this many stars. Defaults to 0, which lists every photo"},
+}
+
+// Query parameters of the zip download
+var downloadParams = []apiParam{
+	{Name: "scope", Type: "string", Description: "picks for the photos the client picked, delivered for every photo once the shoot has been " +
+		"delivered, or category for one folder of the shoot. Defaults to picks"},
+	{Name: "category", Type: "string", Description: "Folder of the shoot to download when scope is category. Example: ceremony"},
+}
+
+// The routes of version 1 of the JSON API
+func (api *APIv1) routes() []apiRoute {
+	return []apiRoute{
+		{Name: "getCSRFToken", Tag: "session", Method: http.MethodGet, Path: "/csrf", Summary: "Get the token to send in the " + csrfHeader + " header",
+			Response: CSRFResource{}, Handler: api.getCSRFToken},
+		{Name: "listShoots", Tag: "shoots", Method: http.MethodGet, Path: "/shoots", Summary: "List shoots",
+			Auth: true, Scope: scopeReadShoots, Response: ShootResource{}, List: true, Query: []apiParam{ownerParam},
+			Errors: []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound}, Handler: api.listShoots},
+		{Name: "createShoot", Tag: "shoots", Method: http.MethodPost, Path: "/shoots", Summary: "Create a shoot",
+			Auth: true, Scope: scopeAdmin, Request: ShootRequest{}, Response: ShootResource{}, Status: http.StatusCreated, Query: []apiParam{ownerParam},
+			Errors: []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound}, Handler: api.createShoot},
+		{Name: "getShoot", Tag: "shoots", Method: http.MethodGet, Path: "/shoots/:id", Summary: "Get a shoot",
+			Auth: true, Scope: scopeReadShoots, Response: ShootResource{}, Query: []apiParam{ownerParam},
+			Errors: []int{http.StatusForbidden, http.StatusNotFound}, Handler: api.getShoot},
+		{Name: "updateShoot", Tag: "shoots", Method: http.MethodPatch, Path: "/shoots/:id", Summary: "Rename a shoot, change its date or cover photo, or move its deadline",
+			Auth: true, Scope: scopeWriteShoots, Request: ShootUpdate{}, Response: ShootResource{}, Query: []apiParam{ownerParam},
+			Errors: []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusUnprocessableEntity}, Handler: api.updateShoot},
+		{Name: "deleteShoot", Tag: "shoots", Method: http.MethodDelete, Path: "/shoots/:id", Summary: "Delete a shoot",
+			Auth: true, Scope: scopeAdmin, Status: http.StatusNoContent, Query: []apiParam{purgeParam, ownerParam},
+			Errors: []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusConflict}, Handler: api.deleteShoot},
+		{Name: "setShootOrder", Tag: "shoots", Method: http.MethodPut, Path: "/shoots/order", Summary: "Set how the home page and shoot list are ordered",
+			Auth: true, Scope: scopeWriteShoots, Request: ShootOrder{}, Response: ShootOrder{}, Query: []apiParam{ownerParam},
+			Errors: []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusUnprocessableEntity}, Handler: api.setShootOrder},
+		{Name: "listPhotos", Tag: "photos", Method: http.MethodGet, Path: "/shoots/:id/photos", Summary: "List the photos in a shoot",
+			Auth: true, Scope: scopeReadShoots, Response: PhotoResource{}, List: true, Query: append(viewParams, ownerParam),
+			Errors: []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound}, Handler: api.listPhotos},
+		{Name: "uploadPhoto", Tag: "photos", Method: http.MethodPost, Path: "/shoots/:id/photos", Summary: "Upload a photo to a shoot",
+			Auth: true, Scope: scopeAdmin, Upload: true, Response: PhotoResource{}, Status: http.StatusCreated, Query: []apiParam{ownerParam},
+			Errors: []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity}, Handler: api.uploadPhoto},
+		{Name: "setRating", Tag: "photos", Method: http.MethodPut, Path: "/shoots/:id/photos/:key/rating", Summary: "Set the star rating and color label of a photo",
+			Auth: true, Scope: scopeWritePicks, Request: PhotoRating{}, Response: PhotoRating{}, Query: []apiParam{ownerParam},
+			Errors: []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound}, Handler: api.setRating},
+		{Name: "getPicks", Tag: "picks", Method: http.MethodGet, Path: "/shoots/:id/picks", Summary: "Get the picks for a shoot",
+			Auth: true, Scope: scopeReadShoots, Response: Picks{}, Query: []apiParam{ownerParam},
+			Errors: []int{http.StatusForbidden, http.StatusNotFound}, Handler: api.getPicks},
+		{Name: "setPicks", Tag: "picks", Method: http.MethodPut, Path: "/shoots/:id/picks", Summary: "Replace the picks for a shoot",
+			Auth: true, Scope: scopeWritePicks, Request: Picks{}, Response: Picks{}, Query: []apiParam{ownerParam},
+			Errors: []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusUnprocessableEntity}, Handler: api.setPicks},
+		{Name: "exportPicks", Tag: "picks", Method: http.MethodGet, Path: "/shoots/:id/export", Summary: "Export the picks for Lightroom",
+			Auth: true, Scope: scopeReadShoots, Produces: []string{"text/plain", "text/csv", "application/zip"}, Query: append(exportParams, ownerParam),
+			Errors: []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound}, Handler: api.exportPicks},
+		{Name: "downloadPhotos", Tag: "photos", Method: http.MethodGet, Path: "/shoots/:id/download", Summary: "Download a zip of the originals in a shoot",
+			Auth: true, Scope: scopeReadShoots, Produces: []string{"application/zip"}, Query: append(downloadParams, ownerParam),
+			Errors: []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound}, Handler: api.downloadPhotos},
+		{Name: "listComments", Tag: "comments", Method: http.MethodGet, Path: "/shoots/:id/comments", Summary: "List the comments on a shoot",
+			Auth: true, Scope: scopeReadShoots, Response: Comment{}, List: true, Query: []apiParam{ownerParam},
+			Errors: []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound}, Handler: api.listComments},
+		{Name: "addComment", Tag: "comments", Method: http.MethodPost, Path: "/shoots/:id/comments", Summary: "Comment on a shoot or one of its photos",
+			Auth: true, Scope: scopeWritePicks, Request: CommentRequest{}, Response: Comment{}, Status: http.StatusCreated, Query: []apiParam{ownerParam},
+			Errors: []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusUnprocessableEntity}, Handler: api.addComment},
+		{Name: "listFavorites", Tag: "shares", Method: http.MethodGet, Path: "/shoots/:id/favorites", Summary: "List the photos guests on share links favorited, most favorited first",
+			Auth: true, Scope: scopeReadShoots, Response: FavoriteResource{}, List: true, Query: []apiParam{ownerParam},
+			Errors: []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound}, Handler: api.listFavorites},
+		{Name: "listShares", Tag: "shares", Method: http.MethodGet, Path: "/shoots/:id/shares", Summary: "List the share links on a shoot",
+			Auth: true, Scope: scopeReadShoots, Response: ShareResource{}, List: true, Query: []apiParam{ownerParam},
+			Errors: []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound}, Handler: api.listShares},
+		{Name: "createShare", Tag: "shares", Method: http.MethodPost, Path: "/shoots/:id/shares", Summary: "Make a share link for a shoot",
+			Auth: true, Scope: scopeWriteShares, Request: ShareRequest{}, Response: ShareResource{}, Status: http.StatusCreated, Query: []apiParam{ownerParam},
+			Errors: []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusUnprocessableEntity}, Handler: api.createShare},
+		{Name: "revokeShare", Tag: "shares", Method: http.MethodDelete, Path: "/shoots/:id/shares/:shareID", Summary: "Revoke a share link",
+			Auth: true, Scope: scopeWriteShares, Status: http.StatusNoContent, Query: []apiParam{ownerParam},
+			Errors: []int{http.StatusForbidden, http.StatusNotFound}, Handler: api.revokeShare},
+		{Name: "getCurrentUser", Tag: "users", Method: http.MethodGet, Path: "/users/me", Summary: "Get the logged in user",
+			Auth: true, Scope: scopeReadShoots, Response: UserResource{}, Handler: api.getCurrentUser},
+		{Name: "listUsers", Tag: "users", Method: http.MethodGet, Path: "/users", Summary: "List users",
+			Auth: true, Scope: scopeAdmin, Response: UserResource{}, List: true,
+			Errors: []int{http.StatusBadRequest, http.StatusForbidden}, Handler: api.listUsers},
+		{Name: "getUser", Tag: "users", Method: http.MethodGet, Path: "/users/:username", Summary: "Get a user",
+			Auth: true, Scope: scopeReadShoots, Response: UserResource{},
+			Errors: []int{http.StatusForbidden, http.StatusNotFound}, Handler: api.getUser},
+	}
+}
+
+// Adds the JSON API routes and the OpenAPI document to the router
+func (api *APIv1) register(r *gin.Engine) {
+
+	routes := api.routes()
+	spec := openAPISpec("Client Photos API", "1.0.0", apiV1Path, routes)
+
+	group := r.Group(apiV1Path)
+
+	group.GET("/openapi.json", func(c *gin.Context) {
+		c.JSON(http.StatusOK, spec)
+	})
+
+	for _, route := range routes {
+		route := route
+		group.Handle(route.Method, route.Path, func(c *gin.Context) {
+			var user User
+			var scopes []string
+			if route.Auth {
+				var ok bool
+				user, scopes, ok = api.authenticate(c)
+				if !ok {
+					return
+				}
+				if !hasScope(scopes, route.Scope) {
+					apiError(c, http.StatusForbidden, fmt.Errorf("API token needs the %v scope", route.Scope))
+					return
+				}
+			}
+			route.Handler(c, user, scopes)
+		})
+	}
+}
+
+// Sends an error in the API's error envelope
+// The error code is worked out from the status. Example: 404 becomes "not_found"
+func apiError(c *gin.Context, status int, err error) {
+	apiErrorDetails(c, status, err, nil)
+}
+
+// Sends an error in the API's error envelope with extra details for the client to act on
+func apiErrorDetails(c *gin.Context, status int, err error, details any) {
+
+	code := strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+	if code == "" {
+		code = "error"
+	}
+
+	c.AbortWithStatusJSON(status, APIErrorResponse{Error: APIError{
+		Code:    code,
+		Message: err.Error(),
+		Details: details,
+	}})
+}
+
+// Sends a single resource
+func apiData(c *gin.Context, status int, data any) {
+	c.JSON(status, gin.H{"data": data})
+}
+
+// Reads the page and per_page query parameters
+func parsePagination(c *gin.Context) (int, int, error) {
+
+	page, perPage := 1, defaultPerPage
+
+	if value := c.Query("page"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			return 0, 0, errors.New("page must be a whole number of at least 1")
+		}
+		page = parsed
+	}
+
+	if value := c.Query("per_page"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxPerPage {
+			return 0, 0, fmt.Errorf("per_page must be a whole number from 1 to %v", maxPerPage)
+		}
+		perPage = parsed
+	}
+
+	return page, perPage, nil
+}
+
+// Cuts one page out of a list
+func paginate[T any](items []T, page int, perPage int) ([]T, Pagination) {
+
+	pagination := Pagination{
+		Page:       page,
+		PerPage:    perPage,
+		Total:      len(items),
+		TotalPages: int(math.Ceil(float64(len(items)) / float64(perPage))),
+	}
+
+	start := (page - 1) * perPage
+	if start >= len(items) {
+		return []T{}, pagination
+	}
+	end := start + perPage
+	if end > len(items) {
+		end = len(items)
+	}
+
+	return items[start:end], pagination
+}
+
+// Sends one page of a list, reading the page from the query string
+func apiList[T any](c *gin.Context, items []T) {
+
+	page, perPage, err := parsePagination(c)
+	if err != nil {
+		apiError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	data, pagination := paginate(items, page, perPage)
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":       data,
+		"pagination": pagination,
+	})
+}
+
+// Looks up who made an API request, from either an API token or the session cookie
+// Unlike the page routes this never redirects, API clients get a 401 instead
+func (api *APIv1) authenticate(c *gin.Context) (User, []string, bool) {
+
+	user, scopes, err := authenticate(c, api.TableName, api.Redis, api.Cookies, *api.Svc)
+	if err != nil {
+		c.Header("WWW-Authenticate", `Bearer realm="api"`)
+		apiError(c, http.StatusUnauthorized, err)
+		return User{}, nil, false
+	}
+
+	return user, scopes, true
+}
+
+// Works out whose shoots a request is about
+// Clients can only use their own shoots, photographers can pass owner to use a client's
+// Using someone else's shoots needs the admin scope, so a photographer's narrower tokens only reach their own
+func (api *APIv1) shootOwner(c *gin.Context, user User, scopes []string) (User, bool) {
+
+	owner := strings.ToLower(c.Query("owner"))
+	if owner == "" || owner == user.Username {
+		return user, true
+	}
+
+	if !isPhotographer(user) {
+		apiError(c, http.StatusForbidden, errors.New("only photographers can use other users' shoots"))
+		return User{}, false
+	}
+	if !hasScope(scopes, scopeAdmin) {
+		apiError(c, http.StatusForbidden, fmt.Errorf("API token needs the %v scope to use other users' shoots", scopeAdmin))
+		return User{}, false
+	}
+
+	ownerUser, err := getUser(api.TableName, owner, *api.Svc)
+	if err != nil {
+		apiError(c, http.StatusNotFound, errors.New("user does not exist"))
+		return User{}, false
+	}
+
+	return ownerUser, true
+}
+
+// Finds the shoot a request is about
+// Returns the owner of the shoot along with its id and the shoot itself
+func (api *APIv1) findShoot(c *gin.Context, user User, scopes []string) (User, string, Shoot, bool) {
+
+	owner, ok := api.shootOwner(c, user, scopes)
+	if !ok {
+		return User{}, "", Shoot{}, false
+	}
+
+	id := c.Param("id")
+	shoot, exists := owner.Shoots[id]
+	if !exists || id == placeholderShoot {
+		apiError(c, http.StatusNotFound, errors.New("shoot does not exist"))
+		return User{}, "", Shoot{}, false
+	}
+
+	return owner, id, shoot, true
+}
+
+// Builds the API view of a shoot
+func newShootResource(id string, shoot Shoot, grace time.Duration) ShootResource {
+
+	cover := ""
+	if shoot.Thumbnail != "" {
+		cover = photoKey(shoot.Thumbnail)
+	}
+
+	return ShootResource{
+		ID:          id,
+		Name:        shootDisplayName(id, shoot),
+		Date:        shoot.Date,
+		Deadline:    shoot.Deadline,
+		Locked:      selectionsLocked(shoot, grace),
+		PickCount:   len(shoot.Picks.Picks),
+		MaxPicks:    shoot.MaxPicks,
+		Downloads:   shoot.Downloads,
+		SubmittedAt: shoot.SubmittedAt,
+		DeliveredAt: shoot.DeliveredAt,
+		Cover:       cover,
+	}
+}
+
+// Builds the API view of a user
+func newUserResource(user User) UserResource {
+	return UserResource{
+		Username:  user.Username,
+		First:     user.First_name,
+		Last:      user.Last_name,
+		Email:     user.Email,
+		Role:      user.Role,
+		Verified:  !user.Unverified,
+		TwoFactor: user.TOTPEnabled,
+	}
+}
+
+func (api *APIv1) listShoots(c *gin.Context, user User, scopes []string) {
+
+	owner, ok := api.shootOwner(c, user, scopes)
+	if !ok {
+		return
+	}
+
+	shoots := []ShootResource{}
+	for _, id := range orderedShootIDs(owner) {
+		shoots = append(shoots, newShootResource(id, owner.Shoots[id], api.Grace))
+	}
+
+	apiList(c, shoots)
+}
+
+func (api *APIv1) createShoot(c *gin.Context, user User, scopes []string) {
+
+	if !isPhotographer(user) {
+		apiError(c, http.StatusForbidden, errors.New("only photographers can create shoots"))
+		return
+	}
+
+	owner, ok := api.shootOwner(c, user, scopes)
+	if !ok {
+		return
+	}
+
+	var request ShootRequest
+	err := c.ShouldBindJSON(&request)
+	if err != nil {
+		apiError(c, http.StatusBadRequest, errors.New("body must be a JSON shoot"))
+		return
+	}
+
+	shoot, err := newShoot(request)
+	if err != nil {
+		apiError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	err = recordShootStorage(api.DataTable, owner.Username, shoot, *api.Svc)
+	if err != nil {
+		log.Println(err)
+		apiError(c, http.StatusInternalServerError, errors.New("could not create shoot"))
+		return
+	}
+
+	id := newShootID(shoot.Name, owner.Shoots)
+	err = addShoot(api.TableName, owner.Username, id, shoot, *api.Svc)
+	if err != nil {
+		log.Printf("could not add shoot for %v: %v", owner.Username, err)
+		apiError(c, http.StatusInternalServerError, errors.New("could not create shoot"))
+		return
+	}
+	err = deletePlaceHolder(*api.Svc, owner.Username, api.TableName)
+	if err != nil {
+		log.Println(err)
+	}
+
+	notify(api.Mailer, owner.Email, "Your photos from "+shoot.Name+" are ready", "shoot_ready.html", newEmailData(api.BaseURL, owner, id, shoot))
+
+	apiData(c, http.StatusCreated, newShootResource(id, shoot, api.Grace))
+}
+
+func (api *APIv1) getShoot(c *gin.Context, user User, scopes []string) {
+
+	_, id, shoot, ok := api.findShoot(c, user, scopes)
+	if !ok {
+		return
+	}
+
+	apiData(c, http.StatusOK, newShootResource(id, shoot, api.Grace))
+}
+
+func (api *APIv1) updateShoot(c *gin.Context, user User, scopes []string) {
+
+	owner, id, shoot, ok := api.findShoot(c, user, scopes)
+	if !ok {
+		return
+	}
+
+	var update ShootUpdate
+	err := c.ShouldBindJSON(&update)
+	if err != nil {
+		apiError(c, http.StatusBadRequest, errors.New("body must be a JSON shoot update"))
+		return
+	}
+
+	shoot, err = updateShoot(api.TableName, api.Redis, api.Client, api.Bucket, owner.Username, id, shoot, update, isPhotographer(user), api.Grace, *api.Svc)
+	var shootErr *ShootError
+	if errors.As(err, &shootErr) {
+		apiError(c, shootErr.Status, shootErr)
+		return
+	}
+	if err != nil {
+		log.Printf("could not update shoot %v for %v: %v", id, owner.Username, err)
+		apiError(c, http.StatusInternalServerError, errors.New("could not update shoot"))
+		return
+	}
+
+	apiData(c, http.StatusOK, newShootResource(id, shoot, api.Grace))
+}
+
+func (api *APIv1) deleteShoot(c *gin.Context, user User, scopes []string) {
+
+	purge, err := strconv.ParseBool(c.DefaultQuery("purge", "false"))
+	if err != nil {
+		apiError(c, http.StatusBadRequest, errors.New("purge must be true or false"))
+		return
+	}
+	if !isPhotographer(user) {
+		apiError(c, http.StatusForbidden, errors.New("only photographers can delete shoots"))
+		return
+	}
+
+	owner, id, shoot, ok := api.findShoot(c, user, scopes)
+	if !ok {
+		return
+	}
+
+	deleted, err := deleteShoot(api.TableName, api.DataTable, api.Redis, api.Client, api.Bucket, owner.Username, id, shoot, purge, *api.Svc)
+	var shootErr *ShootError
+	if errors.As(err, &shootErr) {
+		apiError(c, shootErr.Status, shootErr)
+		return
+	}
+	if err != nil {
+		log.Printf("could not delete shoot %v for %v: %v", id, owner.Username, err)
+		apiError(c, http.StatusInternalServerError, errors.New("could not delete shoot"))
+		return
+	}
+
+	log.Printf("%v deleted shoot %v for %v along with %v stored objects", user.Username, id, owner.Username, deleted)
+
+	c.Status(http.StatusNoContent)
+}
+
+func (api *APIv1) setShootOrder(c *gin.Context, user User, scopes []string) {
+
+	owner, ok := api.shootOwner(c, user, scopes)
+	if !ok {
+		return
+	}
+
+	var order ShootOrder
+	err := c.ShouldBindJSON(&order)
+	if err != nil {
+		apiError(c, http.StatusBadRequest, errors.New("body must be a JSON shoot order"))
+		return
+	}
+
+	order, err = checkShootOrder(order, owner.Shoots)
+	var shootErr *ShootError
+	if errors.As(err, &shootErr) {
+		apiError(c, shootErr.Status, shootErr)
+		return
+	}
+
+	err = setShootOrder(api.TableName, owner.Username, order, *api.Svc)
+	if err != nil {
+		log.Printf("could not order shoots for %v: %v", owner.Username, err)
+		apiError(c, http.StatusInternalServerError, errors.New("could not save shoot order"))
+		return
+	}
+
+	apiData(c, http.StatusOK, order)
+}
+
+func (api *APIv1) listPhotos(c *gin.Context, user User, scopes []string) {
+
+	owner, id, shoot, ok := api.findShoot(c, user, scopes)
+	if !ok {
+		return
+	}
+
+	page, perPage, err := parsePagination(c)
+	if err != nil {
+		apiError(c, http.StatusBadRequest, err)
+		return
+	}
+	view, err := parseGalleryView(c.Request.URL.Query())
+	if err != nil {
+		apiError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	objects, err := shootThumbnails(api.Redis, api.Client, api.Bucket, shoot)
+	var favorites map[string]int
+	if err == nil {
+		favorites, err = viewFavorites(api.DataTable, owner.Username, id, view, *api.Svc)
+	}
+	if err != nil {
+		log.Printf("could not list photos in %v: %v", shoot.Prefix, err)
+		apiError(c, http.StatusInternalServerError, errors.New("could not list photos"))
+		return
+	}
+
+	objects = applyView(api.Redis, api.Client, api.Bucket, shoot, objects, favorites, view)
+
+	// Only the page being sent needs pre-signed urls
+	keys, pagination := paginate(objects, page, perPage)
+	urls, _, err := api.Presigner.URLs(keys)
+	if err != nil {
+		log.Printf("could not presign photos in %v: %v", shoot.Prefix, err)
+		apiError(c, http.StatusInternalServerError, errors.New("could not list photos"))
+		return
+	}
+	photos := make([]PhotoResource, 0, len(keys))
+	for i, key := range keys {
+		rating := shoot.Ratings[photoKey(key)]
+		photos = append(photos, PhotoResource{Key: photoKey(key), URL: urls[i], Rating: rating.Rating, Label: rating.Label})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":       photos,
+		"pagination": pagination,
+	})
+}
+
+func (api *APIv1) setRating(c *gin.Context, user User, scopes []string) {
+
+	owner, id, shoot, ok := api.findShoot(c, user, scopes)
+	if !ok {
+		return
+	}
+
+	if !canRate(user, shoot, api.Grace) {
+		apiError(c, http.StatusForbidden, errors.New("selections for this shoot are locked"))
+		return
+	}
+
+	var request PhotoRating
+	err := c.ShouldBindJSON(&request)
+	if err != nil {
+		apiError(c, http.StatusBadRequest, errors.New("body must be a JSON object with a rating and label"))
+		return
+	}
+	rating, err := checkRating(request)
+	if err != nil {
+		apiError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	key := c.Param("key")
+	available, err := shootPhotoKeys(api.Redis, api.Client, api.Bucket, shoot)
+	if err != nil {
+		log.Printf("could not list photos in %v: %v", id, err)
+		apiError(c, http.StatusInternalServerError, errors.New("could not check photo"))
+		return
+	}
+	if !available[key] {
+		apiError(c, http.StatusNotFound, errors.New("photo is not in this shoot"))
+		return
+	}
+
+	err = setPhotoRating(api.TableName, owner.Username, id, key, rating, *api.Svc)
+	if err != nil {
+		log.Printf("could not rate %v in %v: %v", key, id, err)
+		apiError(c, http.StatusInternalServerError, errors.New("could not save rating"))
+		return
+	}
+
+	apiData(c, http.StatusOK, rating)
+}
+
+func (api *APIv1) getPicks(c *gin.Context, user User, scopes []string) {
+
+	_, _, shoot, ok := api.findShoot(c, user, scopes)
+	if !ok {
+		return
+	}
+
+	picks := shoot.Picks
+	if picks.Picks == nil {
+		picks.Picks = []string{}
+	}
+	picks.Count = len(picks.Picks)
+
+	apiData(c, http.StatusOK, picks)
+}
+
+func (api *APIv1) setPicks(c *gin.Context, user User, scopes []string) {
+
+	owner, id, shoot, ok := api.findShoot(c, user, scopes)
+	if !ok {
+		return
+	}
+
+	if selectionsLocked(shoot, api.Grace) {
+		apiError(c, http.StatusForbidden, errors.New("selections for this shoot are locked"))
+		return
+	}
+
+	var submitted Picks
+	err := c.ShouldBindJSON(&submitted)
+	if err != nil {
+		apiError(c, http.StatusBadRequest, errors.New("body must be a JSON object with a list of photo keys"))
+		return
+	}
+
+	picks, err := checkPicks(api.Redis, api.Client, api.Bucket, shoot, submitted.Picks, api.MaxSelections)
+	var picksErr *PicksError
+	if errors.As(err, &picksErr) {
+		apiErrorDetails(c, http.StatusUnprocessableEntity, picksErr, gin.H{
+			"rejected": picksErr.Rejected,
+			"limit":    picksErr.Limit,
+			"count":    picksErr.Count,
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("could not check picks for %v: %v", id, err)
+		apiError(c, http.StatusInternalServerError, errors.New("could not check picks"))
+		return
+	}
+
+	err = updatePicks(api.TableName, owner.Username, id, picks, *api.Svc)
+	if err != nil {
+		log.Printf("could not save picks for %v: %v", id, err)
+		apiError(c, http.StatusInternalServerError, errors.New("could not save picks"))
+		return
+	}
+
+	apiData(c, http.StatusOK, picks)
+}
+
+func (api *APIv1) exportPicks(c *gin.Context, user User, scopes []string) {
+
+	_, id, shoot, ok := api.findShoot(c, user, scopes)
+	if !ok {
+		return
+	}
+
+	format := c.DefaultQuery("format", export.FormatFilenames)
+	if !export.ValidFormat(format) {
+		apiError(c, http.StatusBadRequest, fmt.Errorf("format must be one of %v", strings.Join(export.Formats, ", ")))
+		return
+	}
+
+	options := export.Options{Rating: export.DefaultRating, Label: c.DefaultQuery("label", export.DefaultLabel)}
+	if value, given := c.GetQuery("rating"); given {
+		rating, err := strconv.Atoi(value)
+		if err != nil {
+			apiError(c, http.StatusBadRequest, errors.New("rating must be a whole number"))
+			return
+		}
+		options.Rating = rating
+	}
+	if format == export.FormatXMP {
+		var err error
+		options, err = options.Validate()
+		if err != nil {
+			apiError(c, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	exported := export.Shoot{
+		ID:          id,
+		Name:        shootDisplayName(id, shoot),
+		SubmittedAt: shoot.SubmittedAt,
+		Picks:       shoot.Picks.Picks,
+		Comments:    shoot.Comments,
+		Ratings:     shoot.Ratings,
+	}
+
+	// Built in memory first so a failure can still be sent as a JSON error
+	var body bytes.Buffer
+	err := export.Write(&body, format, exported, options)
+	if err != nil {
+		log.Printf("could not export picks for %v: %v", id, err)
+		apiError(c, http.StatusInternalServerError, errors.New("could not export picks"))
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", export.FileName(id, format)))
+	c.Data(http.StatusOK, export.ContentType(format), body.Bytes())
+}
+
+func (api *APIv1) downloadPhotos(c *gin.Context, user User, scopes []string) {
+
+	_, id, shoot, ok := api.findShoot(c, user, scopes)
+	if !ok {
+		return
+	}
+
+	scope := c.DefaultQuery("scope", downloadPicks)
+	entries, err := planDownload(api.Client, api.Bucket, shoot, scope, c.Query("category"))
+	var downloadErr *DownloadError
+	if errors.As(err, &downloadErr) {
+		apiError(c, downloadErr.Status, downloadErr)
+		return
+	}
+	if err != nil {
+		log.Printf("could not plan download of %v: %v", id, err)
+		apiError(c, http.StatusInternalServerError, errors.New("could not list photos"))
+		return
+	}
+
+	err = streamDownload(c, api.Client, api.Bucket, id, scope, entries)
+	if err != nil {
+		log.Printf("download of %v for %v failed: %v", id, user.Username, err)
+	}
+}
+
+func (api *APIv1) listComments(c *gin.Context, user User, scopes []string) {
+
+	_, _, shoot, ok := api.findShoot(c, user, scopes)
+	if !ok {
+		return
+	}
+
+	comments := shoot.Comments
+	if comments == nil {
+		comments = []Comment{}
+	}
+
+	apiList(c, comments)
+}
+
+func (api *APIv1) addComment(c *gin.Context, user User, scopes []string) {
+
+	owner, id, shoot, ok := api.findShoot(c, user, scopes)
+	if !ok {
+		return
+	}
+
+	var request CommentRequest
+	err := c.ShouldBindJSON(&request)
+	if err != nil {
+		apiError(c, http.StatusBadRequest, errors.New("body must be a JSON comment"))
+		return
+	}
+
+	request.Text = strings.TrimSpace(request.Text)
+	if request.Text == "" {
+		apiError(c, http.StatusBadRequest, errors.New("comment can not be empty"))
+		return
+	}
+	if len(request.Text) > maxCommentLength {
+		apiError(c, http.StatusBadRequest, fmt.Errorf("comments can be at most %v characters", maxCommentLength))
+		return
+	}
+
+	if request.Photo != "" {
+		available, err := shootPhotoKeys(api.Redis, api.Client, api.Bucket, shoot)
+		if err != nil {
+			log.Printf("could not list photos in %v: %v", id, err)
+			apiError(c, http.StatusInternalServerError, errors.New("could not check photo"))
+			return
+		}
+		if !available[request.Photo] {
+			apiError(c, http.StatusUnprocessableEntity, errors.New("photo is not in this shoot"))
+			return
+		}
+	}
+
+	commentID, err := generateURLToken()
+	if err != nil {
+		apiError(c, http.StatusInternalServerError, errors.New("could not save comment"))
+		return
+	}
+
+	comment := Comment{
+		ID:      commentID,
+		Author:  user.Username,
+		Photo:   request.Photo,
+		Text:    request.Text,
+		Created: time.Now().Format(time.RFC3339),
+	}
+
+	err = appendComment(api.TableName, owner.Username, id, comment, *api.Svc)
+	if err != nil {
+		log.Printf("could not save comment on %v: %v", id, err)
+		apiError(c, http.StatusInternalServerError, errors.New("could not save comment"))
+		return
+	}
+
+	apiData(c, http.StatusCreated, comment)
+}
+
+func (api *APIv1) listFavorites(c *gin.Context, user User, scopes []string) {
+
+	owner, id, _, ok := api.findShoot(c, user, scopes)
+	if !ok {
+		return
+	}
+
+	favorites, err := shootFavorites(api.DataTable, owner.Username, id, *api.Svc)
+	if err != nil {
+		log.Printf("could not get favorites on %v: %v", id, err)
+		apiError(c, http.StatusInternalServerError, errors.New("could not get favorites"))
+		return
+	}
+
+	apiList(c, favoriteResources(favorites))
+}
+
+func (api *APIv1) listShares(c *gin.Context, user User, scopes []string) {
+
+	_, _, shoot, ok := api.findShoot(c, user, scopes)
+	if !ok {
+		return
+	}
+
+	apiList(c, shareResources(shoot))
+}
+
+func (api *APIv1) createShare(c *gin.Context, user User, scopes []string) {
+
+	owner, id, shoot, ok := api.findShoot(c, user, scopes)
+	if !ok {
+		return
+	}
+
+	var request ShareRequest
+	err := c.ShouldBindJSON(&request)
+	if err != nil {
+		apiError(c, http.StatusBadRequest, errors.New("body must be a JSON share link"))
+		return
+	}
+
+	link, err := newShareLink(request, user.Username, shoot, time.Now())
+	var shareErr *ShareError
+	if errors.As(err, &shareErr) {
+		apiError(c, shareErr.Status, shareErr)
+		return
+	}
+	if err != nil {
+		log.Printf("could not make share link for %v: %v", id, err)
+		apiError(c, http.StatusInternalServerError, errors.New("could not make share link"))
+		return
+	}
+
+	token, shareID, err := addShareLink(api.TableName, api.DataTable, owner.Username, id, link, *api.Svc)
+	if err != nil {
+		log.Printf("could not make share link for %v: %v", id, err)
+		apiError(c, http.StatusInternalServerError, errors.New("could not make share link"))
+		return
+	}
+
+	log.Printf("%v made share link %v for %v with permissions %v", user.Username, shareID, id, link.Permissions)
+
+	share := newShareResource(shareID, link)
+	share.URL = shareURL(api.BaseURL, token)
+
+	apiData(c, http.StatusCreated, share)
+}
+
+func (api *APIv1) revokeShare(c *gin.Context, user User, scopes []string) {
+
+	owner, id, _, ok := api.findShoot(c, user, scopes)
+	if !ok {
+		return
+	}
+
+	shareID := c.Param("shareID")
+	revoked, err := revokeShareLink(api.TableName, api.DataTable, owner.Username, id, shareID, *api.Svc)
+	if err != nil {
+		log.Printf("could not revoke share link %v on %v: %v", shareID, id, err)
+		apiError(c, http.StatusInternalServerError, errors.New("could not revoke share link"))
+		return
+	}
+	if !revoked {
+		apiError(c, http.StatusNotFound, errors.New("share link does not exist"))
+		return
+	}
+
+	log.Printf("%v revoked share link %v on %v", user.Username, shareID, id)
+
+	c.Status(http.StatusNoContent)
+}
+
+func (api *APIv1) getCurrentUser(c *gin.Context, user User, scopes []string) {
+	apiData(c, http.StatusOK, newUserResource(user))
+}
+
+func (api *APIv1) listUsers(c *gin.Context, user User, scopes []string) {
+
+	if user.Role != roleAdmin {
+		apiError(c, http.StatusForbidden, errors.New("only admins can list users"))
+		return
+	}
+
+	users, err := scanUsers(api.TableName, *api.Svc)
+	if err != nil {
+		log.Printf("could not scan users: %v", err)
+		apiError(c, http.StatusInternalServerError, errors.New("could not list users"))
+		return
+	}
+
+	final := make([]UserResource, 0, len(users))
+	for _, each := range users {
+		final = append(final, newUserResource(each))
+	}
+	sort.Slice(final, func(i, j int) bool { return final[i].Username < final[j].Username })
+
+	apiList(c, final)
+}
+
+func (api *APIv1) getUser(c *gin.Context, user User, scopes []string) {
+
+	username := strings.ToLower(c.Param("username"))
+	if username != user.Username && !isPhotographer(user) {
+		apiError(c, http.StatusForbidden, errors.New("you can only look up your own account"))
+		return
+	}
+	if username != user.Username && !hasScope(scopes, scopeAdmin) {
+		apiError(c, http.StatusForbidden, fmt.Errorf("API token needs the %v scope to look up other users", scopeAdmin))
+		return
+	}
+
+	found, err := getUser(api.TableName, username, *api.Svc)
+	if err != nil {
+		apiError(c, http.StatusNotFound, errors.New("user does not exist"))
+		return
+	}
+
+	apiData(c, http.StatusOK, newUserResource(found))
+}
+
+// Adds a comment to the end of a shoot's comments
+func appendComment(tableName string, username string, shootID string, comment Comment, svc *dynamodb.DynamoDB) error {
+
+	newComment, err := dynamodbattribute.Marshal([]Comment{comment})
+	if err != nil {
+		return fmt.Errorf("could not marshal comment: %v", err)
+	}
+
+	// The comments path starts with the path to the shoot, so the names cover both
+	shoot, _ := shootPath(shootID)
+	comments, names := shootPath(shootID, "comments")
+
+	updateInput := &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"username": {
+				S: aws.String(username),
+			},
+		},
+		UpdateExpression:         aws.String("SET " + comments + " = list_append(if_not_exists(" + comments + ", :empty), :comment)"),
+		ConditionExpression:      aws.String("attribute_exists(" + shoot + ")"),
+		ExpressionAttributeNames: names,
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":comment": newComment,
+			":empty":   {L: []*dynamodb.AttributeValue{}},
+		},
+	}
+
+	_, err = svc.UpdateItem(updateInput)
+	if err != nil {
+		return fmt.Errorf("could not add comment: %v", err)
+	}
+
+	return nil
+}
+
+func (api *APIv1) getCSRFToken(c *gin.Context, user User, scopes []string) {
+	apiData(c, http.StatusOK, CSRFResource{Token: csrfToken(c)})
+}
+
+func (api *APIv1) uploadPhoto(c *gin.Context, user User, scopes []string) {
+
+	if !isPhotographer(user) {
+		apiError(c, http.StatusForbidden, errors.New("only photographers can upload photos"))
+		return
+	}
+
+	owner, id, shoot, ok := api.findShoot(c, user, scopes)
+	if !ok {
+		return
+	}
+	if shoot.Prefix == "" {
+		apiError(c, http.StatusUnprocessableEntity, errors.New("shoot does not have an S3 prefix to upload to"))
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxUploadBytes)
+	header, err := c.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			apiError(c, http.StatusRequestEntityTooLarge, fmt.Errorf("photos can be at most %v MB", maxUploadBytes>>20))
+			return
+		}
+		apiError(c, http.StatusBadRequest, errors.New("upload the photo in the file field of a multipart form"))
+		return
+	}
+
+	name := path.Base(strings.ReplaceAll(header.Filename, "\\", "/"))
+	if name == "." || name == "/" || strings.HasPrefix(name, ".") {
+		apiError(c, http.StatusBadRequest, errors.New("photo needs a file name"))
+		return
+	}
+
+	if isRendition(name) {
+		apiError(c, http.StatusBadRequest, fmt.Errorf("photo names can not contain %v or %v, they are kept for renditions", thumbnailSuffix, previewSuffix))
+		return
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		apiError(c, http.StatusBadRequest, errors.New("could not read upload"))
+		return
+	}
+	defer file.Close()
+
+	// Only JPEGs can be shown in the gallery
+	sniff := make([]byte, 512)
+	n, _ := io.ReadFull(file, sniff)
+	if http.DetectContentType(sniff[:n]) != "image/jpeg" {
+		apiError(c, http.StatusUnprocessableEntity, errors.New("photos must be JPEGs"))
+		return
+	}
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		apiError(c, http.StatusInternalServerError, errors.New("could not read upload"))
+		return
+	}
+
+	key := strings.TrimSuffix(shoot.Prefix, "/") + "/" + name
+	_, err = api.Client.PutObject(&s3.PutObjectInput{
+		Bucket:      aws.String(api.Bucket),
+		Key:         aws.String(key),
+		Body:        file,
+		ContentType: aws.String("image/jpeg"),
+	})
+	if err != nil {
+		log.Printf("could not upload %v: %v", key, err)
+		apiError(c, http.StatusInternalServerError, errors.New("could not upload photo"))
+		return
+	}
+
+	// The gallery only shows renditions, so the photo needs them before anyone can see it
+	_, err = file.Seek(0, io.SeekStart)
+	if err == nil {
+		err = uploadRenditions(api.Client, api.Bucket, key, file)
+	}
+	if err != nil {
+		log.Printf("could not make renditions of %v: %v", key, err)
+		apiError(c, http.StatusInternalServerError, errors.New("photo was uploaded but its thumbnail could not be made"))
+		return
+	}
+	forgetThumbnails(api.Redis, api.Bucket, shoot.Prefix)
+
+	// Keep when the photo was taken now while it is at hand, so sorting by it never has to read it back from S3
+	// Photos that do not say fall back to when they were uploaded, the same as readCaptureTime
+	var taken time.Time
+	known := false
+	if _, err = file.Seek(0, io.SeekStart); err == nil {
+		data, _ := io.ReadAll(io.LimitReader(file, exifReadBytes))
+		taken, known = exifCaptureTime(data)
+	}
+	if !known {
+		taken = time.Now().UTC()
+	}
+	rememberCaptureTime(api.Redis, api.Bucket, thumbnailKey(key), taken, true)
+
+	// Shoots with a file list only accept picks from the list, so the new photo has to go on it
+	if len(shoot.Files) > 0 && !containsString(shoot.Files, key) {
+		files, _ := dynamodbattribute.Marshal(append(shoot.Files, key))
+		err = setShootProperty(api.TableName, owner.Username, id, "files", files, *api.Svc)
+		if err != nil {
+			log.Printf("could not add %v to the files of %v: %v", key, id, err)
+			apiError(c, http.StatusInternalServerError, errors.New("photo was uploaded but could not be added to the shoot"))
+			return
+		}
+	}
+
+	url, _, err := api.Presigner.URL(thumbnailKey(key))
+	if err != nil {
+		log.Printf("could not presign %v: %v", key, err)
+	}
+
+	apiData(c, http.StatusCreated, PhotoResource{Key: photoKey(key), URL: url})
+}
+
+// Reports whether a slice holds a string
+func containsString(list []string, value string) bool {
+	for _, each := range list {
+		if each == value {
+			return true
+		}
+	}
+	return false
+}
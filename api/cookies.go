@@ -0,0 +1,155 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Version prefix of the cookie format. Bump it if the format ever changes so old cookies are rejected cleanly
+const cookieVersion = "v1"
+
+// Signs and verifies cookie values so the client can hold state it can not tamper with
+// Cookies look like v1.<key id>.<base64 payload>.<base64 HMAC-SHA256>
+// The payload is JSON holding the value and an expiry, and the MAC also covers the cookie name
+// so a value signed for one cookie can not be replayed as another
+type CookieCodec struct {
+	keys       map[string][]byte // Every key cookies may be signed with, by key id
+	currentKey string            // Id of the key new cookies are signed with
+}
+
+// What actually gets signed
+type cookiePayload struct {
+	Expires int64           `json:"exp"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// Builds a codec from the COOKIE_KEYS env entry
+// keys is a comma separated list of id:base64key pairs. The first key signs new cookies,
+// the rest are only used to verify cookies signed before a rotation. Example: "2024b:KEY2,2024a:KEY1"
+// If no keys are configured a random key is used, which means cookies stop working on restart
+// and are not shared between instances
+func newCookieCodec(keys string) (*CookieCodec, error) {
+
+	codec := &CookieCodec{keys: make(map[string][]byte)}
+
+	for _, entry := range strings.Split(keys, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		id, encoded, found := strings.Cut(entry, ":")
+		if !found || id == "" || strings.Contains(id, ".") {
+			return nil, fmt.Errorf("cookie key %q must look like id:base64key", entry)
+		}
+
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("cookie key %v is not valid base64: %v", id, err)
+		}
+		if len(key) < 32 {
+			return nil, fmt.Errorf("cookie key %v must be at least 32 bytes", id)
+		}
+
+		codec.keys[id] = key
+		if codec.currentKey == "" {
+			codec.currentKey = id
+		}
+	}
+
+	if codec.currentKey == "" {
+		log.Println("COOKIE_KEYS is not set, using a random key. Users will be logged out when the server restarts")
+		key := make([]byte, 32)
+		_, err := rand.Read(key)
+		if err != nil {
+			return nil, fmt.Errorf("could not generate cookie key: %v", err)
+		}
+		codec.keys["random"] = key
+		codec.currentKey = "random"
+	}
+
+	return codec, nil
+}
+
+// Calculates the MAC for a cookie
+func (codec *CookieCodec) sign(key []byte, name string, keyID string, payload string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(cookieVersion + "." + name + "." + keyID + "." + payload))
+	return mac.Sum(nil)
+}
+
+// Encodes and signs a value for a cookie
+// name is the name of the cookie the value is for
+// value is anything that can be marshalled to JSON
+// maxAge is how long the value should be accepted for
+func (codec *CookieCodec) Encode(name string, value any, maxAge time.Duration) (string, error) {
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", fmt.Errorf("could not marshal cookie value: %v", err)
+	}
+
+	payloadJSON, err := json.Marshal(cookiePayload{
+		Expires: time.Now().Add(maxAge).Unix(),
+		Data:    data,
+	})
+	if err != nil {
+		return "", fmt.Errorf("could not marshal cookie payload: %v", err)
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString(payloadJSON)
+	mac := codec.sign(codec.keys[codec.currentKey], name, codec.currentKey, payload)
+
+	return strings.Join([]string{
+		cookieVersion,
+		codec.currentKey,
+		payload,
+		base64.RawURLEncoding.EncodeToString(mac),
+	}, "."), nil
+}
+
+// Verifies a signed cookie and unmarshals its value into dst
+// Fails for cookies with the wrong version, an unknown key, a bad signature, or that have expired
+func (codec *CookieCodec) Decode(name string, cookie string, dst any) error {
+
+	parts := strings.Split(cookie, ".")
+	if len(parts) != 4 || parts[0] != cookieVersion {
+		return errors.New("unrecognized cookie format")
+	}
+	keyID, payload, signature := parts[1], parts[2], parts[3]
+
+	key, exists := codec.keys[keyID]
+	if !exists {
+		return fmt.Errorf("cookie signed with unknown key %q", keyID)
+	}
+
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(mac, codec.sign(key, name, keyID, payload)) {
+		return errors.New("cookie signature is invalid")
+	}
+
+	payloadJSON, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return errors.New("cookie payload is not valid base64")
+	}
+
+	var decoded cookiePayload
+	err = json.Unmarshal(payloadJSON, &decoded)
+	if err != nil {
+		return fmt.Errorf("could not unmarshal cookie payload: %v", err)
+	}
+
+	if time.Now().Unix() > decoded.Expires {
+		return errors.New("cookie has expired")
+	}
+
+	return json.Unmarshal(decoded.Data, dst)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Header the front end sends the CSRF token in
+const csrfHeader = "X-CSRF-Token"
+
+// Cookie the signed copy of the CSRF token is kept in
+const csrfCookie = "csrfToken"
+
+// How long a CSRF token cookie is good for
+const csrfMaxAge = time.Hour * 24 * 7
+
+// Data for html pages that have nothing to render other than the CSRF token
+type StaticPage struct {
+	CSRFToken string
+}
+
+// Reports whether a request method can change state
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// This middleware protects every state changing route from cross-site request forgery
+// Each browser gets a random token in a signed cookie, and pages embed the same token in a
+// csrf-token meta tag. Requests other than GET, HEAD and OPTIONS must send the token back in the
+// X-CSRF-Token header, which another site can not do because it can not read our pages or cookies
+// API requests authenticated with a bearer token are let through since they do not use cookies at all
+func CSRFHandler(cookies *CookieCodec) gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		if _, ok := bearerToken(c); ok && strings.HasPrefix(c.Request.URL.Path, apiV1Path+"/") {
+			c.Next()
+			return
+		}
+
+		var token string
+		cookie, err := c.Cookie(csrfCookie)
+		if err == nil {
+			err = cookies.Decode(csrfCookie, cookie, &token)
+		}
+
+		if err != nil || token == "" {
+			if !safeMethod(c.Request.Method) {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"status": "missing or invalid CSRF token, please reload the page"})
+				return
+			}
+
+			// Hand out a new token on page loads
+			token, err = generateURLToken()
+			if err != nil {
+				log.Printf("could not generate CSRF token: %v", err)
+				c.AbortWithStatus(http.StatusInternalServerError)
+				return
+			}
+			value, err := cookies.Encode(csrfCookie, token, csrfMaxAge)
+			if err != nil {
+				log.Printf("could not encode CSRF cookie: %v", err)
+				c.AbortWithStatus(http.StatusInternalServerError)
+				return
+			}
+			c.SetSameSite(http.SameSiteStrictMode)
+			c.SetCookie(csrfCookie, value, int(csrfMaxAge.Seconds()), "/", c.Request.Host, true, true)
+		}
+
+		c.Set("csrfToken", token)
+
+		if !safeMethod(c.Request.Method) {
+			sent := c.GetHeader(csrfHeader)
+			if subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+				log.Printf("rejected %v %v from %v: CSRF token did not match", c.Request.Method, c.Request.URL.Path, c.ClientIP())
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"status": "missing or invalid CSRF token, please reload the page"})
+				return
+			}
+		}
+
+		c.Next()
+	}
+}
+
+// Returns the CSRF token for the current request, for embedding in pages
+func csrfToken(c *gin.Context) string {
+	return c.GetString("csrfToken")
+}
+
+// Sends one of the html pages that only needs the CSRF token filled in
+// name is the file name of the page in ./static/html. Example: "login.html"
+func servePage(c *gin.Context, name string) {
+
+	tmpl, err := template.ParseFiles("./static/html/" + name)
+	if err != nil {
+		log.Printf("Could not parse %v", name)
+		c.Data(http.StatusInternalServerError, "text/plain", []byte("Could not parse template"))
+		return
+	}
+
+	var final bytes.Buffer
+	err = tmpl.Execute(&final, StaticPage{CSRFToken: csrfToken(c)})
+	if err != nil {
+		log.Printf("Could not execute html template: %v", err)
+		c.Data(http.StatusInternalServerError, "text/plain", []byte("Could not parse template"))
+		return
+	}
+
+	c.Data(http.StatusOK, "text/html", final.Bytes())
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// The data table holds everything that is not a user, so none of it adds to the size of a user's item
+// Items are keyed by a partition key, pk, and a sort key, sk, so related items can be read with one query
+// Example: share link lookups are pk "share#<id>", sk "share"
+
+// Creates the data table if it does not exist yet
+func createDataTable(dataTable string, svc *dynamodb.DynamoDB) error {
+
+	_, err := svc.CreateTable(&dynamodb.CreateTableInput{
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+			{
+				AttributeName: aws.String("pk"),
+				AttributeType: aws.String("S"),
+			},
+			{
+				AttributeName: aws.String("sk"),
+				AttributeType: aws.String("S"),
+			},
+		},
+		KeySchema: []*dynamodb.KeySchemaElement{
+			{
+				AttributeName: aws.String("pk"),
+				KeyType:       aws.String("HASH"),
+			},
+			{
+				AttributeName: aws.String("sk"),
+				KeyType:       aws.String("RANGE"),
+			},
+		},
+		ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+			ReadCapacityUnits:  aws.Int64(10),
+			WriteCapacityUnits: aws.Int64(10),
+		},
+		TableName: aws.String(dataTable),
+	})
+	if err == nil {
+		fmt.Printf("Created the DB table: %v\n", dataTable)
+		return nil
+	}
+	if strings.Contains(err.Error(), "ResourceInUseException: Table") {
+		return nil
+	}
+
+	return err
+}
+
+// Key of an item in the data table
+func dataKey(pk string, sk string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"pk": {S: aws.String(pk)},
+		"sk": {S: aws.String(sk)},
+	}
+}
+
+// Key of the data table item that records a one time migration finished
+// name says which migration. Example: "storage-index"
+func migrationKey(name string) map[string]*dynamodb.AttributeValue {
+	return dataKey("system", "migration#"+name)
+}
+
+// Reports whether a one time migration has already finished, so later starts can skip it
+func migrationDone(dataTable string, name string, svc *dynamodb.DynamoDB) (bool, error) {
+
+	result, err := svc.GetItem(&dynamodb.GetItemInput{
+		TableName:      aws.String(dataTable),
+		Key:            migrationKey(name),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return false, fmt.Errorf("could not check migration %v: %v", name, err)
+	}
+
+	return len(result.Item) > 0, nil
+}
+
+// Records that a one time migration finished
+func markMigrationDone(dataTable string, name string, svc *dynamodb.DynamoDB) error {
+
+	item := migrationKey(name)
+	item["finished"] = &dynamodb.AttributeValue{S: aws.String(time.Now().Format(time.RFC3339))}
+
+	_, err := svc.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(dataTable),
+		Item:      item,
+	})
+	if err != nil {
+		return fmt.Errorf("could not record migration %v: %v", name, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// Parses the selection deadline of a shoot
+// Returns false if the shoot has no deadline or the deadline is not a valid RFC 3339 timestamp
+func shootDeadline(shoot Shoot) (time.Time, bool) {
+
+	if shoot.Deadline == "" {
+		return time.Time{}, false
+	}
+
+	deadline, err := time.Parse(time.RFC3339, shoot.Deadline)
+	if err != nil {
+		log.Printf("invalid deadline %q: %v", shoot.Deadline, err)
+		return time.Time{}, false
+	}
+
+	return deadline, true
+}
+
+// Reports whether the picks on a shoot can no longer be changed
+// A shoot is locked once it has been explicitly locked or once its deadline plus the grace period has passed
+// grace is how long after the deadline the client may still change their picks
+func selectionsLocked(shoot Shoot, grace time.Duration) bool {
+
+	if shoot.Locked {
+		return true
+	}
+
+	deadline, ok := shootDeadline(shoot)
+	if !ok {
+		return false
+	}
+
+	return time.Now().After(deadline.Add(grace))
+}
+
+// Parses a comma separated list of durations such as "72h,24h,2h"
+// Used for the REMINDER_OFFSETS env entry. Invalid entries are logged and skipped
+// Returns the offsets sorted from largest to smallest
+func parseReminderOffsets(offsets string) []time.Duration {
+
+	var final []time.Duration
+
+	for _, entry := range strings.Split(offsets, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		offset, err := time.ParseDuration(entry)
+		if err != nil || offset <= 0 {
+			log.Printf("skipping invalid reminder offset %q", entry)
+			continue
+		}
+		final = append(final, offset)
+	}
+
+	sort.Slice(final, func(i, j int) bool { return final[i] > final[j] })
+
+	return final
+}
+
+// Parses the DEADLINE_GRACE env entry. Defaults to no grace period
+func parseGracePeriod(grace string) time.Duration {
+
+	if grace == "" {
+		return 0
+	}
+
+	duration, err := time.ParseDuration(grace)
+	if err != nil || duration < 0 {
+		log.Printf("invalid deadline grace period %q, using no grace period", grace)
+		return 0
+	}
+
+	return duration
+}
+
+// Sets a property on one of a user's shoots
+// property is the name of the shoot attribute to set. Example: "locked"
+// value is the DynamoDB attribute value to set it to
+func setShootProperty(tableName string, username string, shootID string, property string, value *dynamodb.AttributeValue, svc *dynamodb.DynamoDB) error {
+
+	key := map[string]*dynamodb.AttributeValue{
+		"username": {
+			S: aws.String(username),
+		},
+	}
+
+	path, names := shootPath(shootID, property)
+	updateExpression := "SET " + path + " = :newValue"
+
+	updateInput := &dynamodb.UpdateItemInput{
+		TableName:                aws.String(tableName),
+		Key:                      key,
+		UpdateExpression:         aws.String(updateExpression),
+		ExpressionAttributeNames: names,
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":newValue": value,
+		},
+	}
+
+	_, err := svc.UpdateItem(updateInput)
+
+	return err
+}
+
+// Works out which reminder is due for a shoot, if any
+// Only the closest due offset is returned so a deadline set late does not send every reminder at once
+// Returns every unsent offset that should be marked as sent along with the reminder
+func dueReminder(shoot Shoot, offsets []time.Duration, now time.Time) ([]string, bool) {
+
+	deadline, ok := shootDeadline(shoot)
+	if !ok || now.After(deadline) {
+		return nil, false
+	}
+
+	sent := make(map[string]bool)
+	for _, offset := range shoot.RemindersSent {
+		sent[offset] = true
+	}
+
+	var due []string
+	var closest time.Duration
+	for _, offset := range offsets { // offsets are sorted largest to smallest
+		if now.Before(deadline.Add(-offset)) {
+			break
+		}
+		closest = offset
+		if !sent[offset.String()] {
+			due = append(due, offset.String())
+		}
+	}
+
+	if len(due) == 0 || sent[closest.String()] {
+		return nil, false
+	}
+
+	return due, true
+}
+
+// Marks the reminders in due as sent, unless another run already did
+// due comes from dueReminder, its last offset is the reminder about to be sent
+// Returns false if the reminder was already claimed and should not be sent
+func claimReminder(tableName string, username string, shootID string, shoot Shoot, due []string, svc *dynamodb.DynamoDB) (bool, error) {
+
+	sent, err := dynamodbattribute.Marshal(append(shoot.RemindersSent, due...))
+	if err != nil {
+		return false, err
+	}
+
+	path, names := shootPath(shootID, "remindersSent")
+
+	_, err = svc.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"username": {
+				S: aws.String(username),
+			},
+		},
+		UpdateExpression:         aws.String("SET " + path + " = :sent"),
+		ConditionExpression:      aws.String("attribute_not_exists(" + path + ") OR NOT contains(" + path + ", :offset)"),
+		ExpressionAttributeNames: names,
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":sent":   sent,
+			":offset": {S: aws.String(due[len(due)-1])},
+		},
+	})
+	if conditionFailed(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Gives up the claim on a reminder whose email could not be sent, so the next run tries again
+// Only puts back what was there before if nothing else has changed the reminders since
+func releaseReminder(tableName string, username string, shootID string, shoot Shoot, due []string, svc *dynamodb.DynamoDB) error {
+
+	before, err := dynamodbattribute.Marshal(shoot.RemindersSent)
+	if err != nil {
+		return err
+	}
+	claimed, err := dynamodbattribute.Marshal(append(shoot.RemindersSent, due...))
+	if err != nil {
+		return err
+	}
+
+	path, names := shootPath(shootID, "remindersSent")
+
+	_, err = svc.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"username": {
+				S: aws.String(username),
+			},
+		},
+		UpdateExpression:         aws.String("SET " + path + " = :before"),
+		ConditionExpression:      aws.String(path + " = :claimed"),
+		ExpressionAttributeNames: names,
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":before":  before,
+			":claimed": claimed,
+		},
+	})
+	if err != nil && !conditionFailed(err) {
+		return err
+	}
+
+	return nil
+}
+
+// Goes through every user's shoots, locks any whose deadline has passed and sends reminders that are due
+// baseURL is the public address of the site, used for the link in the reminder email
+func processDeadlines(tableName string, svc *dynamodb.DynamoDB, mailer Mailer, baseURL string, offsets []time.Duration, grace time.Duration) {
+
+	users, err := scanUsers(tableName, svc)
+	if err != nil {
+		log.Printf("could not scan users for deadlines: %v", err)
+		return
+	}
+
+	now := time.Now()
+
+	for _, user := range users {
+		for shootID, shoot := range user.Shoots {
+
+			if shootID == placeholderShoot || shoot.Locked {
+				continue
+			}
+
+			// Lock shoots whose deadline and grace period have passed
+			if selectionsLocked(shoot, grace) {
+				err := setShootProperty(tableName, user.Username, shootID, "locked", &dynamodb.AttributeValue{BOOL: aws.Bool(true)}, svc)
+				if err != nil {
+					log.Printf("could not lock shoot %v for %v: %v", shootID, user.Username, err)
+				}
+				continue
+			}
+
+			due, ok := dueReminder(shoot, offsets, now)
+			if !ok {
+				continue
+			}
+
+			// Claim the reminder before sending it, so two servers running this at once can not both send it
+			claimed, err := claimReminder(tableName, user.Username, shootID, shoot, due, svc)
+			if err != nil {
+				log.Printf("could not record reminder for shoot %v for %v: %v", shootID, user.Username, err)
+				continue
+			}
+			if !claimed {
+				continue
+			}
+
+			deadline, _ := shootDeadline(shoot)
+			subject := fmt.Sprintf("Reminder: your selections for %v are due %v", shootDisplayName(shootID, shoot), deadline.Format("January 2"))
+			err = sendEmail(mailer, user.Email, subject, "reminder.html", newEmailData(baseURL, user, shootID, shoot))
+			if err != nil {
+				log.Printf("could not send reminder for shoot %v to %v: %v", shootID, user.Username, err)
+				err = releaseReminder(tableName, user.Username, shootID, shoot, due, svc)
+				if err != nil {
+					log.Printf("could not release reminder for shoot %v for %v: %v", shootID, user.Username, err)
+				}
+			}
+		}
+	}
+}
+
+// Checks shoot deadlines on a 15-minute interval
+// Gets put into a goroutine to run in the background
+// svc is a pointer to the DynamoDB client so renewed clients are picked up
+func autoProcessDeadlines(svc **dynamodb.DynamoDB, tableName string, mailer Mailer, baseURL string, offsets []time.Duration, grace time.Duration) {
+
+	for {
+		processDeadlines(tableName, *svc, mailer, baseURL, offsets, grace)
+		time.Sleep(time.Minute * 15)
+	}
+}
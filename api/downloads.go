@@ -0,0 +1,244 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gin-gonic/gin"
+)
+
+// What a zip download can hold
+const (
+	downloadPicks     = "picks"     // Originals of the photos the client picked
+	downloadDelivered = "delivered" // Every original in the shoot, once it has been delivered
+	downloadCategory  = "category"  // Every original in one folder of the shoot, once it has been delivered
+)
+
+// Sizes of the zip records the archive/zip writer makes for stored files
+// Used to work out the size of a download before it is sent
+const (
+	zipLocalHeaderLen     = 30
+	zipCentralHeaderLen   = 46
+	zipDataDescriptorLen  = 16
+	zipEndRecordLen       = 22
+	zipTimestampExtraLen  = 9 // Extended timestamp field added when Modified is set
+	zipMaxEntries         = 0xffff
+	zipMaxSize            = 0xffffffff
+	downloadContentLength = -1 // Size of a download that can not be worked out up front
+)
+
+// Why a download can not be made
+type DownloadError struct {
+	Status int
+	Reason string
+}
+
+func (e *DownloadError) Error() string {
+	return e.Reason
+}
+
+// An original photo going into a zip download
+type downloadEntry struct {
+	Key      string // S3 object key
+	Name     string // Path inside the zip, relative to the shoot's prefix. Example: "ceremony/IMG_0001.jpg"
+	Size     int64
+	Modified time.Time
+}
+
+// Lists the originals in a shoot's prefix with their sizes, in key order
+func listOriginals(client *s3.S3, bucket string, prefix string) ([]*s3.Object, error) {
+
+	var final []*s3.Object
+
+	err := client.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range page.Contents {
+			if *object.Size > 0 && !isRendition(*object.Key) {
+				final = append(final, object)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return final, nil
+}
+
+// Works out which originals go into a download
+// scope is one of downloadPicks, downloadDelivered or downloadCategory. category is the folder
+// under the shoot's prefix to download and is only used with downloadCategory. Example: "ceremony"
+// Returns a *DownloadError if the download is not allowed or there is nothing to download
+func planDownload(client *s3.S3, bucket string, shoot Shoot, scope string, category string) ([]downloadEntry, error) {
+
+	if !shoot.Downloads {
+		return nil, &DownloadError{Status: http.StatusForbidden, Reason: "downloads are not enabled for this shoot"}
+	}
+	if shoot.Prefix == "" {
+		return nil, &DownloadError{Status: http.StatusNotFound, Reason: "shoot does not have any photos"}
+	}
+
+	prefix := strings.TrimSuffix(shoot.Prefix, "/") + "/"
+
+	switch scope {
+	case downloadPicks:
+	case downloadDelivered, downloadCategory:
+		if shoot.DeliveredAt == "" {
+			return nil, &DownloadError{Status: http.StatusForbidden, Reason: "this shoot has not been delivered yet"}
+		}
+		if scope == downloadCategory {
+			if category == "" || strings.ContainsAny(category, "/\\") || category == "." || category == ".." {
+				return nil, &DownloadError{Status: http.StatusBadRequest, Reason: "category must be the name of a folder in the shoot"}
+			}
+			prefix += category + "/"
+		}
+	default:
+		return nil, &DownloadError{Status: http.StatusBadRequest, Reason: fmt.Sprintf("scope must be one of %v, %v or %v", downloadPicks, downloadDelivered, downloadCategory)}
+	}
+
+	objects, err := listOriginals(client, bucket, prefix)
+	if err != nil {
+		return nil, fmt.Errorf("could not list originals: %v", err)
+	}
+
+	picked := make(map[string]bool)
+	for _, key := range shoot.Picks.Picks {
+		picked[key] = true
+	}
+
+	var final []downloadEntry
+	for _, object := range objects {
+		name := strings.TrimPrefix(*object.Key, strings.TrimSuffix(shoot.Prefix, "/")+"/")
+		if scope == downloadPicks {
+			// Picks are made from thumbnails, which are named after the original without its extension
+			base := name[strings.LastIndex(name, "/")+1:]
+			if !picked[base] && !picked[strings.TrimSuffix(base, extension(base))] {
+				continue
+			}
+		}
+		entry := downloadEntry{Key: *object.Key, Name: name, Size: *object.Size}
+		if object.LastModified != nil {
+			entry.Modified = object.LastModified.UTC()
+		}
+		final = append(final, entry)
+	}
+
+	if len(final) == 0 {
+		return nil, &DownloadError{Status: http.StatusNotFound, Reason: "there are no photos to download"}
+	}
+
+	sort.Slice(final, func(i, j int) bool { return final[i].Name < final[j].Name })
+
+	return final, nil
+}
+
+// Returns the extension of a file name including the dot. Example: ".jpg"
+func extension(name string) string {
+	if i := strings.LastIndex(name, "."); i > 0 {
+		return name[i:]
+	}
+	return ""
+}
+
+// Works out exactly how big the zip of some originals will be
+// Photos are stored without compression so the size only depends on the names and sizes
+// Returns -1 if the zip would need zip64 records, since their size is not worth predicting
+func zipSize(entries []downloadEntry) int64 {
+
+	if len(entries) >= zipMaxEntries {
+		return downloadContentLength
+	}
+
+	var size, directory int64
+	for _, entry := range entries {
+		if entry.Size >= zipMaxSize {
+			return downloadContentLength
+		}
+		extra := int64(0)
+		if !entry.Modified.IsZero() {
+			extra = zipTimestampExtraLen
+		}
+		size += zipLocalHeaderLen + int64(len(entry.Name)) + extra + entry.Size + zipDataDescriptorLen
+		directory += zipCentralHeaderLen + int64(len(entry.Name)) + extra
+	}
+	if size >= zipMaxSize || directory >= zipMaxSize {
+		return downloadContentLength
+	}
+
+	return size + directory + zipEndRecordLen
+}
+
+// Writes a zip of originals to w, pulling each one from S3 as it goes so only one photo
+// is ever being read at a time and nothing is held in memory
+func writeDownload(w io.Writer, client *s3.S3, bucket string, entries []downloadEntry) error {
+
+	archive := zip.NewWriter(w)
+
+	for _, entry := range entries {
+
+		file, err := archive.CreateHeader(&zip.FileHeader{
+			Name:     entry.Name,
+			Method:   zip.Store, // Photos are already compressed
+			Modified: entry.Modified,
+		})
+		if err != nil {
+			return err
+		}
+
+		object, err := client.GetObject(&s3.GetObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(entry.Key),
+		})
+		if err != nil {
+			return fmt.Errorf("could not get %v: %v", entry.Key, err)
+		}
+
+		// Copy exactly the listed size so the Content-Length sent up front stays true
+		_, err = io.CopyN(file, object.Body, entry.Size)
+		object.Body.Close()
+		if err != nil {
+			return fmt.Errorf("could not copy %v: %v", entry.Key, err)
+		}
+	}
+
+	return archive.Close()
+}
+
+// Streams a zip download of a shoot to the client
+// Once the zip has started the only way to signal an error is to cut the response short,
+// so the connection is closed and the browser sees a failed download instead of a broken zip
+func streamDownload(c *gin.Context, client *s3.S3, bucket string, shootID string, scope string, entries []downloadEntry) error {
+
+	size := zipSize(entries)
+
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", shootID+"-"+scope+".zip"))
+	c.Header("Cache-Control", "no-store")
+	c.Header("Accept-Ranges", "none") // The zip is built as it is sent, so an interrupted download has to start over
+	if size >= 0 {
+		c.Header("Content-Length", strconv.FormatInt(size, 10))
+	}
+	c.Status(http.StatusOK)
+
+	err := writeDownload(c.Writer, client, bucket, entries)
+	if err != nil {
+		c.Abort()
+		if conn, _, hijackErr := c.Writer.Hijack(); hijackErr == nil {
+			conn.Close()
+		}
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/binary"
+	"strings"
+	"time"
+)
+
+// How much of the start of a JPEG to read to find its EXIF data
+// The EXIF segment comes before the image data, so this is enough for every camera we have seen
+const exifReadBytes = 128 << 10
+
+// EXIF tags needed to find when a photo was taken
+const (
+	exifTagDateTime         = 0x0132 // When the file was last changed, used if the camera did not record when it took the photo
+	exifTagExifIFD          = 0x8769 // Offset of the IFD holding the camera's own tags
+	exifTagDateTimeOriginal = 0x9003
+	exifTypeASCII           = 2
+	exifTypeLong            = 4
+)
+
+// How EXIF writes dates. It has no time zone, so times are read as UTC, which keeps a shoot in order
+const exifTimeLayout = "2006:01:02 15:04:05"
+
+// Reads when a photo was taken from the EXIF data at the start of a JPEG
+// Returns false if the data is not a JPEG or it does not say when it was taken
+func exifCaptureTime(data []byte) (time.Time, bool) {
+
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return time.Time{}, false
+	}
+
+	for i := 2; i+4 <= len(data); {
+
+		if data[i] != 0xFF {
+			return time.Time{}, false
+		}
+		marker := data[i+1]
+
+		switch {
+		case marker == 0xFF: // Padding before a marker
+			i++
+			continue
+		case marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7): // Markers without a length
+			i += 2
+			continue
+		case marker == 0xDA || marker == 0xD9: // The image data has started, so there is no EXIF
+			return time.Time{}, false
+		}
+
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		start, end := i+4, i+2+length
+		if length < 2 || end > len(data) {
+			return time.Time{}, false
+		}
+
+		if marker == 0xE1 && end-start > 6 && string(data[start:start+6]) == "Exif\x00\x00" {
+			return tiffCaptureTime(data[start+6 : end])
+		}
+
+		i = end
+	}
+
+	return time.Time{}, false
+}
+
+// Reads the capture time out of the TIFF structure EXIF data is stored in
+func tiffCaptureTime(tiff []byte) (time.Time, bool) {
+
+	if len(tiff) < 8 {
+		return time.Time{}, false
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return time.Time{}, false
+	}
+
+	var modified string
+	var exifIFD uint32
+
+	readIFD(tiff, order, order.Uint32(tiff[4:]), func(tag uint16, kind uint16, count uint32, value []byte) {
+		switch {
+		case tag == exifTagDateTime && kind == exifTypeASCII:
+			modified = exifString(tiff, order, count, value)
+		case tag == exifTagExifIFD && kind == exifTypeLong:
+			exifIFD = order.Uint32(value)
+		}
+	})
+
+	var original string
+	if exifIFD != 0 {
+		readIFD(tiff, order, exifIFD, func(tag uint16, kind uint16, count uint32, value []byte) {
+			if tag == exifTagDateTimeOriginal && kind == exifTypeASCII {
+				original = exifString(tiff, order, count, value)
+			}
+		})
+	}
+
+	for _, value := range []string{original, modified} {
+		if taken, err := time.Parse(exifTimeLayout, value); err == nil {
+			return taken, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// Calls visit with every entry of the IFD at offset, skipping the IFD if it runs off the end of the data
+// value is the entry's four value bytes, which hold either the value itself or the offset to it
+func readIFD(tiff []byte, order binary.ByteOrder, offset uint32, visit func(tag uint16, kind uint16, count uint32, value []byte)) {
+
+	if uint64(offset)+2 > uint64(len(tiff)) {
+		return
+	}
+
+	entries := int(order.Uint16(tiff[offset:]))
+	start := int(offset) + 2
+	if start+entries*12 > len(tiff) {
+		return
+	}
+
+	for i := 0; i < entries; i++ {
+		entry := tiff[start+i*12 : start+i*12+12]
+		visit(order.Uint16(entry), order.Uint16(entry[2:]), order.Uint32(entry[4:]), entry[8:12])
+	}
+}
+
+// Reads an ASCII value, which is stored in the entry itself when it fits in four bytes
+func exifString(tiff []byte, order binary.ByteOrder, count uint32, value []byte) string {
+
+	var raw []byte
+	if count <= 4 {
+		raw = value[:count]
+	} else {
+		offset := order.Uint32(value)
+		if uint64(offset)+uint64(count) > uint64(len(tiff)) {
+			return ""
+		}
+		raw = tiff[offset : offset+count]
+	}
+
+	return strings.TrimRight(string(raw), "\x00 ")
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/mail"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/gin-gonic/gin"
+)
+
+// Most guests that can keep favorites on one shoot
+const maxGuests = 200
+
+// Most photos one guest can favorite
+const maxGuestFavorites = 500
+
+// How long a guest stays signed in on a share link
+const guestSessionTTL = 30 * 24 * time.Hour
+
+// Who a guest on a share link is, kept in a signed cookie
+// The id is random and only ever handed out in the cookie, so the cookie is what proves who a guest is
+type guestSession struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Email string `json:"email"` // Only shown to the photographer, nothing is looked up by it since it is never verified
+}
+
+// Checks the name and email a guest gave
+// id is the guest's id if they are already signed in on this browser, empty to give them a new one
+// Returns a *ShareError if either is not valid
+func newGuestSession(id string, name string, email string) (guestSession, error) {
+
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > maxShareNameLength {
+		return guestSession{}, &ShareError{Status: http.StatusBadRequest, Reason: fmt.Sprintf("please give a name of at most %v characters", maxShareNameLength)}
+	}
+
+	address, err := mail.ParseAddress(strings.TrimSpace(email))
+	if err != nil || address.Name != "" {
+		return guestSession{}, &ShareError{Status: http.StatusBadRequest, Reason: "please give a valid email address"}
+	}
+	email = strings.ToLower(address.Address)
+
+	if id == "" {
+		token, err := generateURLToken()
+		if err != nil {
+			return guestSession{}, err
+		}
+		id = token[:16]
+	}
+
+	return guestSession{ID: id, Name: name, Email: email}, nil
+}
+
+// Name of the cookie that remembers who the guest on a share link is
+func guestCookieName(shareID string) string {
+	return "guest-" + shareID
+}
+
+// Returns the guest signed in on a share link in this browser
+func currentGuest(c *gin.Context, cookies *CookieCodec, shareID string) (guestSession, bool) {
+
+	cookie, err := c.Cookie(guestCookieName(shareID))
+	if err != nil {
+		return guestSession{}, false
+	}
+
+	var guest guestSession
+	err = cookies.Decode(guestCookieName(shareID), cookie, &guest)
+	if err != nil || guest.ID == "" {
+		return guestSession{}, false
+	}
+
+	return guest, true
+}
+
+// Signs a guest in on a share link in this browser
+func startGuestSession(c *gin.Context, cookies *CookieCodec, shareID string, guest guestSession) error {
+
+	value, err := cookies.Encode(guestCookieName(shareID), guest, guestSessionTTL)
+	if err != nil {
+		return err
+	}
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(guestCookieName(shareID), value, int(guestSessionTTL.Seconds()), "/s/", c.Request.Host, true, true)
+
+	return nil
+}
+
+// Partition key of a shoot's guest favorites in the data table, each guest is one item under it
+// Shoot ids can not have a # in them, so the owner is everything before the last one
+func favoritesKey(username string, shootID string) string {
+	return "favorites#" + username + "#" + shootID
+}
+
+// Sort key of one guest's favorites
+func guestKey(guestID string) string {
+	return "guest#" + guestID
+}
+
+// Returns the favorites of every guest on a shoot, by guest id
+func shootFavorites(dataTable string, username string, shootID string, svc *dynamodb.DynamoDB) (map[string]GuestFavorites, error) {
+
+	final := make(map[string]GuestFavorites)
+	var unmarshalErr error
+
+	err := svc.QueryPages(&dynamodb.QueryInput{
+		TableName:              aws.String(dataTable),
+		KeyConditionExpression: aws.String("pk = :pk"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pk": {S: aws.String(favoritesKey(username, shootID))},
+		},
+	}, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			var favorites GuestFavorites
+			unmarshalErr = dynamodbattribute.UnmarshalMap(item, &favorites)
+			if unmarshalErr != nil {
+				return false
+			}
+			final[strings.TrimPrefix(aws.StringValue(item["sk"].S), "guest#")] = favorites
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not get favorites: %v", err)
+	}
+	if unmarshalErr != nil {
+		return nil, fmt.Errorf("could not unmarshal favorites: %v", unmarshalErr)
+	}
+
+	return final, nil
+}
+
+// Returns one guest's favorites on a shoot
+// Returns false if the guest has not signed in on the shoot yet
+func getGuestFavorites(dataTable string, username string, shootID string, guestID string, svc *dynamodb.DynamoDB) (GuestFavorites, bool, error) {
+
+	result, err := svc.GetItem(&dynamodb.GetItemInput{
+		TableName:      aws.String(dataTable),
+		Key:            dataKey(favoritesKey(username, shootID), guestKey(guestID)),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return GuestFavorites{}, false, fmt.Errorf("could not get favorites: %v", err)
+	}
+	if result.Item == nil {
+		return GuestFavorites{}, false, nil
+	}
+
+	var favorites GuestFavorites
+	err = dynamodbattribute.UnmarshalMap(result.Item, &favorites)
+	if err != nil {
+		return GuestFavorites{}, false, fmt.Errorf("could not unmarshal favorites: %v", err)
+	}
+	if favorites.Photos == nil {
+		favorites.Photos = []string{}
+	}
+
+	return favorites, true, nil
+}
+
+// Signs a guest in on a shoot, starting an empty list of favorites for guests new to it
+// Guests already on the shoot just have their name and email updated
+// Returns a *ShareError if the shoot already has as many guests as it can hold
+func addGuest(dataTable string, username string, shootID string, shareID string, guest guestSession, now time.Time, svc *dynamodb.DynamoDB) (GuestFavorites, error) {
+
+	key := dataKey(favoritesKey(username, shootID), guestKey(guest.ID))
+
+	favorites, exists, err := getGuestFavorites(dataTable, username, shootID, guest.ID, svc)
+	if err != nil {
+		return GuestFavorites{}, err
+	}
+
+	if exists {
+		_, err = svc.UpdateItem(&dynamodb.UpdateItemInput{
+			TableName:        aws.String(dataTable),
+			Key:              key,
+			UpdateExpression: aws.String("SET #name = :name, email = :email, updated = :now"),
+			ExpressionAttributeNames: map[string]*string{
+				"#name": aws.String("name"),
+			},
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":name":  {S: aws.String(guest.Name)},
+				":email": {S: aws.String(guest.Email)},
+				":now":   {S: aws.String(now.Format(time.RFC3339))},
+			},
+		})
+		if err != nil {
+			return GuestFavorites{}, fmt.Errorf("could not save guest: %v", err)
+		}
+		favorites.Name, favorites.Email, favorites.Updated = guest.Name, guest.Email, now.Format(time.RFC3339)
+		return favorites, nil
+	}
+
+	count, err := svc.Query(&dynamodb.QueryInput{
+		TableName:              aws.String(dataTable),
+		KeyConditionExpression: aws.String("pk = :pk"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pk": {S: aws.String(favoritesKey(username, shootID))},
+		},
+		Select: aws.String(dynamodb.SelectCount),
+	})
+	if err != nil {
+		return GuestFavorites{}, fmt.Errorf("could not count guests: %v", err)
+	}
+	if aws.Int64Value(count.Count) >= maxGuests {
+		return GuestFavorites{}, &ShareError{Status: http.StatusUnprocessableEntity, Reason: "this gallery can not take any more guests, please ask the photographer"}
+	}
+
+	favorites = GuestFavorites{
+		Name:    guest.Name,
+		Email:   guest.Email,
+		Share:   shareID,
+		Created: now.Format(time.RFC3339),
+		Updated: now.Format(time.RFC3339),
+	}
+	item, err := dynamodbattribute.MarshalMap(favorites)
+	if err != nil {
+		return GuestFavorites{}, fmt.Errorf("could not marshal guest: %v", err)
+	}
+	for name, value := range key {
+		item[name] = value
+	}
+
+	_, err = svc.PutItem(&dynamodb.PutItemInput{
+		TableName:           aws.String(dataTable),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(pk)"),
+	})
+	if err != nil && !conditionFailed(err) { // Lost a race with the same guest signing in twice, which is fine
+		return GuestFavorites{}, fmt.Errorf("could not save guest: %v", err)
+	}
+	favorites.Photos = []string{}
+
+	return favorites, nil
+}
+
+// Adds a photo to a guest's favorites or takes it off
+// The photos are a string set changed with ADD and DELETE, so toggles from two tabs can not undo each other
+// Returns the guest's favorites afterwards, or a *ShareError if they already have as many as they can
+func setFavorite(dataTable string, username string, shootID string, guestID string, key string, favorite bool, now time.Time, svc *dynamodb.DynamoDB) ([]string, error) {
+
+	input := &dynamodb.UpdateItemInput{
+		TableName:           aws.String(dataTable),
+		Key:                 dataKey(favoritesKey(username, shootID), guestKey(guestID)),
+		UpdateExpression:    aws.String("DELETE photos :key SET updated = :now"),
+		ConditionExpression: aws.String("attribute_exists(pk)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":key": {SS: []*string{aws.String(key)}},
+			":now": {S: aws.String(now.Format(time.RFC3339))},
+		},
+		ReturnValues: aws.String(dynamodb.ReturnValueAllNew),
+	}
+	if favorite {
+		input.UpdateExpression = aws.String("ADD photos :key SET updated = :now")
+		input.ConditionExpression = aws.String("attribute_exists(pk) AND (attribute_not_exists(photos) OR size(photos) < :max OR contains(photos, :photo))")
+		input.ExpressionAttributeValues[":max"] = &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(maxGuestFavorites))}
+		input.ExpressionAttributeValues[":photo"] = &dynamodb.AttributeValue{S: aws.String(key)}
+	}
+
+	result, err := svc.UpdateItem(input)
+	if conditionFailed(err) {
+		if favorite {
+			return nil, &ShareError{Status: http.StatusUnprocessableEntity, Reason: fmt.Sprintf("you can have at most %v favorites", maxGuestFavorites)}
+		}
+		return nil, &ShareError{Status: http.StatusUnauthorized, Reason: "please give your name and email first"}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not save favorite: %v", err)
+	}
+
+	var favorites GuestFavorites
+	err = dynamodbattribute.UnmarshalMap(result.Attributes, &favorites)
+	if err != nil {
+		return nil, fmt.Errorf("could not unmarshal favorites: %v", err)
+	}
+	if favorites.Photos == nil {
+		favorites.Photos = []string{}
+	}
+	sort.Strings(favorites.Photos)
+
+	return favorites.Photos, nil
+}
+
+// Deletes the favorites every guest kept on a shoot
+func forgetFavorites(dataTable string, username string, shootID string, svc *dynamodb.DynamoDB) error {
+
+	favorites, err := shootFavorites(dataTable, username, shootID, svc)
+	if err != nil {
+		return err
+	}
+
+	for guestID := range favorites {
+		_, err := svc.DeleteItem(&dynamodb.DeleteItemInput{
+			TableName: aws.String(dataTable),
+			Key:       dataKey(favoritesKey(username, shootID), guestKey(guestID)),
+		})
+		if err != nil {
+			return fmt.Errorf("could not delete favorites: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// Returns how many guests favorited each photo, when a view sorts or filters by it
+// Returns nil for every other view so the favorites are only read when they are needed
+func viewFavorites(dataTable string, username string, shootID string, view GalleryView, svc *dynamodb.DynamoDB) (map[string]int, error) {
+
+	if view.Sort != sortFavorites && view.Filter != filterFavorited {
+		return nil, nil
+	}
+
+	favorites, err := shootFavorites(dataTable, username, shootID, svc)
+	if err != nil {
+		return nil, err
+	}
+
+	return favoriteTotals(favorites), nil
+}
+
+// Counts how many guests favorited each photo in a shoot
+func favoriteTotals(favorites map[string]GuestFavorites) map[string]int {
+
+	final := make(map[string]int)
+	for _, guest := range favorites {
+		for _, photo := range guest.Photos {
+			final[photo]++
+		}
+	}
+
+	return final
+}
+
+// Lists the photos guests favorited, most favorited first
+func favoriteResources(favorites map[string]GuestFavorites) []FavoriteResource {
+
+	guests := make(map[string][]string)
+	for _, guest := range favorites {
+		for _, photo := range guest.Photos {
+			guests[photo] = append(guests[photo], guest.Name)
+		}
+	}
+
+	final := make([]FavoriteResource, 0, len(guests))
+	for photo, names := range guests {
+		sort.Strings(names)
+		final = append(final, FavoriteResource{Key: photo, Count: len(names), Guests: names})
+	}
+	sort.Slice(final, func(i, j int) bool {
+		if final[i].Count != final[j].Count {
+			return final[i].Count > final[j].Count
+		}
+		return final[i].Key < final[j].Key
+	})
+
+	return final
+}
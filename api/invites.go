@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/go-redis/redis"
+)
+
+// Roles a user can have
+// Clients only see their own shoots, photographers can also invite new clients
+const (
+	roleClient       = "client"
+	rolePhotographer = "photographer"
+	roleAdmin        = "admin"
+)
+
+// How long an invite link is good for
+const inviteDays = 14
+
+// How long an email verification link is good for
+const verifyHours = 48
+
+// Reports whether a user is allowed to manage clients
+// Admins can do anything a photographer can
+func isPhotographer(user User) bool {
+	return user.Role == rolePhotographer || user.Role == roleAdmin
+}
+
+// Reports whether a role is one the server knows about
+func validRole(role string) bool {
+	return role == roleClient || role == rolePhotographer || role == roleAdmin
+}
+
+// Stores an invite in Redis and returns the token for the signup link
+func createInvite(r *redis.Client, invite Invite) (string, error) {
+
+	inviteJSON, err := json.Marshal(invite)
+	if err != nil {
+		return "", fmt.Errorf("could not marshal invite: %v", err)
+	}
+
+	return createOneTimeToken(r, "invite:", string(inviteJSON), time.Hour*24*inviteDays)
+}
+
+// Looks up an invite without using it up
+func getInvite(r *redis.Client, token string) (Invite, error) {
+
+	inviteJSON, err := checkOneTimeToken(r, "invite:", token)
+	if err != nil {
+		return Invite{}, errors.New("invite is invalid or has expired")
+	}
+
+	var invite Invite
+	err = json.Unmarshal([]byte(inviteJSON), &invite)
+	if err != nil {
+		return Invite{}, fmt.Errorf("could not unmarshal invite: %v", err)
+	}
+
+	return invite, nil
+}
+
+// Looks up an invite and deletes it so it can only be used for one account
+func consumeInvite(r *redis.Client, token string) (Invite, error) {
+
+	inviteJSON, err := consumeOneTimeToken(r, "invite:", token)
+	if err != nil {
+		return Invite{}, errors.New("invite is invalid or has expired")
+	}
+
+	var invite Invite
+	err = json.Unmarshal([]byte(inviteJSON), &invite)
+	if err != nil {
+		return Invite{}, fmt.Errorf("could not unmarshal invite: %v", err)
+	}
+
+	return invite, nil
+}
+
+// Creates the token for an email verification link
+func createVerifyToken(r *redis.Client, username string) (string, error) {
+	return createOneTimeToken(r, "verify:", username, time.Hour*verifyHours)
+}
+
+// Returns the username a verification token belongs to and deletes the token
+func consumeVerifyToken(r *redis.Client, token string) (string, error) {
+	return consumeOneTimeToken(r, "verify:", token)
+}
+
+// Activates an account once its email address has been verified
+func markVerified(tableName string, username string, svc *dynamodb.DynamoDB) error {
+
+	key := map[string]*dynamodb.AttributeValue{
+		"username": {
+			S: aws.String(username),
+		},
+	}
+
+	updateInput := &dynamodb.UpdateItemInput{
+		TableName:        aws.String(tableName),
+		Key:              key,
+		UpdateExpression: aws.String("REMOVE unverified"),
+	}
+
+	_, err := svc.UpdateItem(updateInput)
+	if err != nil {
+		return fmt.Errorf("could not verify user: %v", err)
+	}
+
+	return nil
+}
+
+// Reports whether no users exist yet
+// The very first account can be created without an invite and becomes the admin
+func tableIsEmpty(tableName string, svc *dynamodb.DynamoDB) (bool, error) {
+
+	result, err := svc.Scan(&dynamodb.ScanInput{
+		TableName: aws.String(tableName),
+		Limit:     aws.Int64(1),
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return len(result.Items) == 0, nil
+}
+
+// Key of the data table item that marks the first account as taken
+func bootstrapKey() map[string]*dynamodb.AttributeValue {
+	return dataKey("system", "bootstrap")
+}
+
+// Claims the right to create the first account, which becomes the admin
+// The claim is a conditional put, so two signups on an empty table can not both become admin
+// Returns false if another signup already claimed it
+func claimBootstrap(dataTable string, username string, svc *dynamodb.DynamoDB) (bool, error) {
+
+	item := bootstrapKey()
+	item["username"] = &dynamodb.AttributeValue{S: aws.String(username)}
+	item["created"] = &dynamodb.AttributeValue{S: aws.String(time.Now().Format(time.RFC3339))}
+
+	_, err := svc.PutItem(&dynamodb.PutItemInput{
+		TableName:           aws.String(dataTable),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(pk)"),
+	})
+	if conditionFailed(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("could not claim the first account: %v", err)
+	}
+
+	return true, nil
+}
+
+// Gives up the claim on the first account when it could not be created, so signing up can be tried again
+func releaseBootstrap(dataTable string, svc *dynamodb.DynamoDB) {
+	_, err := svc.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(dataTable),
+		Key:       bootstrapKey(),
+	})
+	if err != nil {
+		log.Printf("could not release the claim on the first account: %v", err)
+	}
+}
+
+// Makes an existing user an admin
+// Used for the ADMIN_USERNAME env entry, so deployments from before roles existed have someone who can invite
+func promoteAdmin(tableName string, username string, svc *dynamodb.DynamoDB) error {
+
+	_, err := svc.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"username": {
+				S: aws.String(strings.ToLower(username)),
+			},
+		},
+		UpdateExpression:    aws.String("SET #role = :admin"),
+		ConditionExpression: aws.String("attribute_exists(username)"),
+		ExpressionAttributeNames: map[string]*string{
+			"#role": aws.String("role"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":admin": {S: aws.String(roleAdmin)},
+		},
+	})
+	if conditionFailed(err) {
+		return fmt.Errorf("user %v does not exist", username)
+	}
+	if err != nil {
+		return fmt.Errorf("could not make %v an admin: %v", username, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Mailer is implemented by anything that can deliver an email to a single recipient
+// Lets the server send mail through a real SMTP relay, a local SMTP sink for testing, or just the log
+type Mailer interface {
+	Send(to string, subject string, templateName string, body string) error
+}
+
+// Sends mail through an SMTP server
+// Username and Password may be left empty for servers that do not require auth, like a local SMTP sink
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+// Sends an HTML email to the recipient
+// to is the email address to send to
+// subject is the subject line of the email
+// templateName is the template the body was rendered from, for mailers that log instead of sending
+// body is the HTML body of the email
+func (m SMTPMailer) Send(to string, subject string, templateName string, body string) error {
+
+	if to == "" {
+		return fmt.Errorf("no recipient for email %q", subject)
+	}
+
+	// Keep newlines in shoot names and such from injecting headers
+	subject = strings.NewReplacer("\r", "", "\n", "").Replace(subject)
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	// Build the raw message with the minimum headers needed by most mail clients
+	var msg strings.Builder
+	msg.WriteString("From: " + m.From + "\r\n")
+	msg.WriteString("To: " + to + "\r\n")
+	msg.WriteString("Subject: " + subject + "\r\n")
+	msg.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/html; charset=\"utf-8\"\r\n")
+	msg.WriteString("\r\n")
+	msg.WriteString(body)
+
+	err := smtp.SendMail(m.Host+":"+m.Port, auth, m.From, []string{to}, []byte(msg.String()))
+	if err != nil {
+		return fmt.Errorf("could not send email to %v: %v", to, err)
+	}
+
+	return nil
+}
+
+// Writes who emails are for to the log instead of sending them
+// Used when no SMTP server is configured
+// The body is left out since reset, invite and verification emails carry tokens
+type LogMailer struct{}
+
+func (m LogMailer) Send(to string, subject string, templateName string, body string) error {
+	log.Printf("email to %v: %v (%v)", to, subject, templateName)
+	return nil
+}
+
+// Builds the mailer described by the env file
+// Falls back to logging emails if SMTP_HOST is not set
+func newMailer() Mailer {
+
+	host := env("SMTP_HOST")
+	if host == "" {
+		log.Println("SMTP_HOST is not set, emails will be written to the log")
+		return LogMailer{}
+	}
+
+	port := env("SMTP_PORT")
+	if port == "" {
+		port = "25"
+	}
+
+	return SMTPMailer{
+		Host:     host,
+		Port:     port,
+		Username: env("SMTP_USERNAME"),
+		Password: env("SMTP_PASSWORD"),
+		From:     env("SMTP_FROM"),
+	}
+}
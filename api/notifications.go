@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"log"
+	"net/url"
+)
+
+// Data made available to the email templates in ./static/html/email
+type EmailData struct {
+	User      User
+	ShootName string // Display name of the shoot
+	Shoot     Shoot
+	Deadline  string
+	Link      string
+}
+
+// Builds the data passed to an email template
+// baseURL is the public address of the site, used to link back to it. Example: "https://photos.example.com"
+// shootID may be empty for emails that are not about a shoot
+func newEmailData(baseURL string, user User, shootID string, shoot Shoot) EmailData {
+
+	data := EmailData{
+		User:  user,
+		Shoot: shoot,
+		Link:  baseURL + "/home",
+	}
+
+	if shootID != "" {
+		data.ShootName = shootDisplayName(shootID, shoot)
+		data.Link = baseURL + "/shoot/" + url.PathEscape(shootID) + "/0"
+	}
+
+	if deadline, ok := shootDeadline(shoot); ok {
+		data.Deadline = deadline.Format("Monday, January 2 at 3:04 PM MST")
+	}
+
+	return data
+}
+
+// Renders one of the email templates in ./static/html/email
+// Returns the HTML as a string
+func renderEmail(templateName string, data EmailData) (string, error) {
+
+	tmpl, err := template.ParseFiles("./static/html/email/" + templateName)
+	if err != nil {
+		return "", fmt.Errorf("could not parse email template %v: %v", templateName, err)
+	}
+
+	var final bytes.Buffer
+	err = tmpl.Execute(&final, data)
+	if err != nil {
+		return "", fmt.Errorf("could not execute email template %v: %v", templateName, err)
+	}
+
+	return final.String(), nil
+}
+
+// Renders an email template and sends it
+// to is the email address to send to
+// templateName is the file name of the template in ./static/html/email. Example: "welcome.html"
+func sendEmail(mailer Mailer, to string, subject string, templateName string, data EmailData) error {
+
+	body, err := renderEmail(templateName, data)
+	if err != nil {
+		return err
+	}
+
+	return mailer.Send(to, subject, templateName, body)
+}
+
+// Sends an email in the background so a request is not held up by the mail server
+// Failures are only logged since the action that triggered the email has already happened
+func notify(mailer Mailer, to string, subject string, templateName string, data EmailData) {
+
+	if to == "" {
+		log.Printf("not sending %v, no email address", templateName)
+		return
+	}
+
+	go func() {
+		err := sendEmail(mailer, to, subject, templateName, data)
+		if err != nil {
+			log.Printf("could not send %v to %v: %v", templateName, to, err)
+		}
+	}()
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Matches gin style path parameters. Example: ":id"
+var pathParamPattern = regexp.MustCompile(`:([A-Za-z]+)`)
+
+// A query string parameter an API route accepts
+type apiParam struct {
+	Name        string
+	Type        string // OpenAPI type. Example: "integer"
+	Description string
+}
+
+// Query parameters every paginated route accepts
+var paginationParams = []apiParam{
+	{Name: "page", Type: "integer", Description: "Page to return, starting at 1"},
+	{Name: "per_page", Type: "integer", Description: "Items per page, at most " + strconv.Itoa(maxPerPage)},
+}
+
+// Builds the OpenAPI 3 document for a set of API routes
+// The schemas are generated from the request and response types so the document can not drift from the code
+// basePath is where the routes are mounted. Example: "/api/v1"
+func openAPISpec(title string, version string, basePath string, routes []apiRoute) map[string]any {
+
+	schemas := map[string]any{}
+	paths := map[string]map[string]any{}
+
+	errorRef := schemaFor(reflect.TypeOf(APIErrorResponse{}), schemas)
+	paginationRef := schemaFor(reflect.TypeOf(Pagination{}), schemas)
+
+	for _, route := range routes {
+
+		path := pathParamPattern.ReplaceAllString(route.Path, "{$1}")
+
+		var parameters []map[string]any
+		for _, match := range pathParamPattern.FindAllStringSubmatch(route.Path, -1) {
+			parameters = append(parameters, map[string]any{
+				"name":     match[1],
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]any{"type": "string"},
+			})
+		}
+		query := route.Query
+		if route.List {
+			query = append(append([]apiParam{}, paginationParams...), query...)
+		}
+		for _, param := range query {
+			parameters = append(parameters, map[string]any{
+				"name":        param.Name,
+				"in":          "query",
+				"description": param.Description,
+				"schema":      map[string]any{"type": param.Type},
+			})
+		}
+
+		responses := map[string]any{}
+
+		success := route.Status
+		if success == 0 {
+			success = http.StatusOK
+		}
+		if len(route.Produces) > 0 {
+			content := map[string]any{}
+			for _, contentType := range route.Produces {
+				content[contentType] = map[string]any{"schema": map[string]any{"type": "string", "format": "binary"}}
+			}
+			responses[strconv.Itoa(success)] = map[string]any{
+				"description": http.StatusText(success),
+				"content":     content,
+			}
+		} else if route.Response == nil {
+			responses[strconv.Itoa(success)] = map[string]any{"description": http.StatusText(success)}
+		} else {
+			data := schemaFor(reflect.TypeOf(route.Response), schemas)
+			properties := map[string]any{"data": data}
+			if route.List {
+				properties["data"] = map[string]any{"type": "array", "items": data}
+				properties["pagination"] = paginationRef
+			}
+			responses[strconv.Itoa(success)] = map[string]any{
+				"description": http.StatusText(success),
+				"content": map[string]any{
+					"application/json": map[string]any{
+						"schema": map[string]any{"type": "object", "properties": properties},
+					},
+				},
+			}
+		}
+
+		errors := route.Errors
+		if route.Auth {
+			errors = append([]int{http.StatusUnauthorized}, errors...)
+		}
+		for _, status := range append(errors, http.StatusInternalServerError) {
+			responses[strconv.Itoa(status)] = map[string]any{
+				"description": http.StatusText(status),
+				"content": map[string]any{
+					"application/json": map[string]any{"schema": errorRef},
+				},
+			}
+		}
+
+		operation := map[string]any{
+			"operationId": route.Name,
+			"summary":     route.Summary,
+			"tags":        []string{route.Tag},
+			"responses":   responses,
+		}
+		if len(parameters) > 0 {
+			operation["parameters"] = parameters
+		}
+		if route.Upload {
+			operation["requestBody"] = map[string]any{
+				"required": true,
+				"content": map[string]any{
+					"multipart/form-data": map[string]any{
+						"schema": map[string]any{
+							"type":       "object",
+							"required":   []string{"file"},
+							"properties": map[string]any{"file": map[string]any{"type": "string", "format": "binary"}},
+						},
+					},
+				},
+			}
+		} else if route.Request != nil {
+			operation["requestBody"] = map[string]any{
+				"required": true,
+				"content": map[string]any{
+					"application/json": map[string]any{
+						"schema": schemaFor(reflect.TypeOf(route.Request), schemas),
+					},
+				},
+			}
+		}
+		if !route.Auth {
+			operation["security"] = []any{}
+		}
+		if route.Scope != "" {
+			operation["description"] = "API tokens need the " + route.Scope + " scope."
+		}
+
+		if paths[path] == nil {
+			paths[path] = map[string]any{}
+		}
+		paths[path][strings.ToLower(route.Method)] = operation
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   title,
+			"version": version,
+			"description": "Scripts should authenticate with a personal access token from the account page, sent as " +
+				"Authorization: Bearer <token>. State changing requests made with the session cookie instead must send " +
+				"the token from " + basePath + "/csrf, or the csrf-token meta tag on any page, in the " + csrfHeader + " header.",
+		},
+		"servers": []any{map[string]any{"url": basePath}},
+		"paths":   paths,
+		"components": map[string]any{
+			"schemas": schemas,
+			"securitySchemes": map[string]any{
+				"session": map[string]any{"type": "apiKey", "in": "cookie", "name": "authToken"},
+				"token":   map[string]any{"type": "http", "scheme": "bearer"},
+			},
+		},
+		"security": []any{map[string]any{"session": []string{}}, map[string]any{"token": []string{}}},
+	}
+}
+
+// Generates the JSON schema for a Go type
+// Named structs are added to schemas once and referenced from everywhere they are used
+func schemaFor(t reflect.Type, schemas map[string]any) map[string]any {
+
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": schemaFor(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaFor(t.Elem(), schemas)}
+	case reflect.Struct:
+		ref := map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+		if _, exists := schemas[t.Name()]; exists {
+			return ref
+		}
+		// Claim the name before walking the fields so types that refer to themselves do not loop forever
+		schemas[t.Name()] = nil
+		schemas[t.Name()] = structSchema(t, schemas)
+		return ref
+	default:
+		return map[string]any{}
+	}
+}
+
+// Generates the JSON schema for the exported fields of a struct, named the way encoding/json names them
+func structSchema(t reflect.Type, schemas map[string]any) map[string]any {
+
+	properties := map[string]any{}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name := field.Name
+		if tag := field.Tag.Get("json"); tag != "" {
+			tagName, _, _ := strings.Cut(tag, ",")
+			if tagName == "-" {
+				continue
+			}
+			if tagName != "" {
+				name = tagName
+			}
+		}
+
+		properties[name] = schemaFor(field.Type, schemas)
+	}
+
+	return map[string]any{"type": "object", "properties": properties}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/go-redis/redis"
+)
+
+// How long a password reset link is good for
+const resetTokenMinutes = 30
+
+// Creates a single use password reset token for a user and stores it in Redis
+// Returns the token to be emailed to the user
+func createResetToken(r *redis.Client, username string) (string, error) {
+	return createOneTimeToken(r, "reset:", username, time.Minute*resetTokenMinutes)
+}
+
+// Returns the username a reset token belongs to without using it up
+func checkResetToken(r *redis.Client, token string) (string, error) {
+	return checkOneTimeToken(r, "reset:", token)
+}
+
+// Returns the username a reset token belongs to and deletes the token so it can not be used again
+func consumeResetToken(r *redis.Client, token string) (string, error) {
+	return consumeOneTimeToken(r, "reset:", token)
+}
+
+// Finds a user by either their username or their email address
+// login is what the user typed into the forgot password form
+func findUser(tableName string, login string, svc *dynamodb.DynamoDB) (User, error) {
+
+	login = strings.ToLower(strings.TrimSpace(login))
+
+	if !strings.Contains(login, "@") {
+		return getUser(tableName, login, svc)
+	}
+
+	users, err := scanUsers(tableName, svc)
+	if err != nil {
+		return User{}, err
+	}
+
+	for _, user := range users {
+		if strings.ToLower(user.Email) == login {
+			return user, nil
+		}
+	}
+
+	return User{}, errors.New("user does not exist")
+}
+
+// Replaces a user's password hash and salt
+func updatePassword(tableName string, username string, hash string, salt string, svc *dynamodb.DynamoDB) error {
+
+	key := map[string]*dynamodb.AttributeValue{
+		"username": {
+			S: aws.String(username),
+		},
+	}
+
+	updateInput := &dynamodb.UpdateItemInput{
+		TableName:        aws.String(tableName),
+		Key:              key,
+		UpdateExpression: aws.String("SET password = :password, salt = :salt"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":password": {S: aws.String(hash)},
+			":salt":     {S: aws.String(salt)},
+		},
+	}
+
+	_, err := svc.UpdateItem(updateInput)
+	if err != nil {
+		return fmt.Errorf("could not update password: %v", err)
+	}
+
+	return nil
+}
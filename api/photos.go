@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/disintegration/imaging"
+	"github.com/go-redis/redis"
+)
+
+// Renditions are named after their original with a suffix added before the extension
+// Example: "smith/IMG_0001.jpg" has the thumbnail "smith/IMG_0001_thumb.jpg"
+// and the preview "smith/IMG_0001_preview.jpg"
+const (
+	thumbnailSuffix = "_thumb"   // Small rendition shown in the gallery grid
+	previewSuffix   = "_preview" // Large rendition shown in the lightbox
+)
+
+// How long a listing of a shoot's thumbnails is kept in Redis
+// Photos added to S3 by hand show up in the gallery once it runs out
+const thumbnailIndexTTL = 10 * time.Minute
+
+// Most photos one batch of the gallery's infinite scroll can ask for
+const maxGalleryBatch = 100
+
+// Longest edges and JPEG quality of the renditions made for photos uploaded through the API
+const (
+	thumbnailSize    = 1000
+	previewSize      = 2048
+	renditionQuality = 80
+)
+
+// Reports whether an object is a thumbnail the uploader made rather than an original
+// Example: "smith/IMG_0001_thumb.jpg"
+func isThumbnail(key string) bool {
+	return strings.Contains(key[strings.LastIndex(key, "/")+1:], thumbnailSuffix)
+}
+
+// Reports whether an object is the preview of a photo
+// Example: "smith/IMG_0001_preview.jpg"
+func isPreview(key string) bool {
+	return strings.Contains(key[strings.LastIndex(key, "/")+1:], previewSuffix)
+}
+
+// Reports whether an object is any rendition rather than an original
+func isRendition(key string) bool {
+	name := key[strings.LastIndex(key, "/")+1:]
+	return strings.Contains(name, thumbnailSuffix) || strings.Contains(name, previewSuffix)
+}
+
+// Returns the key of the preview that goes with a thumbnail
+// Example: "smith/IMG_0001_thumb.jpg" becomes "smith/IMG_0001_preview.jpg"
+func previewKey(thumbnail string) string {
+	i := strings.LastIndex(thumbnail, thumbnailSuffix)
+	if i < 0 {
+		return thumbnail
+	}
+	return thumbnail[:i] + previewSuffix + thumbnail[i+len(thumbnailSuffix):]
+}
+
+// Returns the key of an original's thumbnail, or the key itself if it already is one
+// Example: "smith/IMG_0001.jpg" becomes "smith/IMG_0001_thumb.jpg"
+func thumbnailKey(key string) string {
+	if isThumbnail(key) {
+		return key
+	}
+	return strings.TrimSuffix(key, path.Ext(key)) + thumbnailSuffix + ".jpg"
+}
+
+// Redis key the renditions listed in a prefix are cached under
+func thumbnailIndexKey(bucket string, prefix string) string {
+	return "thumbs:" + bucket + "/" + prefix
+}
+
+// The renditions listed under a prefix, as kept in Redis
+type photoIndex struct {
+	Thumbnails []string `json:"thumbnails"` // In key order
+	Previews   []string `json:"previews"`   // Only photos the uploader has made a preview for have one
+}
+
+// Lists every thumbnail and preview in a prefix, in key order
+// Follows ListObjectsV2 continuation tokens so shoots of any size are listed in full
+func listRenditions(client *s3.S3, bucket string, prefix string) (photoIndex, error) {
+
+	final := photoIndex{Thumbnails: []string{}, Previews: []string{}}
+
+	err := client.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range page.Contents {
+			switch {
+			case *object.Size == 0:
+			case isThumbnail(*object.Key):
+				final.Thumbnails = append(final.Thumbnails, *object.Key)
+			case isPreview(*object.Key):
+				final.Previews = append(final.Previews, *object.Key)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return photoIndex{}, err
+	}
+
+	return final, nil
+}
+
+// Returns the renditions in a prefix. The prefix is listed once and kept in Redis for thumbnailIndexTTL,
+// so paging through a gallery does not list the whole prefix on every page
+func prefixRenditions(r *redis.Client, client *s3.S3, bucket string, prefix string) (photoIndex, error) {
+
+	cacheKey := thumbnailIndexKey(bucket, prefix)
+	if cached, err := r.Get(cacheKey).Result(); err == nil {
+		var final photoIndex
+		if json.Unmarshal([]byte(cached), &final) == nil && final.Thumbnails != nil {
+			return final, nil
+		}
+	}
+
+	final, err := listRenditions(client, bucket, prefix)
+	if err != nil {
+		return photoIndex{}, fmt.Errorf("could not list thumbnails in %v: %v", prefix, err)
+	}
+
+	encoded, _ := json.Marshal(final)
+	err = r.Set(cacheKey, encoded, thumbnailIndexTTL).Err()
+	if err != nil {
+		log.Printf("could not cache thumbnails of %v: %v", prefix, err)
+	}
+
+	return final, nil
+}
+
+// Returns the thumbnail of every photo in a shoot, in the order the gallery shows them
+// Uses the shoot's file list when it has one, otherwise the renditions listed in its prefix
+func shootThumbnails(r *redis.Client, client *s3.S3, bucket string, shoot Shoot) ([]string, error) {
+
+	if len(shoot.Files) > 0 {
+		final := make([]string, 0, len(shoot.Files))
+		seen := make(map[string]bool)
+		for _, file := range shoot.Files {
+			thumbnail := thumbnailKey(file)
+			if !seen[thumbnail] {
+				seen[thumbnail] = true
+				final = append(final, thumbnail)
+			}
+		}
+		return final, nil
+	}
+
+	if shoot.Prefix == "" {
+		return []string{}, nil
+	}
+
+	index, err := prefixRenditions(r, client, bucket, shoot.Prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	return index.Thumbnails, nil
+}
+
+// Returns the keys of the previews that exist for a shoot's photos, so only those are pre-signed
+// Shoots with a file list look in the folders the files are in
+func shootPreviews(r *redis.Client, client *s3.S3, bucket string, shoot Shoot) (map[string]bool, error) {
+
+	var prefixes []string
+	if len(shoot.Files) > 0 {
+		seen := make(map[string]bool)
+		for _, file := range shoot.Files {
+			folder := path.Dir(file)
+			if folder == "." {
+				continue // Listing the top of the bucket would list every shoot, these photos just use their thumbnails
+			}
+			if !seen[folder] {
+				seen[folder] = true
+				prefixes = append(prefixes, folder)
+			}
+		}
+	} else if shoot.Prefix != "" {
+		prefixes = append(prefixes, shoot.Prefix)
+	}
+
+	final := make(map[string]bool)
+	for _, prefix := range prefixes {
+		index, err := prefixRenditions(r, client, bucket, prefix)
+		if err != nil {
+			return nil, err
+		}
+		for _, preview := range index.Previews {
+			final[preview] = true
+		}
+	}
+
+	return final, nil
+}
+
+// Drops the cached thumbnail listing of a prefix so new photos show up straight away
+func forgetThumbnails(r *redis.Client, bucket string, prefix string) {
+	err := r.Del(thumbnailIndexKey(bucket, prefix)).Err()
+	if err != nil {
+		log.Printf("could not clear cached thumbnails of %v: %v", prefix, err)
+	}
+}
+
+// Works out how many pages a list takes up. An empty list still has one, empty, page
+func pageCount(total int, pageSize int) int {
+	if total == 0 || pageSize < 1 {
+		return 1
+	}
+	return int(math.Ceil(float64(total) / float64(pageSize)))
+}
+
+// Makes the thumbnail and preview of a JPEG and uploads them next to the original
+// Gives photos uploaded through the API the same renditions the uploader makes for the gallery
+func uploadRenditions(client *s3.S3, bucket string, key string, original io.Reader) error {
+
+	img, err := imaging.Decode(original, imaging.AutoOrientation(true))
+	if err != nil {
+		return fmt.Errorf("could not decode %v: %v", key, err)
+	}
+
+	thumbnail := thumbnailKey(key)
+	renditions := map[string]int{
+		thumbnail:             thumbnailSize,
+		previewKey(thumbnail): previewSize,
+	}
+
+	for renditionKey, size := range renditions {
+
+		var encoded bytes.Buffer
+		err = imaging.Encode(&encoded, imaging.Fit(img, size, size, imaging.Lanczos), imaging.JPEG, imaging.JPEGQuality(renditionQuality))
+		if err != nil {
+			return fmt.Errorf("could not encode %v: %v", renditionKey, err)
+		}
+
+		_, err = client.PutObject(&s3.PutObjectInput{
+			Bucket:      aws.String(bucket),
+			Key:         aws.String(renditionKey),
+			Body:        bytes.NewReader(encoded.Bytes()),
+			ContentType: aws.String("image/jpeg"),
+		})
+		if err != nil {
+			return fmt.Errorf("could not upload %v: %v", renditionKey, err)
+		}
+	}
+
+	return nil
+}
+
+// Builds the cursor a gallery batch continues from
+// It holds how many photos have been shown and the key of the last one. The key is used when the photo is
+// still in the shoot, so photos added or removed while someone scrolls do not make the gallery skip or repeat
+func encodeCursor(shown int, lastKey string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(shown) + ":" + lastKey))
+}
+
+// Works out where in a shoot's thumbnails a gallery batch starts
+// An empty cursor starts at the beginning
+func cursorStart(thumbnails []string, cursor string) (int, error) {
+
+	if cursor == "" {
+		return 0, nil
+	}
+
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, errors.New("malformed cursor")
+	}
+	shownText, lastKey, found := strings.Cut(string(decoded), ":")
+	shown, err := strconv.Atoi(shownText)
+	if !found || err != nil || shown < 0 {
+		return 0, errors.New("malformed cursor")
+	}
+
+	for i, key := range thumbnails {
+		if key == lastKey {
+			return i + 1, nil
+		}
+	}
+
+	if shown > len(thumbnails) {
+		return len(thumbnails), nil
+	}
+	return shown, nil
+}
+
+// Cuts a batch of thumbnails out of a shoot starting at start
+// Returns the batch and the cursor for the next one, which is empty once the end of the shoot is reached
+func galleryBatch(thumbnails []string, start int, limit int) ([]string, string) {
+
+	end := start + limit
+	if end > len(thumbnails) {
+		end = len(thumbnails)
+	}
+	if start >= end {
+		return []string{}, ""
+	}
+
+	next := ""
+	if end < len(thumbnails) {
+		next = encodeCursor(end, thumbnails[end-1])
+	}
+
+	return thumbnails[start:end], next
+}
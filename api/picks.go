@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/go-redis/redis"
+)
+
+// Reasons a pick can be rejected
+const (
+	pickNotInShoot = "not in shoot"
+	pickInvalid    = "invalid key"
+)
+
+// Default for the most picks allowed on a shoot when the env file does not set MAX_SELECTIONS
+const defaultMaxSelections = 1000
+
+// Reads the most picks allowed on any one shoot from the env file
+func parseMaxSelections() int {
+	if value, err := strconv.Atoi(env("MAX_SELECTIONS")); err == nil && value > 0 {
+		return value
+	}
+	return defaultMaxSelections
+}
+
+// Works out the most picks a client can make on a shoot
+// Shoots can set a lower limit of their own, maxSelections is the limit for every shoot
+func pickLimit(shoot Shoot, maxSelections int) int {
+	if shoot.MaxPicks > 0 && shoot.MaxPicks < maxSelections {
+		return shoot.MaxPicks
+	}
+	return maxSelections
+}
+
+// Turns an S3 object key into the key the gallery uses for a photo
+// Example: "smith/IMG_0001_thumb.jpg" becomes "IMG_0001"
+func photoKey(objectKey string) string {
+
+	key := objectKey[strings.LastIndex(objectKey, "/")+1:]
+	if i := strings.LastIndex(key, "_thumb"); i >= 0 {
+		key = key[:i]
+	}
+
+	return key
+}
+
+// Returns the keys of every photo in a shoot that can be picked
+func shootPhotoKeys(r *redis.Client, client *s3.S3, bucket string, shoot Shoot) (map[string]bool, error) {
+
+	thumbnails, err := shootThumbnails(r, client, bucket, shoot)
+	if err != nil {
+		return nil, err
+	}
+
+	final := make(map[string]bool)
+	for _, thumbnail := range thumbnails {
+		final[photoKey(thumbnail)] = true
+	}
+
+	return final, nil
+}
+
+// Checks the picks a client sent against the photos in the shoot
+// Duplicates are dropped and the count is worked out here, the count the client sent is ignored
+// Returns the cleaned up picks and any keys that were rejected
+func validatePicks(submitted []string, available map[string]bool) (Picks, []RejectedPick) {
+
+	final := Picks{Picks: []string{}}
+	var rejected []RejectedPick
+	seen := make(map[string]bool)
+
+	for _, key := range submitted {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		if key == "" || strings.Contains(key, "/") {
+			rejected = append(rejected, RejectedPick{Key: key, Reason: pickInvalid})
+			continue
+		}
+		if !available[key] {
+			rejected = append(rejected, RejectedPick{Key: key, Reason: pickNotInShoot})
+			continue
+		}
+
+		final.Picks = append(final.Picks, key)
+	}
+
+	final.Count = len(final.Picks)
+
+	return final, rejected
+}
+
+// Why a set of picks could not be saved
+// Either some of the keys are not photos in the shoot, or there are more picks than the shoot allows
+type PicksError struct {
+	Rejected []RejectedPick
+	Limit    int
+	Count    int
+}
+
+func (e *PicksError) Error() string {
+	if len(e.Rejected) > 0 {
+		return "some picks are not photos in this shoot"
+	}
+	return fmt.Sprintf("only %v photos can be picked from this shoot", e.Limit)
+}
+
+// Validates the picks a client sent for a shoot and works out what should be saved
+// Returns a *PicksError if the picks can not be saved as they are
+func checkPicks(r *redis.Client, client *s3.S3, bucket string, shoot Shoot, submitted []string, maxSelections int) (Picks, error) {
+
+	available, err := shootPhotoKeys(r, client, bucket, shoot)
+	if err != nil {
+		return Picks{}, fmt.Errorf("could not list photos in shoot: %v", err)
+	}
+
+	// Only photos that are actually in the shoot are saved, and nothing is saved if any are not
+	picks, rejected := validatePicks(submitted, available)
+	if len(rejected) > 0 {
+		return Picks{}, &PicksError{Rejected: rejected}
+	}
+
+	limit := pickLimit(shoot, maxSelections)
+	if picks.Count > limit {
+		return Picks{}, &PicksError{Limit: limit, Count: picks.Count}
+	}
+
+	return picks, nil
+}
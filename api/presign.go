@@ -0,0 +1,254 @@
+package main
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/go-redis/redis"
+)
+
+// Most pre-signed urls made at once when a page has cache misses
+const presignWorkers = 16
+
+// Most urls kept in memory, the least recently used are dropped to make room for new ones
+const presignCacheSize = 10000
+
+// Pages are cached by the browser for a little less than their urls have left so no image is ever loaded
+// with a url that has just expired
+const presignClockSkew = 30 * time.Second
+
+// A pre-signed url and when it stops working
+type presignedURL struct {
+	URL     string    `json:"url"`
+	Expires time.Time `json:"expires"`
+}
+
+// A url kept in memory along with the object it is for, so the entry can be found when it is dropped
+type presignEntry struct {
+	key string
+	url presignedURL
+}
+
+// Reuses pre-signed urls for S3 objects until they are close to expiring
+// Urls are reused while they have at least half of their lifetime left, so a page built from cached urls
+// can always be cached by the browser for at least half the lifetime too
+// When Redis is set every server shares the cache, otherwise each server keeps its own in memory
+type PresignCache struct {
+	client   *s3.S3
+	bucket   string
+	lifetime time.Duration
+	redis    *redis.Client // Optional
+
+	mu      sync.Mutex
+	entries map[string]*list.Element // Elements of recent, by object key
+	recent  *list.List               // Entries from most to least recently used
+}
+
+// Creates a cache of urls that are good for minutes
+// r can be nil to keep the cache in memory only
+func newPresignCache(client *s3.S3, bucket string, minutes int64, r *redis.Client) *PresignCache {
+
+	if minutes < 1 {
+		minutes = 1
+	}
+
+	return &PresignCache{
+		client:   client,
+		bucket:   bucket,
+		lifetime: time.Duration(minutes) * time.Minute,
+		redis:    r,
+		entries:  make(map[string]*list.Element),
+		recent:   list.New(),
+	}
+}
+
+// Reports whether a cached url has enough time left to hand out again
+func (p *PresignCache) fresh(url presignedURL, now time.Time) bool {
+	return url.Expires.Sub(now) >= p.lifetime/2
+}
+
+// Redis key a url is shared under
+func (p *PresignCache) redisKey(key string) string {
+	return "presign:" + p.bucket + "/" + key
+}
+
+// Looks for a url that can be reused, first in memory and then in Redis
+func (p *PresignCache) lookup(key string, now time.Time) (presignedURL, bool) {
+
+	p.mu.Lock()
+	element, exists := p.entries[key]
+	var url presignedURL
+	if exists {
+		url = element.Value.(*presignEntry).url
+		p.recent.MoveToFront(element)
+	}
+	p.mu.Unlock()
+	if exists && p.fresh(url, now) {
+		return url, true
+	}
+
+	if p.redis == nil {
+		return presignedURL{}, false
+	}
+
+	cached, err := p.redis.Get(p.redisKey(key)).Result()
+	if err != nil {
+		return presignedURL{}, false
+	}
+	if json.Unmarshal([]byte(cached), &url) != nil || !p.fresh(url, now) {
+		return presignedURL{}, false
+	}
+
+	p.remember(key, url)
+	return url, true
+}
+
+// Keeps a url in memory, dropping the least recently used once the cache is full
+func (p *PresignCache) remember(key string, url presignedURL) {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if element, exists := p.entries[key]; exists {
+		element.Value.(*presignEntry).url = url
+		p.recent.MoveToFront(element)
+		return
+	}
+
+	p.entries[key] = p.recent.PushFront(&presignEntry{key: key, url: url})
+
+	for p.recent.Len() > presignCacheSize {
+		oldest := p.recent.Back()
+		p.recent.Remove(oldest)
+		delete(p.entries, oldest.Value.(*presignEntry).key)
+	}
+}
+
+// Pre-signs a new url for an object and stores it
+func (p *PresignCache) sign(key string, now time.Time) (presignedURL, error) {
+
+	req, _ := p.client.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(p.bucket),
+		Key:    aws.String(key),
+	})
+
+	signed, err := req.Presign(p.lifetime)
+	if err != nil {
+		return presignedURL{}, fmt.Errorf("could not presign %v: %v", key, err)
+	}
+
+	url := presignedURL{URL: signed, Expires: now.Add(p.lifetime)}
+	p.remember(key, url)
+
+	if p.redis != nil {
+		encoded, _ := json.Marshal(url)
+		// Only shared for as long as other servers could reuse it
+		err = p.redis.Set(p.redisKey(key), encoded, p.lifetime/2).Err()
+		if err != nil {
+			log.Printf("could not share pre-signed url of %v: %v", key, err)
+		}
+	}
+
+	return url, nil
+}
+
+// Returns a pre-signed url for an object and when it expires
+func (p *PresignCache) URL(key string) (string, time.Time, error) {
+
+	now := time.Now()
+	if url, ok := p.lookup(key, now); ok {
+		return url.URL, url.Expires, nil
+	}
+
+	url, err := p.sign(key, now)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return url.URL, url.Expires, nil
+}
+
+// Returns pre-signed urls for objects, in the same order as keys, along with when the first of them expires
+// Urls that are not cached are signed in parallel
+func (p *PresignCache) URLs(keys []string) ([]string, time.Time, error) {
+
+	now := time.Now()
+	urls := make([]presignedURL, len(keys))
+	var missing []int
+
+	for i, key := range keys {
+		if url, ok := p.lookup(key, now); ok {
+			urls[i] = url
+		} else {
+			missing = append(missing, i)
+		}
+	}
+
+	if len(missing) > 0 {
+		var wg sync.WaitGroup
+		var errMu sync.Mutex
+		var firstErr error
+		jobs := make(chan int)
+
+		workers := presignWorkers
+		if len(missing) < workers {
+			workers = len(missing)
+		}
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range jobs {
+					url, err := p.sign(keys[i], now)
+					if err != nil {
+						errMu.Lock()
+						if firstErr == nil {
+							firstErr = err
+						}
+						errMu.Unlock()
+						continue
+					}
+					urls[i] = url
+				}
+			}()
+		}
+		for _, i := range missing {
+			jobs <- i
+		}
+		close(jobs)
+		wg.Wait()
+
+		if firstErr != nil {
+			return nil, time.Time{}, firstErr
+		}
+	}
+
+	final := make([]string, len(urls))
+	expires := now.Add(p.lifetime)
+	for i, url := range urls {
+		final[i] = url.URL
+		if url.Expires.Before(expires) {
+			expires = url.Expires
+		}
+	}
+
+	return final, expires, nil
+}
+
+// Builds a Cache-Control header that lets the browser keep a page only as long as its urls still work
+func presignedCacheControl(expires time.Time) string {
+
+	maxAge := int(time.Until(expires.Add(-presignClockSkew)).Seconds())
+	if maxAge <= 0 {
+		return "no-store"
+	}
+
+	return "private, max-age=" + strconv.Itoa(maxAge)
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// A token bucket. Burst is how many requests can be made at once
+// and PerMinute is how quickly the bucket refills afterwards
+type RateLimit struct {
+	Burst     int
+	PerMinute int
+}
+
+// Settings for locking an account after repeated failed logins
+type LockoutPolicy struct {
+	Threshold int           // Failed logins allowed before the account is locked
+	Duration  time.Duration // How long the account stays locked, also how long failures are remembered
+}
+
+// Max number of lockout events kept for admins to look at
+const maxLockoutEvents = 1000
+
+// Takes a token from a bucket if there is one
+// Runs in Redis so the limit is shared between every instance of the server
+// KEYS[1] is the bucket, ARGV is burst, tokens per second and the current time in milliseconds
+var takeTokenScript = redis.NewScript(`
+local bucket = redis.call("HMGET", KEYS[1], "tokens", "updated")
+local burst = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+local tokens = tonumber(bucket[1])
+local updated = tonumber(bucket[2])
+if tokens == nil then
+	tokens = burst
+	updated = now
+end
+
+tokens = math.min(burst, tokens + (now - updated) / 1000 * rate)
+
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "updated", tostring(now))
+redis.call("PEXPIRE", KEYS[1], math.ceil(burst / rate * 1000))
+
+return allowed
+`)
+
+// Reads a rate limit from the env file, falling back to the defaults
+// prefix is the start of the env entries. Example: "LOGIN_IP" reads LOGIN_IP_BURST and LOGIN_IP_PER_MINUTE
+func parseRateLimit(prefix string, burst int, perMinute int) RateLimit {
+
+	limit := RateLimit{Burst: burst, PerMinute: perMinute}
+
+	if value, err := strconv.Atoi(env(prefix + "_BURST")); err == nil && value > 0 {
+		limit.Burst = value
+	}
+	if value, err := strconv.Atoi(env(prefix + "_PER_MINUTE")); err == nil && value > 0 {
+		limit.PerMinute = value
+	}
+
+	return limit
+}
+
+// Reads a lockout policy from the env file, falling back to the defaults
+// prefix is the start of the env entries. Example: "LOCKOUT" reads LOCKOUT_THRESHOLD and LOCKOUT_MINUTES
+func parseLockoutPolicy(prefix string, threshold int, minutes int) LockoutPolicy {
+
+	policy := LockoutPolicy{Threshold: threshold, Duration: time.Minute * time.Duration(minutes)}
+
+	if value, err := strconv.Atoi(env(prefix + "_THRESHOLD")); err == nil && value > 0 {
+		policy.Threshold = value
+	}
+	if value, err := strconv.Atoi(env(prefix + "_MINUTES")); err == nil && value > 0 {
+		policy.Duration = time.Minute * time.Duration(value)
+	}
+
+	return policy
+}
+
+// Reads the proxies allowed to say who the client is from the TRUSTED_PROXIES env entry
+// proxies is a comma separated list of IPs or CIDRs. Example: "10.0.0.0/8,192.168.1.5"
+// Returns nil when none are set, so the client IP is always the address the request came from
+func parseTrustedProxies(proxies string) []string {
+
+	var final []string
+	for _, proxy := range strings.Split(proxies, ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			final = append(final, proxy)
+		}
+	}
+
+	return final
+}
+
+// Reports whether a request fits within the rate limit
+// key identifies who is being limited. Example: "login-ip:127.0.0.1"
+// Fails open if Redis can not be reached so a Redis hiccup does not lock everyone out
+func allowRequest(r *redis.Client, key string, limit RateLimit) bool {
+
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	perSecond := float64(limit.PerMinute) / 60
+
+	allowed, err := takeTokenScript.Run(r, []string{"ratelimit:" + key}, limit.Burst, perSecond, now).Int()
+	if err != nil {
+		log.Printf("could not check rate limit for %v: %v", key, err)
+		return true
+	}
+
+	return allowed == 1
+}
+
+// Reports whether a username is locked out from logging in
+func isLockedOut(r *redis.Client, username string) bool {
+	exists, err := r.Exists("lockout:" + username).Result()
+	return err == nil && exists == 1
+}
+
+// Counts a failed login against a username and locks it once it hits the threshold
+// Failures are counted whether or not the user exists so lockouts do not reveal which usernames are real
+func recordLoginFailure(r *redis.Client, username string, ip string, policy LockoutPolicy) {
+
+	key := "login-failures:" + username
+	failures, err := r.Incr(key).Result()
+	if err != nil {
+		log.Printf("could not record failed login for %v: %v", username, err)
+		return
+	}
+	r.Expire(key, policy.Duration)
+
+	if failures < int64(policy.Threshold) {
+		return
+	}
+
+	err = r.Set("lockout:"+username, ip, policy.Duration).Err()
+	if err != nil {
+		log.Printf("could not lock out %v: %v", username, err)
+		return
+	}
+	r.Del(key)
+
+	log.Printf("locked out %v for %v after %v failed logins, last from %v", username, policy.Duration, failures, ip)
+
+	event, _ := json.Marshal(LockoutEvent{
+		Username: username,
+		IP:       ip,
+		Failures: int(failures),
+		Time:     time.Now().Format(time.RFC3339),
+		Until:    time.Now().Add(policy.Duration).Format(time.RFC3339),
+	})
+	r.LPush("lockout-events", event)
+	r.LTrim("lockout-events", 0, maxLockoutEvents-1)
+}
+
+// Forgets failed logins once a user gets their password right
+func clearLoginFailures(r *redis.Client, username string) {
+	r.Del("login-failures:" + username)
+}
+
+// Lifts a lockout early, used by admins
+func unlockUser(r *redis.Client, username string) error {
+	return r.Del("lockout:"+username, "login-failures:"+username).Err()
+}
+
+// Returns the most recent lockout events, newest first
+func getLockoutEvents(r *redis.Client) ([]LockoutEvent, error) {
+
+	raw, err := r.LRange("lockout-events", 0, maxLockoutEvents-1).Result()
+	if err != nil {
+		return []LockoutEvent{}, err
+	}
+
+	final := make([]LockoutEvent, 0, len(raw))
+	for _, entry := range raw {
+		var event LockoutEvent
+		if json.Unmarshal([]byte(entry), &event) == nil {
+			final = append(final, event)
+		}
+	}
+
+	return final, nil
+}
+
+// Reports whether a share link has had too many wrong passwords to take another try
+// Counted per link so spreading guesses over many IPs does not help
+func shareLockedOut(r *redis.Client, id string, policy LockoutPolicy) bool {
+	failures, err := r.Get("share-failures:" + id).Int()
+	return err == nil && failures >= policy.Threshold
+}
+
+// Counts a wrong password against a share link
+// The count is forgotten policy.Duration after the first wrong password
+func recordShareFailure(r *redis.Client, id string, policy LockoutPolicy) {
+
+	key := "share-failures:" + id
+	failures, err := r.Incr(key).Result()
+	if err != nil {
+		log.Printf("could not record wrong password for share link %v: %v", id, err)
+		return
+	}
+	if failures == 1 {
+		r.Expire(key, policy.Duration)
+	}
+	if failures == int64(policy.Threshold) {
+		log.Printf("share link %v takes no more passwords for %v after %v wrong ones", id, policy.Duration, failures)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+
+	"main/export"
+)
+
+// A rating sent for one photo by the gallery
+type RatingRequest struct {
+	Key    string `json:"key"` // Photo key. Example: "IMG_0001"
+	Rating int    `json:"rating"`
+	Label  string `json:"label"`
+}
+
+// Checks a rating is one Lightroom can show
+// Labels are matched without caring about case and returned the way Lightroom spells them
+func checkRating(rating PhotoRating) (PhotoRating, error) {
+
+	if rating.Rating < 0 || rating.Rating > export.MaxRating {
+		return PhotoRating{}, fmt.Errorf("rating must be from 0 to %v", export.MaxRating)
+	}
+
+	if rating.Label != "" {
+		label, ok := export.ValidLabel(rating.Label)
+		if !ok {
+			return PhotoRating{}, fmt.Errorf("label must be one of %v", strings.Join(export.Labels, ", "))
+		}
+		rating.Label = label
+	}
+
+	return rating, nil
+}
+
+// Works out whether a user can change the ratings on a shoot
+// Clients rate alongside their picks so they lose the ability when the picks lock,
+// photographers and second shooters can keep rating after the deadline
+func canRate(user User, shoot Shoot, grace time.Duration) bool {
+	return isPhotographer(user) || !selectionsLocked(shoot, grace)
+}
+
+// Reads the fewest stars a photo needs to be shown from a query parameter
+// An empty value shows every photo
+func parseMinRating(value string) (int, error) {
+
+	if value == "" {
+		return 0, nil
+	}
+
+	minRating, err := strconv.Atoi(value)
+	if err != nil || minRating < 0 || minRating > export.MaxRating {
+		return 0, fmt.Errorf("rating must be a whole number from 0 to %v", export.MaxRating)
+	}
+
+	return minRating, nil
+}
+
+// Keeps only the thumbnails of photos rated at least minRating stars
+// A minRating of 0 keeps every photo
+func filterByRating(thumbnails []string, ratings map[string]PhotoRating, minRating int) []string {
+
+	if minRating <= 0 {
+		return thumbnails
+	}
+
+	final := []string{}
+	for _, thumbnail := range thumbnails {
+		if ratings[photoKey(thumbnail)].Rating >= minRating {
+			final = append(final, thumbnail)
+		}
+	}
+
+	return final
+}
+
+// Sets the rating and label of one photo in a shoot, or clears them when both are empty
+// The ratings map only exists once a photo has been rated, so the first rating creates it
+func setPhotoRating(tableName string, username string, shootID string, key string, rating PhotoRating, svc *dynamodb.DynamoDB) error {
+
+	shoot, _ := shootPath(shootID)
+	ratings, ratingsNames := shootPath(shootID, "ratings")
+	photo, names := shootPath(shootID, "ratings", key)
+
+	userKey := map[string]*dynamodb.AttributeValue{
+		"username": {
+			S: aws.String(username),
+		},
+	}
+
+	if rating.Rating == 0 && rating.Label == "" {
+		_, err := svc.UpdateItem(&dynamodb.UpdateItemInput{
+			TableName:                aws.String(tableName),
+			Key:                      userKey,
+			UpdateExpression:         aws.String("REMOVE " + photo),
+			ConditionExpression:      aws.String("attribute_exists(" + ratings + ")"),
+			ExpressionAttributeNames: names,
+		})
+		if err != nil && !conditionFailed(err) {
+			return fmt.Errorf("could not clear rating: %v", err)
+		}
+		return nil
+	}
+
+	value, err := dynamodbattribute.Marshal(rating)
+	if err != nil {
+		return fmt.Errorf("could not marshal rating: %v", err)
+	}
+
+	// Another request can create the map between the two updates, so try the first one again if it does
+	for attempt := 0; attempt < 2; attempt++ {
+
+		_, err = svc.UpdateItem(&dynamodb.UpdateItemInput{
+			TableName:                aws.String(tableName),
+			Key:                      userKey,
+			UpdateExpression:         aws.String("SET " + photo + " = :rating"),
+			ConditionExpression:      aws.String("attribute_exists(" + ratings + ")"),
+			ExpressionAttributeNames: names,
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":rating": value,
+			},
+		})
+		if !conditionFailed(err) {
+			break
+		}
+
+		// There is no ratings map yet, so create it holding just this photo
+		_, err = svc.UpdateItem(&dynamodb.UpdateItemInput{
+			TableName:                aws.String(tableName),
+			Key:                      userKey,
+			UpdateExpression:         aws.String("SET " + ratings + " = :ratings"),
+			ConditionExpression:      aws.String("attribute_exists(" + shoot + ") AND attribute_not_exists(" + ratings + ")"),
+			ExpressionAttributeNames: ratingsNames,
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":ratings": {M: map[string]*dynamodb.AttributeValue{key: value}},
+			},
+		})
+		if !conditionFailed(err) {
+			break
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("could not set rating: %v", err)
+	}
+
+	return nil
+}
+
+// Reports whether a DynamoDB update was skipped because its condition did not hold
+func conditionFailed(err error) bool {
+	var awsErr awserr.Error
+	return errors.As(err, &awsErr) && awsErr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+}
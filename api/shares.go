@@ -0,0 +1,422 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/gin-gonic/gin"
+)
+
+// What a share link lets people do
+// Every link can view the shoot, the others have to be given when the link is made
+const (
+	shareView     = "view"
+	shareFavorite = "favorite" // Keep a list of favorites of their own, separate from the client's picks
+	shareDownload = "download" // Download zips of the delivered originals
+)
+
+// Start of every share token so they are easy to spot in logs
+const shareTokenPrefix = "cps"
+
+// Most share links one shoot can have
+const maxShareLinks = 20
+
+// Longest name a share link can have
+const maxShareNameLength = 100
+
+// How long entering a share link's password lasts before it has to be entered again
+const shareUnlockTTL = 12 * time.Hour
+
+// Why a share link can not be used
+type ShareError struct {
+	Status int
+	Reason string
+}
+
+func (e *ShareError) Error() string {
+	return e.Reason
+}
+
+// Builds the token at the end of a share link
+// The token says nothing about whose shoot it is, the id is looked up in the data table to find that
+// Example: cps.Q3J5cHRvR3JhcGh5.<secret>
+func formatShareToken(id string, secret string) string {
+	return strings.Join([]string{shareTokenPrefix, id, secret}, ".")
+}
+
+// Splits a share token into the link id and the secret
+func parseShareToken(token string) (string, string, error) {
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != shareTokenPrefix || parts[1] == "" || parts[2] == "" {
+		return "", "", errors.New("malformed share link")
+	}
+
+	return parts[1], parts[2], nil
+}
+
+// Key of the data table item that says which shoot a share link is for
+func shareLookupKey(id string) map[string]*dynamodb.AttributeValue {
+	return dataKey("share#"+id, "share")
+}
+
+// Reports whether a share link lets people do something
+func shareAllows(link ShareLink, permission string) bool {
+	return permission == shareView || containsString(link.Permissions, permission)
+}
+
+// Reports whether a share link has expired
+func shareExpired(link ShareLink, now time.Time) bool {
+	if link.Expires == "" {
+		return false
+	}
+	expires, err := time.Parse(time.RFC3339, link.Expires)
+	return err != nil || !now.Before(expires)
+}
+
+// Builds the API view of a share link
+func newShareResource(id string, link ShareLink) ShareResource {
+	return ShareResource{
+		ID:          id,
+		Name:        link.Name,
+		Permissions: append([]string{shareView}, link.Permissions...),
+		Password:    link.PasswordHash != "",
+		Expires:     link.Expires,
+		Views:       link.Views,
+		Created:     link.Created,
+		CreatedBy:   link.CreatedBy,
+		LastViewed:  link.LastViewed,
+	}
+}
+
+// Lists a shoot's share links, newest first
+func shareResources(shoot Shoot) []ShareResource {
+
+	final := make([]ShareResource, 0, len(shoot.Shares))
+	for id, link := range shoot.Shares {
+		final = append(final, newShareResource(id, link))
+	}
+	sort.Slice(final, func(i, j int) bool {
+		if final[i].Created != final[j].Created {
+			return final[i].Created > final[j].Created
+		}
+		return final[i].ID < final[j].ID
+	})
+
+	return final
+}
+
+// Checks a request for a share link and builds the link to store
+// Returns a *ShareError if the request is not valid
+func newShareLink(request ShareRequest, createdBy string, shoot Shoot, now time.Time) (ShareLink, error) {
+
+	if len(shoot.Shares) >= maxShareLinks {
+		return ShareLink{}, &ShareError{Status: http.StatusUnprocessableEntity, Reason: fmt.Sprintf("shoots can have at most %v share links, revoke one first", maxShareLinks)}
+	}
+
+	name := strings.TrimSpace(request.Name)
+	if name == "" || len(name) > maxShareNameLength {
+		return ShareLink{}, &ShareError{Status: http.StatusBadRequest, Reason: fmt.Sprintf("share links need a name of at most %v characters", maxShareNameLength)}
+	}
+
+	permissions := []string{}
+	for _, permission := range request.Permissions {
+		switch permission {
+		case shareView:
+		case shareFavorite, shareDownload:
+			if !containsString(permissions, permission) {
+				permissions = append(permissions, permission)
+			}
+		default:
+			return ShareLink{}, &ShareError{Status: http.StatusBadRequest, Reason: fmt.Sprintf("permissions can only be %v, %v or %v", shareView, shareFavorite, shareDownload)}
+		}
+	}
+
+	if request.Expires != "" {
+		expires, err := time.Parse(time.RFC3339, request.Expires)
+		if err != nil {
+			return ShareLink{}, &ShareError{Status: http.StatusBadRequest, Reason: "expires must be an RFC 3339 timestamp"}
+		}
+		if !expires.After(now) {
+			return ShareLink{}, &ShareError{Status: http.StatusBadRequest, Reason: "expires must be in the future"}
+		}
+	}
+
+	link := ShareLink{
+		Name:        name,
+		Permissions: permissions,
+		Expires:     request.Expires,
+		Created:     now.Format(time.RFC3339),
+		CreatedBy:   createdBy,
+	}
+
+	if request.Password != "" {
+		err := setSharePassword(&link, request.Password)
+		if err != nil {
+			return ShareLink{}, err
+		}
+	}
+
+	return link, nil
+}
+
+// Sets the password on a share link
+// The unlock nonce is replaced along with it, so browsers that entered an old password are locked out
+func setSharePassword(link *ShareLink, password string) error {
+
+	hash, salt, err := hashPassword(password)
+	if err != nil {
+		return fmt.Errorf("could not hash share password: %v", err)
+	}
+
+	nonce, err := generateURLToken()
+	if err != nil {
+		return fmt.Errorf("could not make share unlock nonce: %v", err)
+	}
+
+	link.PasswordHash, link.Salt, link.UnlockNonce = hash, salt, nonce
+
+	return nil
+}
+
+// Stores a new share link on a shoot
+// Returns the token for the link, which is only ever shown this once, along with the link's id
+func addShareLink(tableName string, dataTable string, username string, shootID string, link ShareLink, svc *dynamodb.DynamoDB) (string, string, error) {
+
+	id, err := generateURLToken()
+	if err != nil {
+		return "", "", err
+	}
+	id = id[:16]
+
+	secret, err := generateURLToken()
+	if err != nil {
+		return "", "", err
+	}
+	link.Hash = hashAPITokenSecret(secret)
+
+	value, err := dynamodbattribute.Marshal(link)
+	if err != nil {
+		return "", "", fmt.Errorf("could not marshal share link: %v", err)
+	}
+
+	// The lookup goes in first, a lookup for a link that never got stored just leads nowhere
+	lookup := shareLookupKey(id)
+	lookup["owner"] = &dynamodb.AttributeValue{S: aws.String(username)}
+	lookup["shoot"] = &dynamodb.AttributeValue{S: aws.String(shootID)}
+	_, err = svc.PutItem(&dynamodb.PutItemInput{
+		TableName:           aws.String(dataTable),
+		Item:                lookup,
+		ConditionExpression: aws.String("attribute_not_exists(pk)"),
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("could not create share link: %v", err)
+	}
+
+	key := map[string]*dynamodb.AttributeValue{
+		"username": {
+			S: aws.String(username),
+		},
+	}
+	shoot, _ := shootPath(shootID)
+	shares, sharesNames := shootPath(shootID, "shares")
+
+	// The shares map has to exist before a link can be set inside it
+	_, err = svc.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName:                aws.String(tableName),
+		Key:                      key,
+		UpdateExpression:         aws.String("SET " + shares + " = if_not_exists(" + shares + ", :empty)"),
+		ConditionExpression:      aws.String("attribute_exists(" + shoot + ")"),
+		ExpressionAttributeNames: sharesNames,
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":empty": {M: map[string]*dynamodb.AttributeValue{}},
+		},
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("could not create share link: %v", err)
+	}
+
+	path, names := shootPath(shootID, "shares", id)
+	_, err = svc.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName:                aws.String(tableName),
+		Key:                      key,
+		UpdateExpression:         aws.String("SET " + path + " = :link"),
+		ExpressionAttributeNames: names,
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":link": value,
+		},
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("could not create share link: %v", err)
+	}
+
+	return formatShareToken(id, secret), id, nil
+}
+
+// Deletes a share link so it stops working straight away
+// Returns false if the shoot has no link with that id
+func revokeShareLink(tableName string, dataTable string, username string, shootID string, id string, svc *dynamodb.DynamoDB) (bool, error) {
+
+	path, names := shootPath(shootID, "shares", id)
+
+	_, err := svc.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"username": {
+				S: aws.String(username),
+			},
+		},
+		UpdateExpression:         aws.String("REMOVE " + path),
+		ConditionExpression:      aws.String("attribute_exists(" + path + ")"),
+		ExpressionAttributeNames: names,
+	})
+	if conditionFailed(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("could not revoke share link: %v", err)
+	}
+
+	// The link is already gone from the shoot, so a lookup left behind still leads nowhere
+	forgetShareLinks(dataTable, []string{id}, svc)
+
+	return true, nil
+}
+
+// Deletes the lookups for share links that no longer exist
+func forgetShareLinks(dataTable string, ids []string, svc *dynamodb.DynamoDB) {
+	for _, id := range ids {
+		_, err := svc.DeleteItem(&dynamodb.DeleteItemInput{
+			TableName: aws.String(dataTable),
+			Key:       shareLookupKey(id),
+		})
+		if err != nil {
+			log.Printf("could not delete lookup for share link %v: %v", id, err)
+		}
+	}
+}
+
+// Counts a view of a share link and records when it happened
+func recordShareView(tableName string, username string, shootID string, id string, svc *dynamodb.DynamoDB) error {
+
+	path, names := shootPath(shootID, "shares", id)
+	names["#views"] = aws.String("views")
+	names["#lastViewed"] = aws.String("lastViewed")
+
+	_, err := svc.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"username": {
+				S: aws.String(username),
+			},
+		},
+		// The link can be revoked while it is being viewed, so only count views of links that still exist
+		UpdateExpression:         aws.String("ADD " + path + ".#views :one SET " + path + ".#lastViewed = :now"),
+		ConditionExpression:      aws.String("attribute_exists(" + path + ")"),
+		ExpressionAttributeNames: names,
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":one": {N: aws.String("1")},
+			":now": {S: aws.String(time.Now().Format(time.RFC3339))},
+		},
+	})
+	if err != nil && !conditionFailed(err) {
+		return fmt.Errorf("could not count share link view: %v", err)
+	}
+
+	return nil
+}
+
+// Looks up the shoot a share link is for
+// Returns the owner, the shoot id, the shoot and the link, or a *ShareError if the link can not be used
+// Unknown, revoked and wrong links all look the same so links can not be guessed one part at a time
+func resolveShareLink(tableName string, dataTable string, token string, svc *dynamodb.DynamoDB) (User, string, Shoot, string, ShareLink, error) {
+
+	notFound := &ShareError{Status: http.StatusNotFound, Reason: "this link is not valid, it may have been revoked"}
+
+	id, secret, err := parseShareToken(token)
+	if err != nil {
+		return User{}, "", Shoot{}, "", ShareLink{}, notFound
+	}
+
+	result, err := svc.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(dataTable),
+		Key:       shareLookupKey(id),
+	})
+	if err != nil || result.Item == nil || result.Item["owner"] == nil || result.Item["shoot"] == nil {
+		return User{}, "", Shoot{}, "", ShareLink{}, notFound
+	}
+	username, shootID := aws.StringValue(result.Item["owner"].S), aws.StringValue(result.Item["shoot"].S)
+
+	owner, err := getUser(tableName, username, svc)
+	if err != nil {
+		return User{}, "", Shoot{}, "", ShareLink{}, notFound
+	}
+
+	shoot, exists := owner.Shoots[shootID]
+	if !exists || shootID == placeholderShoot {
+		return User{}, "", Shoot{}, "", ShareLink{}, notFound
+	}
+
+	link, exists := shoot.Shares[id]
+	if !exists || subtle.ConstantTimeCompare([]byte(hashAPITokenSecret(secret)), []byte(link.Hash)) != 1 {
+		return User{}, "", Shoot{}, "", ShareLink{}, notFound
+	}
+
+	if shareExpired(link, time.Now()) {
+		return User{}, "", Shoot{}, "", ShareLink{}, &ShareError{Status: http.StatusGone, Reason: "this link has expired"}
+	}
+
+	return owner, shootID, shoot, id, link, nil
+}
+
+// Name of the cookie that remembers a share link's password was entered
+func shareCookieName(id string) string {
+	return "share-" + id
+}
+
+// Reports whether the password for a share link has been entered in this browser
+// Links without a password are always unlocked
+func shareUnlocked(c *gin.Context, cookies *CookieCodec, id string, link ShareLink) bool {
+
+	if link.PasswordHash == "" {
+		return true
+	}
+
+	cookie, err := c.Cookie(shareCookieName(id))
+	if err != nil {
+		return false
+	}
+
+	// Cookies are signed but not encrypted, so they hold the link's unlock nonce and never anything about the password
+	var nonce string
+	err = cookies.Decode(shareCookieName(id), cookie, &nonce)
+	return err == nil && link.UnlockNonce != "" && subtle.ConstantTimeCompare([]byte(nonce), []byte(link.UnlockNonce)) == 1
+}
+
+// Remembers that the password for a share link was entered, for shareUnlockTTL
+func unlockShare(c *gin.Context, cookies *CookieCodec, id string, link ShareLink) error {
+
+	value, err := cookies.Encode(shareCookieName(id), link.UnlockNonce, shareUnlockTTL)
+	if err != nil {
+		return err
+	}
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(shareCookieName(id), value, int(shareUnlockTTL.Seconds()), "/s/", c.Request.Host, true, true)
+
+	return nil
+}
+
+// Address of a share link
+func shareURL(baseURL string, token string) string {
+	return baseURL + "/s/" + token
+}
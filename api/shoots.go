@@ -0,0 +1,982 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/go-redis/redis"
+)
+
+// Key of the empty shoot new accounts are created with so the shoots map always exists
+const placeholderShoot = "placeholder"
+
+// What a shoot id looks like. Lower case letters and numbers separated by single dashes
+var shootIDPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// Anything that can not be part of a shoot id
+var notSlugCharacters = regexp.MustCompile(`[^a-z0-9]+`)
+
+// Longest a generated shoot id can be, not counting the suffix added to keep it unique
+const maxShootIDLength = 48
+
+// Reports whether a string is a valid shoot id
+func validShootID(id string) bool {
+	return shootIDPattern.MatchString(id)
+}
+
+// Turns a shoot's display name into a url and expression safe slug
+// Example: "Smith Wedding 6.14" becomes "smith-wedding-6-14"
+func slugify(name string) string {
+
+	slug := notSlugCharacters.ReplaceAllString(strings.ToLower(name), "-")
+	slug = strings.Trim(slug, "-")
+
+	if len(slug) > maxShootIDLength {
+		slug = strings.TrimRight(slug[:maxShootIDLength], "-")
+	}
+	if slug == "" || slug == placeholderShoot {
+		slug = "shoot"
+	}
+
+	return slug
+}
+
+// Generates an id for a new shoot that does not clash with any of the user's other shoots
+// name is the display name of the shoot. Example: "Smith Wedding"
+// existing is the user's current shoots, keyed by id
+func newShootID(name string, existing map[string]Shoot) string {
+
+	base := slugify(name)
+	id := base
+
+	for i := 2; ; i++ {
+		if _, taken := existing[id]; !taken {
+			return id
+		}
+		id = fmt.Sprintf("%v-%v", base, i)
+	}
+}
+
+// Returns the name to show users for a shoot
+// Shoots from before display names existed fall back to their id
+func shootDisplayName(id string, shoot Shoot) string {
+	if shoot.Name != "" {
+		return shoot.Name
+	}
+	return id
+}
+
+// Re-keys shoots that are keyed by display name, like the ones in an invite, by generated id
+func shootsByID(shoots map[string]Shoot) map[string]Shoot {
+
+	final := make(map[string]Shoot)
+
+	for name, shoot := range shoots {
+		if shoot.Name == "" {
+			shoot.Name = name
+		}
+		final[newShootID(shoot.Name, final)] = shoot
+	}
+
+	return final
+}
+
+// Builds the document path to a shoot, or to an attribute inside it, for use in expressions
+// The shoot id and attribute names go in ExpressionAttributeNames so they are never parsed as
+// part of the expression itself
+// Returns the path and the names to pass along with it. Example: "#shoots.#shoot.#p0"
+func shootPath(shootID string, attributes ...string) (string, map[string]*string) {
+
+	path := "#shoots.#shoot"
+	names := map[string]*string{
+		"#shoots": aws.String("shoots"),
+		"#shoot":  aws.String(shootID),
+	}
+
+	for i, attribute := range attributes {
+		placeholder := fmt.Sprintf("#p%v", i)
+		path += "." + placeholder
+		names[placeholder] = aws.String(attribute)
+	}
+
+	return path, names
+}
+
+// Gets the picks saved for one of a user's shoots
+// Returns empty picks if the shoot has none yet
+func getPicks(tableName string, username string, shootID string, svc *dynamodb.DynamoDB) (Picks, error) {
+
+	var picks Picks
+
+	path, names := shootPath(shootID, "picks")
+
+	input := &dynamodb.GetItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"username": {
+				S: aws.String(username),
+			},
+		},
+		ProjectionExpression:     aws.String(path),
+		ExpressionAttributeNames: names,
+	}
+
+	result, err := svc.GetItem(input)
+	if err != nil {
+		return picks, fmt.Errorf("could not get picks: %v", err)
+	}
+
+	shoots, exists := result.Item["shoots"]
+	if !exists || shoots.M[shootID] == nil || shoots.M[shootID].M["picks"] == nil {
+		return picks, nil
+	}
+
+	err = dynamodbattribute.UnmarshalMap(shoots.M[shootID].M["picks"].M, &picks)
+	if err != nil {
+		return picks, fmt.Errorf("could not unmarshal picks: %v", err)
+	}
+
+	return picks, nil
+}
+
+// Adds a new shoot to a user's account
+// Fails rather than overwriting if a shoot with the same id already exists
+func addShoot(tableName string, username string, shootID string, shoot Shoot, svc *dynamodb.DynamoDB) error {
+
+	newShoot, err := dynamodbattribute.MarshalMap(shoot)
+	if err != nil {
+		return fmt.Errorf("could not marshal shoot: %v", err)
+	}
+
+	path, names := shootPath(shootID)
+
+	updateInput := &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"username": {
+				S: aws.String(username),
+			},
+		},
+		UpdateExpression:         aws.String("SET " + path + " = :newValue"),
+		ConditionExpression:      aws.String("attribute_not_exists(" + path + ")"),
+		ExpressionAttributeNames: names,
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":newValue": {
+				M: newShoot,
+			},
+		},
+	}
+
+	_, err = svc.UpdateItem(updateInput)
+	if err != nil {
+		return fmt.Errorf("could not add shoot: %v", err)
+	}
+
+	return nil
+}
+
+// Works out the new shoots map for a user whose shoots are still keyed by display name
+// Shoots keep their id if it is already a valid slug, everything else gets a generated one
+// Returns false if nothing needs to change
+func migratedShoots(shoots map[string]Shoot) (map[string]Shoot, bool) {
+
+	final := make(map[string]Shoot)
+	changed := false
+
+	// Keep the ids that are already valid first so renamed shoots can not take them
+	for id, shoot := range shoots {
+		if id == placeholderShoot || !validShootID(id) {
+			continue
+		}
+		if shoot.Name == "" {
+			shoot.Name = id
+			changed = true
+		}
+		final[id] = shoot
+	}
+
+	for id, shoot := range shoots {
+		if id == placeholderShoot {
+			final[id] = shoot
+			continue
+		}
+		if validShootID(id) {
+			continue
+		}
+		if shoot.Name == "" {
+			shoot.Name = id
+		}
+		final[newShootID(shoot.Name, final)] = shoot
+		changed = true
+	}
+
+	return final, changed
+}
+
+// Returns a user's shoots attribute as it is stored, without unmarshalling it
+func storedShoots(tableName string, username string, svc *dynamodb.DynamoDB) (*dynamodb.AttributeValue, error) {
+
+	result, err := svc.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"username": {
+				S: aws.String(username),
+			},
+		},
+		ProjectionExpression: aws.String("#shoots"),
+		ExpressionAttributeNames: map[string]*string{
+			"#shoots": aws.String("shoots"),
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	shoots, ok := result.Item["shoots"]
+	if !ok {
+		return nil, errors.New("user has no shoots")
+	}
+
+	return shoots, nil
+}
+
+// Moves shoots created before shoot ids existed over to generated ids and display names
+// Runs once, later starts see the marker it leaves in the data table and skip the scan
+// Each user is only rewritten if their shoots have not changed since they were read, so a
+// second server starting at the same time, or a request that came in meanwhile, is never overwritten
+func migrateShootIDs(tableName string, dataTable string, svc *dynamodb.DynamoDB) error {
+
+	done, err := migrationDone(dataTable, shootIDMigration, svc)
+	if err != nil || done {
+		return err
+	}
+
+	users, err := scanUsers(tableName, svc)
+	if err != nil {
+		return fmt.Errorf("could not scan users to migrate shoots: %v", err)
+	}
+
+	var failed []string
+
+	for _, user := range users {
+
+		if _, changed := migratedShoots(user.Shoots); !changed {
+			continue
+		}
+
+		// Work from the shoots exactly as they are stored now and compare against those in the condition
+		// Marshalling them again can differ in small ways, like empty lists, and would never match
+		oldShoots, err := storedShoots(tableName, user.Username, svc)
+		if err != nil {
+			log.Printf("could not read shoots for %v: %v", user.Username, err)
+			failed = append(failed, user.Username)
+			continue
+		}
+		var current map[string]Shoot
+		err = dynamodbattribute.Unmarshal(oldShoots, &current)
+		if err != nil {
+			failed = append(failed, user.Username)
+			continue
+		}
+		shoots, changed := migratedShoots(current)
+		if !changed {
+			continue
+		}
+		newShoots, err := dynamodbattribute.Marshal(shoots)
+		if err != nil {
+			failed = append(failed, user.Username)
+			continue
+		}
+
+		updateInput := &dynamodb.UpdateItemInput{
+			TableName: aws.String(tableName),
+			Key: map[string]*dynamodb.AttributeValue{
+				"username": {
+					S: aws.String(user.Username),
+				},
+			},
+			UpdateExpression:    aws.String("SET #shoots = :new"),
+			ConditionExpression: aws.String("#shoots = :old"),
+			ExpressionAttributeNames: map[string]*string{
+				"#shoots": aws.String("shoots"),
+			},
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":old": oldShoots,
+				":new": newShoots,
+			},
+		}
+
+		_, err = svc.UpdateItem(updateInput)
+		if conditionFailed(err) {
+			// Something else changed the shoots after the scan, try again with fresh data next start
+			log.Printf("shoots for %v changed while migrating, leaving them for the next start", user.Username)
+			failed = append(failed, user.Username)
+			continue
+		}
+		if err != nil {
+			log.Printf("could not migrate shoots for %v: %v", user.Username, err)
+			failed = append(failed, user.Username)
+			continue
+		}
+
+		log.Printf("migrated %v shoots for %v to generated ids", len(shoots), user.Username)
+	}
+
+	if len(failed) > 0 {
+		return errors.New("could not migrate shoots for " + strings.Join(failed, ", "))
+	}
+
+	return markMigrationDone(dataTable, shootIDMigration, svc)
+}
+
+// How the home page can order shoots
+const (
+	tileOrderDate   = "date"   // Newest first, by the shoot's date
+	tileOrderName   = "name"   // By display name
+	tileOrderCustom = "custom" // The order the user arranged them in
+)
+
+// Longest a shoot's display name can be
+const maxShootNameLength = 100
+
+// Most objects one S3 DeleteObjects call can delete
+const maxDeleteBatch = 1000
+
+// Names of the one time migrations, each leaves a marker in the data table once it has finished
+const (
+	shootIDMigration      = "shoot-ids"     // Moves shoots keyed by display name over to generated ids
+	storageIndexMigration = "storage-index" // Fills the storage index from the shoots that already exist
+)
+
+// Formats shoot dates are written in, tried in order when ordering shoots by date
+var shootDateLayouts = []string{"2006-01-02", time.RFC3339, "01/02/2006", "January 2, 2006", "Jan 2, 2006"}
+
+// Why a shoot can not be changed
+type ShootError struct {
+	Status int
+	Reason string
+}
+
+func (e *ShootError) Error() string {
+	return e.Reason
+}
+
+// Reads when a shoot took place from its date
+// Returns false if the shoot has no date or it is not in one of shootDateLayouts
+func shootTime(shoot Shoot) (time.Time, bool) {
+	for _, layout := range shootDateLayouts {
+		if taken, err := time.Parse(layout, strings.TrimSpace(shoot.Date)); err == nil {
+			return taken, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Checks a request for a new shoot and builds the shoot to store
+// Only what the request holds is copied, so picks, shares and the like can not be set when a shoot is made
+// Returns a *ShootError if the request is not valid
+func newShoot(request ShootRequest) (Shoot, error) {
+
+	request.Name = strings.TrimSpace(request.Name)
+	if request.Name == "" || len(request.Name) > maxShootNameLength {
+		return Shoot{}, &ShootError{Status: http.StatusBadRequest, Reason: fmt.Sprintf("shoot needs a name of at most %v characters", maxShootNameLength)}
+	}
+	if _, err := time.Parse(time.RFC3339, request.Deadline); request.Deadline != "" && err != nil {
+		return Shoot{}, &ShootError{Status: http.StatusBadRequest, Reason: "deadline must be an RFC 3339 timestamp"}
+	}
+	if request.MaxPicks < 0 {
+		return Shoot{}, &ShootError{Status: http.StatusBadRequest, Reason: "maxPicks can not be negative"}
+	}
+
+	return Shoot{
+		Name:      request.Name,
+		Prefix:    request.Prefix,
+		Date:      request.Date,
+		Thumbnail: request.Thumbnail,
+		Deadline:  request.Deadline,
+		MaxPicks:  request.MaxPicks,
+		Downloads: request.Downloads,
+		Files:     request.Files,
+	}, nil
+}
+
+// Returns how a user's home page orders their shoots, by date unless they chose otherwise
+func tileOrder(user User) string {
+	if user.TileOrder == "" {
+		return tileOrderDate
+	}
+	return user.TileOrder
+}
+
+// Lists a user's shoot ids in the order their home page shows them
+func orderedShootIDs(user User) []string {
+
+	position := make(map[string]int)
+	for i, id := range user.ShootOrder {
+		if _, seen := position[id]; !seen {
+			position[id] = i
+		}
+	}
+
+	ids := []string{}
+	for id := range user.Shoots {
+		if id != placeholderShoot {
+			ids = append(ids, id)
+		}
+	}
+
+	newestFirst := func(a string, b string) bool {
+		timeA, okA := shootTime(user.Shoots[a])
+		timeB, okB := shootTime(user.Shoots[b])
+		switch {
+		case okA != okB:
+			return okA // Shoots without a date go last
+		case !timeA.Equal(timeB):
+			return timeA.After(timeB)
+		default:
+			return a < b
+		}
+	}
+
+	sort.Slice(ids, func(i, j int) bool {
+		a, b := ids[i], ids[j]
+		switch user.TileOrder {
+		case tileOrderName:
+			nameA := strings.ToLower(shootDisplayName(a, user.Shoots[a]))
+			nameB := strings.ToLower(shootDisplayName(b, user.Shoots[b]))
+			if nameA != nameB {
+				return nameA < nameB
+			}
+			return a < b
+		case tileOrderCustom:
+			// Shoots made since the order was saved go after the arranged ones
+			positionA, arrangedA := position[a]
+			positionB, arrangedB := position[b]
+			switch {
+			case arrangedA && arrangedB:
+				return positionA < positionB
+			case arrangedA != arrangedB:
+				return arrangedA
+			}
+		}
+		return newestFirst(a, b)
+	})
+
+	return ids
+}
+
+// Checks a new order for a user's home page
+// Returns a *ShootError if the order is not valid
+func checkShootOrder(order ShootOrder, shoots map[string]Shoot) (ShootOrder, error) {
+
+	switch order.Order {
+	case tileOrderDate, tileOrderName:
+		return ShootOrder{Order: order.Order, IDs: []string{}}, nil
+	case tileOrderCustom:
+	default:
+		return ShootOrder{}, &ShootError{Status: http.StatusBadRequest, Reason: fmt.Sprintf("order must be one of %v, %v or %v", tileOrderDate, tileOrderName, tileOrderCustom)}
+	}
+
+	seen := make(map[string]bool)
+	for _, id := range order.IDs {
+		if _, exists := shoots[id]; !exists || id == placeholderShoot {
+			return ShootOrder{}, &ShootError{Status: http.StatusUnprocessableEntity, Reason: fmt.Sprintf("shoot %v does not exist", id)}
+		}
+		if seen[id] {
+			return ShootOrder{}, &ShootError{Status: http.StatusBadRequest, Reason: fmt.Sprintf("shoot %v is in the order more than once", id)}
+		}
+		seen[id] = true
+	}
+
+	return order, nil
+}
+
+// Saves how a user's home page orders their shoots
+func setShootOrder(tableName string, username string, order ShootOrder, svc *dynamodb.DynamoDB) error {
+
+	values := map[string]*dynamodb.AttributeValue{
+		":order": {S: aws.String(order.Order)},
+	}
+	updateExpression := "SET #tileOrder = :order REMOVE #shootOrder"
+	if len(order.IDs) > 0 {
+		ids, err := dynamodbattribute.Marshal(order.IDs)
+		if err != nil {
+			return fmt.Errorf("could not marshal shoot order: %v", err)
+		}
+		values[":ids"] = ids
+		updateExpression = "SET #tileOrder = :order, #shootOrder = :ids"
+	}
+
+	_, err := svc.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"username": {
+				S: aws.String(username),
+			},
+		},
+		UpdateExpression: aws.String(updateExpression),
+		ExpressionAttributeNames: map[string]*string{
+			"#tileOrder":  aws.String("tileOrder"),
+			"#shootOrder": aws.String("shootOrder"),
+		},
+		ExpressionAttributeValues: values,
+	})
+	if err != nil {
+		return fmt.Errorf("could not save shoot order: %v", err)
+	}
+
+	return nil
+}
+
+// Renames a shoot, changes its date or picks the photo shown on its tile
+// The shoot keeps its id, so links to it keep working after a rename
+// Photographers can also move the deadline and unlock the shoot, so a client who needs more time can be given it
+// photographer is whether the user making the change is a photographer
+// Returns the changed shoot, or a *ShootError if the update is not valid
+func updateShoot(tableName string, r *redis.Client, client *s3.S3, bucket string, username string, shootID string, shoot Shoot, update ShootUpdate, photographer bool, grace time.Duration, svc *dynamodb.DynamoDB) (Shoot, error) {
+
+	if (update.Deadline != nil || update.Locked != nil) && !photographer {
+		return Shoot{}, &ShootError{Status: http.StatusForbidden, Reason: "only photographers can change the deadline or lock"}
+	}
+
+	path, names := shootPath(shootID)
+	values := map[string]*dynamodb.AttributeValue{}
+	var sets []string
+
+	if update.Name != nil {
+		name := strings.TrimSpace(*update.Name)
+		if name == "" || len(name) > maxShootNameLength {
+			return Shoot{}, &ShootError{Status: http.StatusBadRequest, Reason: fmt.Sprintf("shoot needs a name of at most %v characters", maxShootNameLength)}
+		}
+		shoot.Name = name
+		names["#name"] = aws.String("name")
+		values[":name"] = &dynamodb.AttributeValue{S: aws.String(name)}
+		sets = append(sets, path+".#name = :name")
+	}
+
+	if update.Date != nil {
+		date := strings.TrimSpace(*update.Date)
+		if len(date) > maxShootNameLength {
+			return Shoot{}, &ShootError{Status: http.StatusBadRequest, Reason: "date is too long"}
+		}
+		shoot.Date = date
+		names["#date"] = aws.String("date")
+		values[":date"] = &dynamodb.AttributeValue{S: aws.String(date)}
+		sets = append(sets, path+".#date = :date")
+	}
+
+	if update.Cover != nil {
+		thumbnails, err := shootThumbnails(r, client, bucket, shoot)
+		if err != nil {
+			return Shoot{}, err
+		}
+		cover := ""
+		for _, thumbnail := range thumbnails {
+			if photoKey(thumbnail) == *update.Cover {
+				cover = thumbnail
+				break
+			}
+		}
+		if cover == "" {
+			return Shoot{}, &ShootError{Status: http.StatusUnprocessableEntity, Reason: "photo is not in this shoot"}
+		}
+		shoot.Thumbnail = cover
+		names["#thumbnail"] = aws.String("thumbnail")
+		values[":thumbnail"] = &dynamodb.AttributeValue{S: aws.String(cover)}
+		sets = append(sets, path+".#thumbnail = :thumbnail")
+	}
+
+	if update.Deadline != nil {
+		deadline := strings.TrimSpace(*update.Deadline)
+		if _, err := time.Parse(time.RFC3339, deadline); deadline != "" && err != nil {
+			return Shoot{}, &ShootError{Status: http.StatusBadRequest, Reason: "deadline must be an RFC 3339 timestamp"}
+		}
+		if deadline != shoot.Deadline {
+			// Reminders count down to the new deadline from the start
+			shoot.Deadline = deadline
+			shoot.RemindersSent = nil
+			names["#deadline"] = aws.String("deadline")
+			names["#remindersSent"] = aws.String("remindersSent")
+			values[":deadline"] = &dynamodb.AttributeValue{S: aws.String(deadline)}
+			values[":remindersSent"] = &dynamodb.AttributeValue{L: []*dynamodb.AttributeValue{}}
+			sets = append(sets, path+".#deadline = :deadline", path+".#remindersSent = :remindersSent")
+		}
+	}
+
+	if update.Locked != nil {
+		shoot.Locked = *update.Locked
+		// Deadlines are checked every few minutes, so unlocking without moving a passed deadline would not last
+		if !shoot.Locked && selectionsLocked(shoot, grace) {
+			return Shoot{}, &ShootError{Status: http.StatusUnprocessableEntity, Reason: "the deadline has passed, move it to unlock the shoot"}
+		}
+		names["#locked"] = aws.String("locked")
+		values[":locked"] = &dynamodb.AttributeValue{BOOL: aws.Bool(shoot.Locked)}
+		sets = append(sets, path+".#locked = :locked")
+	}
+
+	if len(sets) == 0 {
+		return Shoot{}, &ShootError{Status: http.StatusBadRequest, Reason: "nothing to change, send a name, date, cover, deadline or lock"}
+	}
+
+	_, err := svc.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"username": {
+				S: aws.String(username),
+			},
+		},
+		UpdateExpression:          aws.String("SET " + strings.Join(sets, ", ")),
+		ConditionExpression:       aws.String("attribute_exists(" + path + ")"),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+	})
+	if conditionFailed(err) {
+		return Shoot{}, &ShootError{Status: http.StatusNotFound, Reason: "shoot does not exist"}
+	}
+	if err != nil {
+		return Shoot{}, fmt.Errorf("could not update shoot: %v", err)
+	}
+
+	return shoot, nil
+}
+
+// Deletes a shoot from a user's account
+// Returns false if the user has no shoot with that id
+func removeShoot(tableName string, username string, shootID string, svc *dynamodb.DynamoDB) (bool, error) {
+
+	path, names := shootPath(shootID)
+
+	_, err := svc.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"username": {
+				S: aws.String(username),
+			},
+		},
+		UpdateExpression:         aws.String("REMOVE " + path),
+		ConditionExpression:      aws.String("attribute_exists(" + path + ")"),
+		ExpressionAttributeNames: names,
+	})
+	if conditionFailed(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("could not delete shoot: %v", err)
+	}
+
+	return true, nil
+}
+
+// Reports whether any other shoot, of any user, shows photos stored for a shoot
+// Invites can give several clients the same prefix, and deleting it would empty their galleries too
+func storageShared(users []User, username string, shootID string, shoot Shoot) bool {
+
+	prefix := strings.TrimSuffix(shoot.Prefix, "/") + "/"
+	files := make(map[string]bool)
+	for _, file := range shoot.Files {
+		files[file] = true
+	}
+
+	overlaps := func(other Shoot) bool {
+		if shoot.Prefix != "" && other.Prefix != "" {
+			otherPrefix := strings.TrimSuffix(other.Prefix, "/") + "/"
+			if strings.HasPrefix(prefix, otherPrefix) || strings.HasPrefix(otherPrefix, prefix) {
+				return true
+			}
+		}
+		for _, file := range other.Files {
+			if files[file] || (shoot.Prefix != "" && strings.HasPrefix(file, prefix)) {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, user := range users {
+		for id, other := range user.Shoots {
+			if id == placeholderShoot || (user.Username == username && id == shootID) {
+				continue
+			}
+			if overlaps(other) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Top level folders of the bucket a shoot's photos are stored under
+// Two shoots can only share photos if they share one of these, so the folders key the storage index
+func storageFolders(shoot Shoot) []string {
+
+	seen := make(map[string]bool)
+	var final []string
+
+	add := func(key string) {
+		folder, _, _ := strings.Cut(strings.TrimPrefix(key, "/"), "/")
+		if !seen[folder] {
+			seen[folder] = true
+			final = append(final, folder)
+		}
+	}
+
+	if shoot.Prefix != "" {
+		add(shoot.Prefix)
+	}
+	for _, file := range shoot.Files {
+		add(file)
+	}
+
+	return final
+}
+
+// Key of the storage index items for one top level folder
+// Each item under it has sk "user#<username>" for a user with a shoot stored in the folder
+func storageKey(folder string) string {
+	return "storage#" + folder
+}
+
+// Notes in the storage index which folders a user's shoot is stored under
+// Called before the shoot is saved, so a purge never misses it. Entries are not taken out when
+// shoots are, a user listed who no longer uses the folder only costs an extra read when purging
+func recordShootStorage(dataTable string, username string, shoot Shoot, svc *dynamodb.DynamoDB) error {
+
+	for _, folder := range storageFolders(shoot) {
+		item := dataKey(storageKey(folder), "user#"+username)
+		item["username"] = &dynamodb.AttributeValue{S: aws.String(username)}
+
+		_, err := svc.PutItem(&dynamodb.PutItemInput{
+			TableName: aws.String(dataTable),
+			Item:      item,
+		})
+		if err != nil {
+			return fmt.Errorf("could not record where shoot photos are stored: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// Returns the users the storage index lists as having shoots in the same folders as a shoot
+// Fails until indexShootStorage has finished, since before then the index can leave users out
+func storageUsers(dataTable string, shoot Shoot, svc *dynamodb.DynamoDB) ([]string, error) {
+
+	done, err := migrationDone(dataTable, storageIndexMigration, svc)
+	if err != nil {
+		return nil, err
+	}
+	if !done {
+		return nil, errors.New("the shoot storage index has not been filled yet, restart the server to retry")
+	}
+
+	var final []string
+
+	for _, folder := range storageFolders(shoot) {
+		err := svc.QueryPages(&dynamodb.QueryInput{
+			TableName:              aws.String(dataTable),
+			KeyConditionExpression: aws.String("pk = :pk"),
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":pk": {S: aws.String(storageKey(folder))},
+			},
+			ConsistentRead: aws.Bool(true),
+		}, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+			for _, item := range page.Items {
+				username := strings.TrimPrefix(aws.StringValue(item["sk"].S), "user#")
+				if !containsString(final, username) {
+					final = append(final, username)
+				}
+			}
+			return true
+		})
+		if err != nil {
+			return nil, fmt.Errorf("could not look up where shoot photos are stored: %v", err)
+		}
+	}
+
+	return final, nil
+}
+
+// Fills the storage index with the shoots that existed before it did
+// Runs once, later starts see the marker it leaves and skip the scan
+func indexShootStorage(tableName string, dataTable string, svc *dynamodb.DynamoDB) error {
+
+	done, err := migrationDone(dataTable, storageIndexMigration, svc)
+	if err != nil || done {
+		return err
+	}
+
+	users, err := scanUsers(tableName, svc)
+	if err != nil {
+		return fmt.Errorf("could not scan users to index shoot storage: %v", err)
+	}
+
+	for _, user := range users {
+		for id, shoot := range user.Shoots {
+			if id == placeholderShoot {
+				continue
+			}
+			err = recordShootStorage(dataTable, user.Username, shoot, svc)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return markMigrationDone(dataTable, storageIndexMigration, svc)
+}
+
+// Lists every object stored for a shoot: the originals along with their thumbnails and previews
+func shootObjects(client *s3.S3, bucket string, shoot Shoot) ([]string, error) {
+
+	final := []string{}
+
+	if len(shoot.Files) > 0 {
+		seen := make(map[string]bool)
+		for _, file := range shoot.Files {
+			for _, key := range []string{file, thumbnailKey(file), previewKey(thumbnailKey(file))} {
+				if !seen[key] {
+					seen[key] = true
+					final = append(final, key)
+				}
+			}
+		}
+		return final, nil
+	}
+
+	if shoot.Prefix == "" {
+		return final, nil
+	}
+
+	err := client.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(strings.TrimSuffix(shoot.Prefix, "/") + "/"),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range page.Contents {
+			final = append(final, *object.Key)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not list objects in %v: %v", shoot.Prefix, err)
+	}
+
+	return final, nil
+}
+
+// Deletes objects from the bucket, maxDeleteBatch at a time
+// Keys that do not exist are not an error, so a half finished delete can be run again
+func deleteObjects(client *s3.S3, bucket string, keys []string) error {
+
+	for start := 0; start < len(keys); start += maxDeleteBatch {
+		end := start + maxDeleteBatch
+		if end > len(keys) {
+			end = len(keys)
+		}
+
+		objects := make([]*s3.ObjectIdentifier, 0, end-start)
+		for _, key := range keys[start:end] {
+			objects = append(objects, &s3.ObjectIdentifier{Key: aws.String(key)})
+		}
+
+		output, err := client.DeleteObjects(&s3.DeleteObjectsInput{
+			Bucket: aws.String(bucket),
+			Delete: &s3.Delete{Objects: objects, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return fmt.Errorf("could not delete objects: %v", err)
+		}
+		if len(output.Errors) > 0 {
+			return fmt.Errorf("could not delete %v objects, the first was %v: %v", len(output.Errors), aws.StringValue(output.Errors[0].Key), aws.StringValue(output.Errors[0].Message))
+		}
+	}
+
+	return nil
+}
+
+// Deletes a shoot and, when purge is set, every photo stored for it
+// The shoot is removed first so nothing is served from it while its photos are deleted
+// Returns how many objects were deleted, or a *ShootError if the shoot can not be deleted
+func deleteShoot(tableName string, dataTable string, r *redis.Client, client *s3.S3, bucket string, username string, shootID string, shoot Shoot, purge bool, svc *dynamodb.DynamoDB) (int, error) {
+
+	var objects []string
+	if purge {
+		// Only the users the storage index lists can have shoots showing the same photos
+		usernames, err := storageUsers(dataTable, shoot, svc)
+		if err != nil {
+			return 0, fmt.Errorf("could not check who else uses the photos: %v", err)
+		}
+		if !containsString(usernames, username) {
+			usernames = append(usernames, username)
+		}
+
+		var users []User
+		for _, other := range usernames {
+			user, err := getUser(tableName, other, svc)
+			if errors.Is(err, errUserNotFound) {
+				continue
+			}
+			if err != nil {
+				return 0, fmt.Errorf("could not check who else uses the photos: %v", err)
+			}
+			users = append(users, user)
+		}
+
+		if storageShared(users, username, shootID, shoot) {
+			return 0, &ShootError{Status: http.StatusConflict, Reason: "another shoot shows these photos, delete the shoot without its photos instead"}
+		}
+
+		objects, err = shootObjects(client, bucket, shoot)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	removed, err := removeShoot(tableName, username, shootID, svc)
+	if err != nil {
+		return 0, err
+	}
+	if !removed {
+		return 0, &ShootError{Status: http.StatusNotFound, Reason: "shoot does not exist"}
+	}
+
+	shares := make([]string, 0, len(shoot.Shares))
+	for id := range shoot.Shares {
+		shares = append(shares, id)
+	}
+	forgetShareLinks(dataTable, shares, svc)
+	err = forgetFavorites(dataTable, username, shootID, svc)
+	if err != nil {
+		log.Printf("could not delete guest favorites on %v: %v", shootID, err)
+	}
+
+	if !purge {
+		return 0, nil
+	}
+
+	err = deleteObjects(client, bucket, objects)
+	if err != nil {
+		return 0, err
+	}
+	if shoot.Prefix != "" {
+		forgetThumbnails(r, bucket, shoot.Prefix)
+	}
+	for _, key := range objects {
+		if isThumbnail(key) {
+			forgetCaptureTime(r, bucket, key)
+		}
+	}
+
+	return len(objects), nil
+}
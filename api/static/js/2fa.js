@@ -0,0 +1,87 @@
+// Users who must set up 2FA before they can log in carry the challenge from /signin in the url
+function challengeQuery() {
+    let challenge = new URLSearchParams(window.location.search).get("challenge")
+    if (challenge === null) {
+        return ""
+    }
+    return "?challenge=" + encodeURIComponent(challenge)
+}
+
+function request(method, path, body, callback) {
+    let xhr = new XMLHttpRequest();
+    xhr.open(method, window.location.origin + path + challengeQuery());
+    xhr.setRequestHeader("X-CSRF-Token", csrfToken());
+    xhr.setRequestHeader("Accept", "application/json");
+    xhr.setRequestHeader("Content-Type", "application/json");
+
+    xhr.onreadystatechange = function () {
+        if (xhr.readyState === 4) {
+            let response = JSON.parse(xhr.responseText)
+            if (xhr.status === 200) {
+                callback(response)
+            } else if (xhr.status === 401) {
+                window.location.href = window.location.origin + "/login"
+            } else {
+                alert(response.status)
+            }
+        }
+    };
+    xhr.send(JSON.stringify(body));
+}
+
+function show(step) {
+    let steps = ["setup_step", "scan_step", "recovery_step", "disable_step"]
+    for (let i = 0; i < steps.length; i++) {
+        document.getElementById(steps[i]).style.display = steps[i] === step ? "block" : "none"
+    }
+}
+
+function startSetup() {
+    request("POST", "/2fa/setup", {}, (response) => {
+        document.getElementById("secret").innerHTML = response.secret
+        document.getElementById("qr_code").src = window.location.origin + "/2fa/qr" + challengeQuery()
+        show("scan_step")
+    })
+}
+
+function enable() {
+    request("POST", "/2fa/enable", {code: document.getElementById("code").value}, (response) => {
+        let list = document.getElementById("recovery_codes")
+        for (let i = 0; i < response.recoveryCodes.length; i++) {
+            let item = document.createElement("li")
+            item.textContent = response.recoveryCodes[i]
+            list.appendChild(item)
+        }
+        document.getElementById("message").innerHTML = "2FA is on."
+        show("recovery_step")
+    })
+}
+
+function disable() {
+    request("POST", "/2fa/disable", {code: document.getElementById("disable_code").value}, () => {
+        document.getElementById("message").innerHTML = "2FA is off."
+        show("setup_step")
+    })
+}
+
+function finish() {
+    window.location.href = window.location.origin + "/home"
+}
+
+window.addEventListener("load", function () {
+    request("GET", "/2fa/status", null, (status) => {
+        if (status.enabled && status.required) {
+            document.getElementById("message").innerHTML = "2FA is on and is required for your account. You have " + status.recoveryCodes + " recovery codes left."
+            show("")
+        } else if (status.enabled) {
+            document.getElementById("message").innerHTML = "2FA is on. You have " + status.recoveryCodes + " recovery codes left."
+            show("disable_step")
+        } else if (status.required) {
+            document.getElementById("message").innerHTML = "Your account requires 2FA. Set it up to finish logging in."
+            show("setup_step")
+        } else {
+            document.getElementById("message").innerHTML = "Protect your account with a code from an authenticator app."
+            show("setup_step")
+        }
+    })
+})
@@ -0,0 +1,104 @@
+// What each scope lets a token do
+const scopeDescriptions = {
+    "shoots:read": "Read shoots, photos, picks and comments",
+    "picks:write": "Change picks and leave comments",
+    "shoots:write": "Rename, redate and reorder shoots",
+    "shares:write": "Make and revoke share links for shoots",
+    "admin": "Create shoots, upload photos, manage users and use clients' shoots",
+}
+
+function request(method, path, body, callback) {
+    let xhr = new XMLHttpRequest();
+    xhr.open(method, window.location.origin + path);
+    xhr.setRequestHeader("X-CSRF-Token", csrfToken());
+    xhr.setRequestHeader("Accept", "application/json");
+    xhr.setRequestHeader("Content-Type", "application/json");
+
+    xhr.onreadystatechange = function () {
+        if (xhr.readyState === 4) {
+            let response = JSON.parse(xhr.responseText)
+            if (xhr.status === 200) {
+                callback(response)
+            } else if (xhr.status === 401) {
+                window.location.href = window.location.origin + "/login"
+            } else {
+                alert(response.status)
+            }
+        }
+    };
+    xhr.send(body === null ? null : JSON.stringify(body));
+}
+
+function loadTokens() {
+    request("GET", "/account/tokens", null, (response) => {
+
+        let list = document.getElementById("tokens")
+        list.innerHTML = ""
+        if (response.tokens.length === 0) {
+            let item = document.createElement("li")
+            item.textContent = "You do not have any tokens."
+            list.appendChild(item)
+        }
+        for (let i = 0; i < response.tokens.length; i++) {
+            let token = response.tokens[i]
+            let item = document.createElement("li")
+            let lastUsed = token.lastUsed === "" ? "never used" : "last used " + new Date(token.lastUsed).toLocaleString()
+            item.textContent = token.name + " (" + token.scopes.join(", ") + ", " + lastUsed + ") "
+
+            let revoke = document.createElement("button")
+            revoke.textContent = "Revoke"
+            revoke.onclick = () => revokeToken(token.id, token.name)
+            item.appendChild(revoke)
+            list.appendChild(item)
+        }
+
+        let scopes = document.getElementById("scopes")
+        if (scopes.children.length > 0) {
+            return
+        }
+        for (let i = 0; i < response.scopes.length; i++) {
+            let scope = response.scopes[i]
+            if (scope === "admin" && !response.admin) {
+                continue
+            }
+            let label = document.createElement("label")
+            let box = document.createElement("input")
+            box.type = "checkbox"
+            box.value = scope
+            box.className = "scope"
+            label.appendChild(box)
+            label.appendChild(document.createTextNode(" " + scopeDescriptions[scope]))
+            scopes.appendChild(label)
+            scopes.appendChild(document.createElement("br"))
+        }
+    })
+}
+
+function createToken() {
+    let scopes = []
+    let boxes = document.getElementsByClassName("scope")
+    for (let i = 0; i < boxes.length; i++) {
+        if (boxes[i].checked) {
+            scopes.push(boxes[i].value)
+        }
+    }
+
+    let name = document.getElementById("token_name").value
+    request("POST", "/account/tokens", {name: name, scopes: scopes}, (response) => {
+        document.getElementById("token_value").textContent = response.token
+        document.getElementById("new_token").style.display = "block"
+        document.getElementById("token_name").value = ""
+        loadTokens()
+    })
+}
+
+function revokeToken(id, name) {
+    if (!confirm("Revoke " + name + "? Scripts using it will stop working.")) {
+        return
+    }
+    request("POST", "/account/tokens/" + encodeURIComponent(id) + "/revoke", {}, () => {
+        loadTokens()
+    })
+}
+
+window.addEventListener("load", loadTokens)
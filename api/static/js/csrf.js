@@ -0,0 +1,9 @@
+// Returns the CSRF token the server put in the page
+// It has to be sent back in the X-CSRF-Token header on every request that changes something
+function csrfToken() {
+    let meta = document.querySelector('meta[name="csrf-token"]')
+    if (meta === null) {
+        return ""
+    }
+    return meta.content
+}
@@ -0,0 +1,35 @@
+function requestReset() {
+    let request = {};
+    request.login = document.getElementById("login").value;
+
+    let xhr = new XMLHttpRequest();
+    xhr.open("POST", window.location.origin + "/forgot");
+    xhr.setRequestHeader("X-CSRF-Token", csrfToken());
+    xhr.setRequestHeader("Accept", "application/json");
+    xhr.setRequestHeader("Content-Type", "application/json");
+
+    xhr.onreadystatechange = function () {
+        if (xhr.readyState === 4) {
+            if (xhr.status === 200) {
+                document.getElementById("message").innerHTML = "If that account exists, a reset link has been sent to its email address."
+            } else if (xhr.status === 429) {
+                alert(JSON.parse(xhr.responseText).status)
+            } else {
+                alert("Something went wrong")
+            }
+        }
+    };
+    xhr.send(JSON.stringify(request));
+}
+
+window.addEventListener("load", function () {
+    if (window.location.search.includes("expired=true")) {
+        document.getElementById("message").innerHTML = "That reset link is invalid or has expired. Request a new one below."
+    }
+
+    document.addEventListener("keypress", function (event) {
+        if (event.key === "Enter") {
+            requestReset(); // Call the reset function when Enter is pressed
+        }
+    });
+})
@@ -3,21 +3,9 @@ let picks = {
     picks: []
 }
 
-function setCookie(cookieName, cookieValue, expirationDays, domain) {
-    const expirationDate = new Date();
-    expirationDate.setDate(expirationDate.getDate() + expirationDays);
-    const secure = true
-
-    const cookieString = `${encodeURIComponent(cookieName)}=${encodeURIComponent(
-        cookieValue
-    )}; expires=${expirationDate.toUTCString()}; path=/;domain=${domain};secure=${secure}`;
-
-    document.cookie = cookieString;
-}
-
-function savePicksToCookie(value, domain, callback){
-    //createSecureCookie("picks",value,"7")
-    setCookie("picks",JSON.stringify(value),7,domain)
+// Keeps a copy of the picks in the browser between saves
+function savePicksLocally(value, callback){
+    localStorage.setItem("picks:" + window.location.pathname.split("/")[2], JSON.stringify(value))
     callback()
 }
 
@@ -30,7 +18,7 @@ function get_picks(url, callback){
     xhr.onreadystatechange = function () {
         if (xhr.readyState === 4) {
             if (xhr.status === 200) {
-                callback()
+                callback(xhr.responseText)
                 return xhr.responseText
             } else {
                 console.log("Something went wrong getting picks from the server")
@@ -42,43 +30,112 @@ function get_picks(url, callback){
     xhr.send();
 }
 
+// Formats the time left until the selection deadline
+// deadline is an RFC 3339 timestamp, locked is "true" once the server has locked the selections
+function countdownText(deadline, locked) {
+    if (locked === "true") {
+        return "Selections Locked"
+    }
+
+    let remaining = new Date(deadline) - new Date()
+    if (remaining <= 0) {
+        return "Deadline Passed"
+    }
+
+    let days = Math.floor(remaining / 86400000)
+    let hours = Math.floor((remaining % 86400000) / 3600000)
+    let minutes = Math.floor((remaining % 3600000) / 60000)
+
+    if (days > 0) {
+        return days + "d " + hours + "h Left"
+    }
+    return hours + "h " + minutes + "m Left"
+}
+
+function updateCountdown() {
+    let countdown = document.getElementById("countdown")
+    if (countdown.dataset.deadline === "" && countdown.dataset.locked !== "true") {
+        countdown.style.display = "none"
+        return
+    }
+    countdown.innerHTML = countdownText(countdown.dataset.deadline, countdown.dataset.locked)
+}
+
+function selectionsLocked() {
+    return document.getElementById("countdown").dataset.locked === "true"
+}
+
+// Draws the selected outline on a photo
+function outlineImage(img) {
+    let borderPX = Math.floor(img.width * .0125)
+    img.style = "outline: " + borderPX + "px solid #ff6600;outline-offset: -" + borderPX + "px;"
+}
+
 function markImage(id) {
+    if (selectionsLocked()) {
+        alert("The deadline for this shoot has passed, your selections can no longer be changed")
+        return
+    }
+
     let img = document.getElementById(id)
     if (img.alt === "1") {
         img.alt = "0";
         img.childNodes[0].style = null
         window.picks.count--
         window.picks.picks = window.picks.picks.filter(item => item !== id) // Removes the picture from picks list
-        savePicksToCookie(window.picks,"."+window.location.hostname,() => {
+        savePicksLocally(window.picks,() => {
             document.getElementById("counter").innerHTML = window.picks.count + " Items Selected";
         });
 
     } else {
         img.alt = "1"
-        let borderPX = Math.floor(img.childNodes[0].width * .0125)
-        img.childNodes[0].style = "outline: " + borderPX + "px solid #ff6600;outline-offset: -" + borderPX + "px;"
+        outlineImage(img.childNodes[0])
         window.picks.count++
         window.picks.picks.push(id) // Adds a picture to the list
-        savePicksToCookie(window.picks,"."+window.location.hostname,()=> {
+        savePicksLocally(window.picks,()=> {
             document.getElementById("counter").innerHTML = window.picks.count + " Items Selected";
         });
     }
     document.getElementById("save_status").innerHTML = ""
 }
 
-function save() {
+// callback is optional and is called once the picks have been saved
+function save(callback) {
+
+    if (selectionsLocked()) {
+        return
+    }
 
-    let picks = getCookie("picks")
+    let picks = JSON.stringify(window.picks)
 
     let xhr = new XMLHttpRequest();
-    xhr.open("POST", window.location.href + "/savePicks");
+    xhr.open("POST", window.location.pathname + "/savePicks");
+    xhr.setRequestHeader("X-CSRF-Token", csrfToken());
     xhr.setRequestHeader("Accept", "application/json");
     xhr.setRequestHeader("Content-Type", "application/json");
 
     xhr.onreadystatechange = function () {
         if (xhr.readyState === 4) {
             if (xhr.status === 200 || xhr.status === 0) {
+                // The server works out the count itself, keep ours in line with it
+                let response = JSON.parse(xhr.responseText || "{}")
+                if (response.picks) {
+                    window.picks = response.picks
+                    document.getElementById("counter").innerHTML = window.picks.count + " Items Selected"
+                }
                 document.getElementById("save_status").innerHTML = "Saved!"
+                if (callback) {
+                    callback()
+                }
+            } else if (xhr.status === 403) {
+                alert("The deadline for this shoot has passed, your selections can no longer be changed")
+            } else if (xhr.status === 422) {
+                let response = JSON.parse(xhr.responseText)
+                if (response.rejected) {
+                    alert("Some of your selections are no longer in this shoot: " + response.rejected.map(pick => pick.key).join(", "))
+                } else {
+                    alert("You can select up to " + response.limit + " photos from this shoot")
+                }
             } else {
                 alert("Something went wrong saving your selections")
                 alert(xhr.status)
@@ -89,43 +146,52 @@ function save() {
 
 }
 
-function getCookie(cookieName) {
-    const name = cookieName + "=";
-    const decodedCookie = decodeURIComponent(document.cookie);
-    const cookieArray = decodedCookie.split(';');
+// Saves the picks then lets the photographer know the client is done choosing
+function submitPicks() {
 
-    for (let i = 0; i < cookieArray.length; i++) {
-        let cookie = cookieArray[i].trim();
-        if (cookie.indexOf(name) === 0) {
-            return cookie.substring(name.length, cookie.length);
-        }
+    if (!confirm("Submit your " + window.picks.count + " selections to the photographer?")) {
+        return
     }
 
-    // If the cookie doesn't exist, return null
-    return null;
+    save(() => {
+        let url = window.location.href;
+        url = url.split("/");
+        url[url.length - 1] = "submit"
+
+        let xhr = new XMLHttpRequest();
+        xhr.open("POST", url.join("/"));
+        xhr.setRequestHeader("X-CSRF-Token", csrfToken());
+        xhr.setRequestHeader("Accept", "application/json");
+
+        xhr.onreadystatechange = function () {
+            if (xhr.readyState === 4) {
+                if (xhr.status === 200) {
+                    document.getElementById("save_status").innerHTML = "Submitted!"
+                } else {
+                    alert("Something went wrong submitting your selections")
+                }
+            }
+        };
+        xhr.send();
+    })
 }
 
-
 function loadSelected(){
 
-    // Update the picks cookie
-    let url = window.location.href;
-    url = url.split("/");
-    url[5] = String("updatePicksCookie")
-    url = url.join("/")
-    get_picks(url,() => {
-
-        // Set picks to cookie value
-        let picks = getCookie("picks")
-        picks = JSON.parse(picks)
-        if (picks.count === 0){
+    // Get the saved picks
+    let shoot = window.location.pathname.split("/")[2]
+    get_picks("/shoot/" + shoot + "/getPicks",(response) => {
+
+        // Set picks to the value from the server
+        let picks = JSON.parse(response)
+        if (picks.count === 0 || picks.picks === null){
             picks = {
                 count: 0,
                 picks: []
             }
         }
         window.picks = picks
-        savePicksToCookie(window.picks, "."+window.location.hostname,()=>{
+        savePicksLocally(window.picks,()=>{
 
             document.getElementById("counter").innerHTML = window.picks.count + " Items Selected"
 
@@ -135,8 +201,7 @@ function loadSelected(){
                     let id = window.picks.picks[i]
                     let img = document.getElementById(id)
                     img.alt = "1"
-                    let borderPX = Math.floor(img.childNodes[0].width * .0125)
-                    img.childNodes[0].style = "outline: " + borderPX + "px solid #ff6600;outline-offset: -" + borderPX + "px;"
+                    outlineImage(img.childNodes[0])
                 } catch {}
             }
         });
@@ -149,11 +214,14 @@ function loadingStuff() {
 
     return new Promise((resolve,reject) => {
         loadSelected() // Mark the previously selected images
+        loadRatings()
+        loadGuestFavorites()
 
-        let url = window.location.href;
+        updatePhotoCount()
+        watchGalleryEnd()
 
-        url = url.split("/");
-        document.getElementById("page_num").innerHTML = "Page " + String(parseInt(url[url.length - 1]) + 1)
+        updateCountdown()
+        setInterval(updateCountdown, 60000)
         resolve();
         reject(new Error("Something failed"));
     });
@@ -177,21 +245,127 @@ window.addEventListener("load", function () {
 
 });
 
-function nextPage() {
-    save()
-    let url = window.location.href;
-    url = url.split("/");
-    url[url.length - 1] = String(parseInt(url[url.length - 1]) + 1)
-    window.location.href = url.join("/")
+// Position in the view of the first photo in the gallery
+// Galleries opened on a later page start part way through the view
+function galleryOffset() {
+    return parseInt(document.getElementById("gallery").dataset.offset) || 0
+}
+
+// Shows how far through the shoot's photos the gallery has loaded
+function updatePhotoCount() {
+    let pageNum = document.getElementById("page_num")
+    let shown = galleryOffset() + document.getElementById("gallery").children.length
+    pageNum.innerHTML = shown + " of " + pageNum.dataset.total + " Photos"
+}
+
+// Adds a photo from a batch to the end of the gallery
+function addPhoto(gallery, photo) {
+    let anchor = document.createElement("a")
+    anchor.id = photo.key
+    anchor.alt = "0"
+    anchor.onclick = function () { markImage(this.id) }
+
+    anchor.dataset.preview = photo.renditions.preview
+
+    let img = document.createElement("img")
+    img.loading = "lazy"
+    img.src = photo.renditions.thumbnail
+    anchor.appendChild(img)
+
+    let expand = document.createElement("span")
+    expand.className = "expand"
+    expand.innerHTML = "&#x2922;"
+    expand.onclick = function (event) { openLightbox(event, anchor.id) }
+    anchor.appendChild(expand)
+
+    let compare = document.createElement("span")
+    compare.className = "compare"
+    compare.innerHTML = "&#x29C9;"
+    compare.onclick = function (event) { toggleCompare(event, anchor.id) }
+    anchor.appendChild(compare)
+    if (comparing.includes(photo.key)) {
+        anchor.classList.add("comparing")
+    }
+    gallery.appendChild(anchor)
+    showRating(anchor)
+    showGuestFavorites(anchor)
+
+    // Unsaved picks are only known here, so they win over what the server says
+    if (window.picks.picks.includes(photo.key)) {
+        anchor.alt = "1"
+        img.addEventListener("load", () => outlineImage(img))
+    }
+}
+
+let loadingBatch = false
+
+// Gets the next batch of photos and adds it to the gallery
+function loadMore() {
+    let gallery = document.getElementById("gallery")
+    let cursor = gallery.dataset.cursor
+    if (loadingBatch || !cursor) {
+        return
+    }
+    loadingBatch = true
+
+    let shoot = window.location.pathname.split("/")[2]
+    let xhr = new XMLHttpRequest();
+    // Batches carry on with the same filter as the page
+    let query = new URLSearchParams(window.location.search)
+    query.set("cursor", cursor)
+    xhr.open("GET", "/shoot/" + shoot + "/photos?" + query.toString());
+    xhr.setRequestHeader("Accept", "application/json");
+
+    xhr.onreadystatechange = function () {
+        if (xhr.readyState !== 4) {
+            return
+        }
+        loadingBatch = false
+        if (xhr.status !== 200) {
+            console.log("Something went wrong loading more photos: " + xhr.status)
+            return
+        }
+
+        let batch = JSON.parse(xhr.responseText)
+        batch.photos.forEach(photo => addPhoto(gallery, photo))
+        gallery.dataset.cursor = batch.nextCursor
+        document.getElementById("page_num").dataset.total = batch.total
+        updatePhotoCount()
+
+        // Observing again checks straight away, so short batches keep loading until the screen is full
+        let end = document.getElementById("gallery-end")
+        galleryObserver.unobserve(end)
+        galleryObserver.observe(end)
+    };
+    xhr.send();
+}
+
+let galleryObserver = null
+
+// Loads more photos as the end of the gallery gets close to scrolling into view
+function watchGalleryEnd() {
+    galleryObserver = new IntersectionObserver((entries) => {
+        if (entries.some(entry => entry.isIntersecting)) {
+            loadMore()
+        }
+    }, {rootMargin: "1000px"})
+    galleryObserver.observe(document.getElementById("gallery-end"))
 }
 
+// Goes back to the page before the one this gallery started on, saving first so no picks are lost
 function previousPage() {
-    save()
-    let url = window.location.href;
-    url = url.split("/");
-    if ((parseInt(url[url.length - 1]) - 1) >= 0) {
-        url[url.length - 1] = String(parseInt(url[url.length - 1]) - 1)
-        window.location.href = url.join("/")
+    let goBack = () => {
+        let url = window.location.pathname;
+        url = url.split("/");
+        if ((parseInt(url[url.length - 1]) - 1) >= 0) {
+            url[url.length - 1] = String(parseInt(url[url.length - 1]) - 1)
+            window.location.href = url.join("/") + window.location.search // Keeps any filter
+        }
+    }
+    if (selectionsLocked()) {
+        goBack()
+    } else {
+        save(goBack)
     }
 }
 
@@ -209,4 +383,551 @@ function goHome(){
     newUrl.push("home")
 
     window.location.href = newUrl.join("/")
-}
\ No newline at end of file
+}
+
+// Star ratings and color labels by photo key. Example: {"IMG_0001": {"rating": 4, "label": "Green"}}
+let ratings = {}
+
+// Lightroom's shortcuts for its color labels
+const labelKeys = {"6": "Red", "7": "Yellow", "8": "Green", "9": "Blue"}
+
+// Gets the saved ratings and shows them on the photos that have loaded
+// The page can come from the browser cache, so ratings are always asked for fresh like the picks are
+function loadRatings() {
+    let xhr = new XMLHttpRequest();
+    xhr.open("GET", "/shoot/" + window.location.pathname.split("/")[2] + "/ratings");
+    xhr.setRequestHeader("Accept", "application/json");
+
+    xhr.onreadystatechange = function () {
+        if (xhr.readyState === 4 && xhr.status === 200) {
+            ratings = JSON.parse(xhr.responseText)
+            Array.from(document.getElementById("gallery").children).forEach(showRating)
+        }
+    };
+    xhr.send();
+}
+
+// Draws a photo's stars and label on its thumbnail
+function showRating(anchor) {
+    let badge = anchor.querySelector(".rating")
+    if (!badge) {
+        badge = document.createElement("span")
+        badge.className = "rating"
+        anchor.appendChild(badge)
+    }
+
+    let rating = ratings[anchor.id] || {rating: 0}
+    badge.innerHTML = "&#x2605;".repeat(rating.rating)
+    badge.dataset.label = rating.label || ""
+    badge.hidden = rating.rating === 0 && !rating.label
+}
+
+// Saves a photo's rating and label. rating is 0 to 5 and label is one of Lightroom's labels, or empty to clear it
+function setRating(id, rating, label) {
+    let xhr = new XMLHttpRequest();
+    xhr.open("POST", "/shoot/" + window.location.pathname.split("/")[2] + "/rate");
+    xhr.setRequestHeader("X-CSRF-Token", csrfToken());
+    xhr.setRequestHeader("Accept", "application/json");
+    xhr.setRequestHeader("Content-Type", "application/json");
+
+    xhr.onreadystatechange = function () {
+        if (xhr.readyState !== 4) {
+            return
+        }
+        if (xhr.status === 200) {
+            let saved = JSON.parse(xhr.responseText).rating
+            if (saved.rating === 0 && !saved.label) {
+                delete ratings[id]
+            } else {
+                ratings[id] = saved
+            }
+            showRating(document.getElementById(id))
+            if (lightboxIndex >= 0) {
+                updateLightbox()
+            }
+        } else if (xhr.status === 403) {
+            alert("The deadline for this shoot has passed, your ratings can no longer be changed")
+        } else {
+            alert("Something went wrong saving your rating")
+        }
+    };
+    xhr.send(JSON.stringify({key: id, rating: rating, label: label}));
+}
+
+// Changes how the gallery is sorted or filtered and starts it again from the top
+// name is one of the gallery's query parameters, an empty value goes back to the default
+// The view is kept in the url so the same view can be bookmarked or shared
+function setView(name, value) {
+    let go = () => {
+        let query = new URLSearchParams(window.location.search)
+        if (value === "") {
+            query.delete(name)
+        } else {
+            query.set(name, value)
+        }
+        let search = query.toString()
+        window.location.href = "/shoot/" + window.location.pathname.split("/")[2] + "/0" + (search === "" ? "" : "?" + search)
+    }
+    if (selectionsLocked()) {
+        go()
+    } else {
+        save(go)
+    }
+}
+
+// Position in the gallery of the photo the lightbox is showing, -1 when it is closed
+let lightboxIndex = -1
+
+// Photos that have not had a preview made yet fall back to their thumbnail
+function previewUrl(anchor) {
+    return anchor.dataset.preview || anchor.childNodes[0].src
+}
+
+function openLightbox(event, id) {
+    event.stopPropagation() // Opening the lightbox should not also pick the photo
+    let photos = Array.from(document.getElementById("gallery").children)
+    document.getElementById("lightbox").hidden = false
+    showLightboxPhoto(photos.findIndex(anchor => anchor.id === id))
+}
+
+function closeLightbox() {
+    document.getElementById("lightbox").hidden = true
+    lightboxIndex = -1
+}
+
+function showLightboxPhoto(index) {
+    let photos = document.getElementById("gallery").children
+    if (index < 0 || index >= photos.length) {
+        return
+    }
+    lightboxIndex = index
+
+    let anchor = photos[index]
+    let img = document.getElementById("lightbox-image")
+    img.onerror = () => {
+        img.onerror = null
+        img.src = anchor.childNodes[0].src
+    }
+    img.src = previewUrl(anchor)
+    updateLightbox()
+
+    // Keep batches coming so browsing with the arrow keys does not stop at the end of what has loaded
+    if (index >= photos.length - 3) {
+        loadMore()
+    }
+    prefetchPreview(index - 1)
+    prefetchPreview(index + 1)
+}
+
+// Makes the photo in the lightbox the one shown on the shoot's tile on the home page
+function setCover() {
+    let anchor = document.getElementById("gallery").children[lightboxIndex]
+    let shoot = window.location.pathname.split("/")[2]
+    let label = document.getElementById("lightbox-cover")
+    let xhr = new XMLHttpRequest();
+    xhr.open("POST", "/shoot/" + shoot + "/update");
+    xhr.setRequestHeader("Content-Type", "application/json");
+    xhr.setRequestHeader("X-CSRF-Token", csrfToken());
+    xhr.setRequestHeader("Accept", "application/json");
+
+    xhr.onreadystatechange = function () {
+        if (xhr.readyState !== 4) {
+            return
+        }
+        let response = {}
+        try {
+            response = JSON.parse(xhr.responseText)
+        } catch (e) {}
+        if (xhr.status !== 200) {
+            alert(response.status || "Something went wrong setting the cover")
+            return
+        }
+        label.textContent = "Cover"
+    };
+    xhr.send(JSON.stringify({cover: anchor.id}));
+}
+
+// Loads a neighbour's preview into the browser cache so moving to it is instant
+function prefetchPreview(index) {
+    let photos = document.getElementById("gallery").children
+    if (index >= 0 && index < photos.length) {
+        new Image().src = previewUrl(photos[index])
+    }
+}
+
+// Shows where the photo is in the gallery, whether it is picked and its rating
+function updateLightbox() {
+    let anchor = document.getElementById("gallery").children[lightboxIndex]
+    let rating = ratings[anchor.id] || {rating: 0}
+    document.getElementById("lightbox-position").innerHTML = (galleryOffset() + lightboxIndex + 1) + " of " + document.getElementById("page_num").dataset.total
+    document.getElementById("lightbox-pick").innerHTML = anchor.alt === "1" ? "Picked" : "Not Picked"
+    document.getElementById("lightbox-rating").innerHTML = "&#x2605;".repeat(rating.rating) + "&#x2606;".repeat(5 - rating.rating)
+    document.getElementById("lightbox-label").innerHTML = rating.label || ""
+    document.getElementById("lightbox-label").dataset.label = rating.label || ""
+    document.getElementById("lightbox-cover").textContent = "Set as Cover"
+}
+
+// Keyboard culling while the lightbox is open
+// Picks go through markImage so they are saved the same way as clicking a photo in the gallery
+document.addEventListener("keydown", (event) => {
+    if (lightboxIndex < 0) {
+        return
+    }
+
+    let anchor = document.getElementById("gallery").children[lightboxIndex]
+    switch (event.key) {
+        case "ArrowRight":
+            showLightboxPhoto(lightboxIndex + 1)
+            break
+        case "ArrowLeft":
+            showLightboxPhoto(lightboxIndex - 1)
+            break
+        case "Escape":
+            closeLightbox()
+            return
+        case "p":
+        case "P":
+            if (anchor.alt !== "1") {
+                markImage(anchor.id)
+            }
+            break
+        case "x":
+        case "X":
+            if (anchor.alt === "1") {
+                markImage(anchor.id)
+            }
+            break
+        case "c":
+        case "C":
+            toggleCompare(event, anchor.id)
+            break
+        case "s":
+        case "S":
+            setView("stack", anchor.id)
+            break
+        default:
+            if (event.key.length !== 1 || event.key < "0" || event.key > "9") {
+                return
+            }
+            let rating = ratings[anchor.id] || {rating: 0, label: ""}
+            if (labelKeys[event.key]) {
+                // Pressing a label's key again takes the label off, like in Lightroom
+                let label = rating.label === labelKeys[event.key] ? "" : labelKeys[event.key]
+                setRating(anchor.id, rating.rating, label)
+            } else {
+                setRating(anchor.id, parseInt(event.key), rating.label || "")
+            }
+    }
+
+    event.preventDefault()
+    updateLightbox()
+})
+
+
+// Keys of the photos chosen to compare, in the order they were chosen
+let comparing = []
+
+// Most photos the compare view shows side by side
+const maxCompare = 4
+
+// Adds a photo to the ones to compare, or takes it off if it is already there
+function toggleCompare(event, id) {
+    event.stopPropagation() // Choosing a photo to compare should not also pick it
+
+    let anchor = document.getElementById(id)
+    if (comparing.includes(id)) {
+        comparing = comparing.filter(key => key !== id)
+        anchor.classList.remove("comparing")
+    } else if (comparing.length >= maxCompare) {
+        alert("You can compare up to " + maxCompare + " photos at a time")
+        return
+    } else {
+        comparing.push(id)
+        anchor.classList.add("comparing")
+    }
+    updateCompareButton()
+}
+
+// The compare button only shows once there are at least two photos to compare
+function updateCompareButton() {
+    let button = document.getElementById("compare_button")
+    button.innerHTML = "Compare " + comparing.length
+    button.hidden = comparing.length < 2
+}
+
+// How far the compare view is zoomed in and panned, shared by every photo so they stay lined up
+let compareView = {scale: 1, x: 0, y: 0}
+
+function applyCompareView() {
+    document.querySelectorAll("#compare-panes img").forEach(img => {
+        img.style.transform = "translate(" + compareView.x + "px, " + compareView.y + "px) scale(" + compareView.scale + ")"
+    })
+}
+
+// Shows the chosen photos side by side at preview size
+function openCompare() {
+    if (comparing.length < 2) {
+        return
+    }
+
+    let panes = document.getElementById("compare-panes")
+    panes.innerHTML = ""
+    panes.dataset.count = comparing.length
+
+    comparing.forEach(id => {
+        let anchor = document.getElementById(id)
+
+        let pane = document.createElement("div")
+        pane.className = "compare-pane"
+
+        let img = document.createElement("img")
+        img.draggable = false
+        img.onerror = () => {
+            img.onerror = null
+            img.src = anchor.childNodes[0].src
+        }
+        img.src = previewUrl(anchor)
+        pane.appendChild(img)
+
+        let winner = document.createElement("a")
+        winner.className = "compare-winner"
+        winner.innerHTML = anchor.alt === "1" ? "Picked &#x2713; Keep Only This One" : "Pick This One"
+        winner.onclick = () => pickWinner(id)
+        pane.appendChild(winner)
+
+        panes.appendChild(pane)
+    })
+
+    compareView = {scale: 1, x: 0, y: 0}
+    applyCompareView()
+    document.getElementById("compare").hidden = false
+}
+
+function closeCompare() {
+    document.getElementById("compare").hidden = true
+    document.getElementById("compare-panes").innerHTML = ""
+}
+
+// Picks the winner and drops the other photos being compared from the picks, then saves
+function pickWinner(id) {
+    if (selectionsLocked()) {
+        alert("The deadline for this shoot has passed, your selections can no longer be changed")
+        return
+    }
+
+    // Unpick the others first, so a client at their pick limit can still swap one photo for another
+    comparing.forEach(key => {
+        let anchor = document.getElementById(key)
+        if (key !== id && anchor.alt === "1") {
+            markImage(key)
+        }
+        anchor.classList.remove("comparing")
+    })
+    if (document.getElementById(id).alt !== "1") {
+        markImage(id)
+    }
+    comparing = []
+    updateCompareButton()
+
+    closeCompare()
+    save()
+}
+
+// Zooming with the wheel keeps the point under the pointer still, in every pane at once
+function zoomCompare(event, pane) {
+    event.preventDefault()
+
+    pane = pane.getBoundingClientRect()
+    let offsetX = event.clientX - (pane.left + pane.width / 2)
+    let offsetY = event.clientY - (pane.top + pane.height / 2)
+
+    let scale = Math.min(8, Math.max(1, compareView.scale * (event.deltaY < 0 ? 1.2 : 1 / 1.2)))
+    let ratio = scale / compareView.scale
+    compareView.x = offsetX - (offsetX - compareView.x) * ratio
+    compareView.y = offsetY - (offsetY - compareView.y) * ratio
+    compareView.scale = scale
+    if (scale === 1) {
+        compareView.x = 0
+        compareView.y = 0
+    }
+    applyCompareView()
+}
+
+let comparePan = null
+
+window.addEventListener("load", function () {
+    let panes = document.getElementById("compare-panes")
+
+    panes.addEventListener("wheel", (event) => {
+        let pane = event.target.closest(".compare-pane")
+        if (pane) {
+            zoomCompare(event, pane)
+        }
+    }, {passive: false})
+
+    panes.addEventListener("pointerdown", (event) => {
+        if (event.target.tagName === "IMG") {
+            comparePan = {x: event.clientX, y: event.clientY}
+            panes.setPointerCapture(event.pointerId)
+        }
+    })
+    panes.addEventListener("pointermove", (event) => {
+        if (!comparePan) {
+            return
+        }
+        compareView.x += event.clientX - comparePan.x
+        compareView.y += event.clientY - comparePan.y
+        comparePan = {x: event.clientX, y: event.clientY}
+        applyCompareView()
+    })
+    panes.addEventListener("pointerup", () => { comparePan = null })
+    panes.addEventListener("pointercancel", () => { comparePan = null })
+
+    panes.addEventListener("dblclick", () => {
+        compareView = {scale: 1, x: 0, y: 0}
+        applyCompareView()
+    })
+})
+
+document.addEventListener("keydown", (event) => {
+    if (event.key === "Escape" && !document.getElementById("compare").hidden) {
+        closeCompare()
+    }
+})
+
+// How many guests on share links favorited each photo, by photo key
+let guestFavorites = {}
+
+function loadGuestFavorites() {
+    let shoot = window.location.pathname.split("/")[2]
+    let xhr = new XMLHttpRequest();
+    xhr.open("GET", "/shoot/" + shoot + "/favorites");
+    xhr.setRequestHeader("Accept", "application/json");
+
+    xhr.onreadystatechange = function () {
+        if (xhr.readyState !== 4 || xhr.status !== 200) {
+            return
+        }
+        guestFavorites = {}
+        JSON.parse(xhr.responseText).photos.forEach(photo => guestFavorites[photo.key] = photo)
+        Array.from(document.getElementById("gallery").children).forEach(showGuestFavorites)
+    };
+    xhr.send();
+}
+
+// Shows how many guests favorited a photo, with their names when hovered
+function showGuestFavorites(anchor) {
+    let favorite = guestFavorites[anchor.id]
+    let badge = anchor.querySelector(".favorites")
+    if (favorite === undefined) {
+        if (badge !== null) {
+            badge.remove()
+        }
+        return
+    }
+    if (badge === null) {
+        badge = document.createElement("span")
+        badge.className = "favorites"
+        anchor.appendChild(badge)
+    }
+    badge.innerHTML = "&#x2665; " + favorite.count
+    badge.title = favorite.guests.join(", ")
+}
+
+// Shows the share links on the shoot and the form for making one
+function openShares() {
+    document.getElementById("shares").hidden = false
+    loadShares()
+}
+
+function closeShares() {
+    document.getElementById("shares").hidden = true
+    document.getElementById("share_created").hidden = true
+}
+
+// Sends a request about the shoot's share links and calls back with the response
+function shareRequest(method, path, body, callback) {
+    let shoot = window.location.pathname.split("/")[2]
+    let xhr = new XMLHttpRequest();
+    xhr.open(method, "/shoot/" + shoot + "/shares" + path);
+    xhr.setRequestHeader("Content-Type", "application/json");
+    xhr.setRequestHeader("X-CSRF-Token", csrfToken());
+    xhr.setRequestHeader("Accept", "application/json");
+
+    xhr.onreadystatechange = function () {
+        if (xhr.readyState !== 4) {
+            return
+        }
+        let response = {}
+        try {
+            response = JSON.parse(xhr.responseText)
+        } catch (e) {}
+        if (xhr.status !== 200) {
+            alert(response.status || "Something went wrong with the share links")
+            return
+        }
+        callback(response)
+    };
+    xhr.send(body === null ? null : JSON.stringify(body));
+}
+
+function loadShares() {
+    shareRequest("GET", "", null, (response) => {
+        let list = document.getElementById("share_list")
+        list.innerHTML = ""
+        response.shares.forEach(share => {
+            let row = list.insertRow()
+            row.insertCell().textContent = share.name
+            let details = share.permissions.join(", ")
+            if (share.password) {
+                details += ", password"
+            }
+            if (share.expires) {
+                details += ", expires " + new Date(share.expires).toLocaleDateString()
+            }
+            row.insertCell().textContent = details
+            row.insertCell().textContent = share.views + (share.views === 1 ? " view" : " views")
+            let revoke = document.createElement("a")
+            revoke.textContent = "Revoke"
+            revoke.onclick = () => revokeShare(share.id, share.name)
+            row.insertCell().appendChild(revoke)
+        })
+    })
+}
+
+function createShare() {
+    let permissions = []
+    if (document.getElementById("share_favorite").checked) {
+        permissions.push("favorite")
+    }
+    if (document.getElementById("share_download").checked) {
+        permissions.push("download")
+    }
+
+    // Links stop working at the end of the day they expire on
+    let expires = document.getElementById("share_expires").value
+    if (expires) {
+        expires = new Date(expires + "T23:59:59").toISOString().split(".")[0] + "Z"
+    }
+
+    let body = {
+        name: document.getElementById("share_name").value,
+        permissions: permissions,
+        password: document.getElementById("share_password").value,
+        expires: expires,
+    }
+    shareRequest("POST", "", body, (response) => {
+        document.getElementById("share_url").value = response.share.url
+        document.getElementById("share_created").hidden = false
+        document.getElementById("share_name").value = ""
+        document.getElementById("share_password").value = ""
+        loadShares()
+    })
+}
+
+function revokeShare(id, name) {
+    if (!confirm("Revoke the link for " + name + "? It will stop working straight away.")) {
+        return
+    }
+    shareRequest("POST", "/" + encodeURIComponent(id) + "/revoke", null, () => loadShares())
+}
@@ -1,25 +1,161 @@
+// Opens the gallery for the tile that was clicked
+// Tiles hold the shoot's id in data-id, the name shown on the tile is only for display
 function goToShoot(passedDiv){
 
+    window.location.href = "/shoot/" + encodeURIComponent(passedDiv.dataset.id) + "/0"
 
-    let shootName = passedDiv.querySelector('#name');
-    shootName = shootName.innerHTML;
+}
+
+// Formats the time left until a shoot's selection deadline
+// deadline is an RFC 3339 timestamp, locked is "true" once the server has locked the selections
+function countdownText(deadline, locked) {
+    if (locked === "true") {
+        return "Selections Locked"
+    }
 
-    let url = window.location.href;
-    url = url.split("/");
+    let remaining = new Date(deadline) - new Date()
+    if (remaining <= 0) {
+        return "Deadline Passed"
+    }
 
-    url[3] = "shoot"
-    url[4] = shootName
-    url[5] = "0"
+    let days = Math.floor(remaining / 86400000)
+    let hours = Math.floor((remaining % 86400000) / 3600000)
+    let minutes = Math.floor((remaining % 3600000) / 60000)
 
-    console.log(url)
-    window.location.href = url.join("/")
+    if (days > 0) {
+        return days + "d " + hours + "h left to choose"
+    }
+    return hours + "h " + minutes + "m left to choose"
+}
 
+function updateCountdowns() {
+    let countdowns = document.getElementsByClassName("countdown")
+    for (let i = 0; i < countdowns.length; i++) {
+        if (countdowns[i].dataset.deadline === "" && countdowns[i].dataset.locked !== "true") {
+            continue
+        }
+        countdowns[i].innerHTML = countdownText(countdowns[i].dataset.deadline, countdowns[i].dataset.locked)
+    }
 }
 
 window.addEventListener("load", function () {
 
+    updateCountdowns()
+    setInterval(updateCountdowns, 60000)
+    enableDragging()
+
     // Hide the loading screen once all images are loaded
     const loadingScreen = document.getElementById("loading-screen");
     loadingScreen.style.display = "none";
 
 });
+
+// Sends a change to the user's shoots and calls callback with the response when it worked
+function shootRequest(path, body, callback) {
+    let xhr = new XMLHttpRequest();
+    xhr.open("POST", path);
+    xhr.setRequestHeader("Content-Type", "application/json");
+    xhr.setRequestHeader("X-CSRF-Token", csrfToken());
+    xhr.setRequestHeader("Accept", "application/json");
+
+    xhr.onreadystatechange = function () {
+        if (xhr.readyState !== 4) {
+            return
+        }
+        let response = {}
+        try {
+            response = JSON.parse(xhr.responseText)
+        } catch (e) {}
+        if (xhr.status !== 200) {
+            alert(response.status || "Something went wrong, please try again")
+            return
+        }
+        callback(response)
+    };
+    xhr.send(JSON.stringify(body));
+}
+
+function tileIds() {
+    return Array.from(document.getElementsByClassName("tile")).map(tile => tile.dataset.id)
+}
+
+// Changes how the tiles are ordered
+// Switching to a custom order starts from the order the tiles are in now
+function changeOrder(order) {
+    let ids = order === "custom" ? tileIds() : []
+    shootRequest("/shoots/order", {order: order, ids: ids}, () => {
+        if (order === "custom") {
+            document.body.dataset.order = order
+            enableDragging()
+            return
+        }
+        window.location.reload()
+    })
+}
+
+function renameShoot(event, button) {
+    event.stopPropagation() // The buttons sit on the tile, which opens the gallery when clicked
+    let tile = button.closest(".tile")
+    let heading = tile.querySelector("h2")
+    let name = prompt("New name for this shoot", heading.textContent)
+    if (name === null || name.trim() === "" || name.trim() === heading.textContent) {
+        return
+    }
+
+    shootRequest("/shoot/" + encodeURIComponent(tile.dataset.id) + "/update", {name: name.trim()}, (response) => {
+        heading.textContent = response.shoot.name
+    })
+}
+
+function deleteShoot(event, button) {
+    event.stopPropagation()
+    let tile = button.closest(".tile")
+    let name = tile.querySelector("h2").textContent
+    if (!confirm("Delete " + name + "? Its picks, comments and share links go with it.")) {
+        return
+    }
+
+    // Only photographers see the delete button, they can choose to delete the photos themselves too
+    let purge = confirm("Also delete the photos stored for " + name + "? This can not be undone.\n\nPress Cancel to keep the photos.")
+
+    shootRequest("/shoot/" + encodeURIComponent(tile.dataset.id) + "/delete", {purge: purge}, () => {
+        tile.remove()
+    })
+}
+
+// Tile being dragged to a new place in a custom order
+let draggedTile = null
+
+// Lets tiles be dragged into a new order, only while the custom order is chosen
+function enableDragging() {
+    let custom = document.body.dataset.order === "custom"
+    Array.from(document.getElementsByClassName("tile")).forEach(tile => {
+        tile.draggable = custom
+        tile.classList.toggle("arrangeable", custom)
+        if (tile.dataset.dragging === "true") {
+            return
+        }
+        tile.dataset.dragging = "true"
+
+        tile.addEventListener("dragstart", (event) => {
+            draggedTile = tile
+            tile.classList.add("dragging")
+            event.dataTransfer.effectAllowed = "move"
+        })
+        tile.addEventListener("dragover", (event) => {
+            if (draggedTile === null || draggedTile === tile) {
+                return
+            }
+            event.preventDefault()
+            let box = tile.getBoundingClientRect()
+            let after = event.clientX > box.left + box.width / 2
+            tile.parentNode.insertBefore(draggedTile, after ? tile.nextSibling : tile)
+        })
+        tile.addEventListener("drop", (event) => event.preventDefault())
+        tile.addEventListener("dragend", () => {
+            tile.classList.remove("dragging")
+            draggedTile = null
+            shootRequest("/shoots/order", {order: "custom", ids: tileIds()}, () => {})
+        })
+    })
+}
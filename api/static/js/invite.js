@@ -0,0 +1,39 @@
+function sendInvite(){
+    var invite = {};
+    invite.email = document.getElementById("email").value;
+    invite.role = document.getElementById("role").value;
+
+    let shootName = document.getElementById("shoot_name").value;
+    if (shootName !== "") {
+        let shoot = {};
+        shoot.prefix = document.getElementById("prefix").value;
+        shoot.date = document.getElementById("date").value;
+        shoot.thumbnail = document.getElementById("thumbnail").value;
+
+        let deadline = document.getElementById("deadline").value;
+        if (deadline !== "") {
+            shoot.deadline = new Date(deadline).toISOString();
+        }
+
+        invite.shoots = {};
+        invite.shoots[shootName] = shoot;
+    }
+
+    let xhr = new XMLHttpRequest();
+    xhr.open("POST", window.location.origin + "/invite");
+    xhr.setRequestHeader("X-CSRF-Token", csrfToken());
+    xhr.setRequestHeader("Accept", "application/json");
+    xhr.setRequestHeader("Content-Type", "application/json");
+
+    xhr.onreadystatechange = function () {
+        if (xhr.readyState === 4) {
+            let response = JSON.parse(xhr.responseText)
+            if (xhr.status === 200){
+                document.getElementById("invite_link").innerHTML = "Invite sent! Link: " + response.link
+            } else{
+                alert(response.status)
+            }
+        }
+    };
+    xhr.send(JSON.stringify(invite));
+}
@@ -21,6 +21,7 @@ function login() {
     console.log(window.location.href.substring(0, currentLink.lastIndexOf("/")) + "/signin")
     let xhr = new XMLHttpRequest();
     xhr.open("POST", window.location.href.substring(0, currentLink.lastIndexOf("/")) + "/signin");
+    xhr.setRequestHeader("X-CSRF-Token", csrfToken());
     xhr.setRequestHeader("Accept", "application/json");
     xhr.setRequestHeader("Content-Type", "application/json");
 
@@ -29,12 +30,28 @@ function login() {
             console.log(xhr.status);
             console.log(xhr.responseText);
             if (xhr.status === 200 || xhr.status === 202) {
+                let response = JSON.parse(xhr.responseText)
+                if (response.enroll) {
+                    // 2FA is required for this account but has not been set up yet
+                    window.location.href = window.location.origin + "/2fa?challenge=" + encodeURIComponent(response.challenge)
+                    return
+                }
+                if (response.twoFactor) {
+                    window.challenge = response.challenge
+                    document.getElementById("password_step").style.display = "none"
+                    document.getElementById("two_factor_step").style.display = "block"
+                    document.getElementById("code").focus()
+                    return
+                }
+
                 console.log("Authentication success!")
                 let newLink = currentLink.substring(0, currentLink.lastIndexOf("/"))
                 newLink += "/home"
                 window.location.href = newLink
-            } else if (xhr.status === 404) {
+            } else if (xhr.status === 401) {
                 alert(xhr.responseText)
+            } else if (xhr.status === 403 || xhr.status === 429) {
+                alert(JSON.parse(xhr.responseText).status)
             } else {
                 alert("Something went wrong")
                 console.log("Something went wrong")
@@ -44,9 +61,45 @@ function login() {
     xhr.send(JSON.stringify(auth));
 }
 
+// Second step of logging in for accounts with 2FA
+function submitCode() {
+    let xhr = new XMLHttpRequest();
+    xhr.open("POST", window.location.origin + "/signin/2fa");
+    xhr.setRequestHeader("X-CSRF-Token", csrfToken());
+    xhr.setRequestHeader("Accept", "application/json");
+    xhr.setRequestHeader("Content-Type", "application/json");
+
+    xhr.onreadystatechange = function () {
+        if (xhr.readyState === 4) {
+            if (xhr.status === 202) {
+                window.location.href = window.location.origin + "/home"
+            } else if (xhr.status === 429) {
+                alert(JSON.parse(xhr.responseText).status)
+            } else if (xhr.status === 401) {
+                let message = JSON.parse(xhr.responseText).status
+                alert(message)
+                if (message !== "incorrect code") {
+                    window.location.reload()
+                }
+            } else {
+                alert("Something went wrong")
+            }
+        }
+    };
+    xhr.send(JSON.stringify({challenge: window.challenge, code: document.getElementById("code").value}));
+}
+
 window.addEventListener("load", function () {
+    if (window.location.search.includes("verified=true")) {
+        alert("Your email address has been verified, you can now log in")
+    } else if (window.location.search.includes("verified=false")) {
+        alert("That verification link is invalid or has expired")
+    }
+
     document.addEventListener("keypress", function (event) {
-        if (event.key === "Enter") {
+        if (event.key === "Enter" && window.challenge) {
+            submitCode(); // Submit the 2FA code when Enter is pressed on the second step
+        } else if (event.key === "Enter") {
             login(); // Call the login function when Enter is pressed
         }
     });
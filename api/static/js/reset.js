@@ -0,0 +1,33 @@
+function resetPassword() {
+    let password = document.getElementById("password").value;
+    if (password !== document.getElementById("confirm").value) {
+        alert("Passwords do not match")
+        return
+    }
+
+    let xhr = new XMLHttpRequest();
+    xhr.open("POST", window.location.href);
+    xhr.setRequestHeader("X-CSRF-Token", csrfToken());
+    xhr.setRequestHeader("Accept", "application/json");
+    xhr.setRequestHeader("Content-Type", "application/json");
+
+    xhr.onreadystatechange = function () {
+        if (xhr.readyState === 4) {
+            if (xhr.status === 200) {
+                alert("Your password has been reset, please log in")
+                window.location.href = window.location.origin + "/login"
+            } else {
+                alert(JSON.parse(xhr.responseText).status)
+            }
+        }
+    };
+    xhr.send(JSON.stringify({password: password}));
+}
+
+window.addEventListener("load", function () {
+    document.addEventListener("keypress", function (event) {
+        if (event.key === "Enter") {
+            resetPassword(); // Call the reset function when Enter is pressed
+        }
+    });
+})
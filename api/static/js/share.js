@@ -0,0 +1,342 @@
+// The gallery people see through a share link
+// Guests on links that allow it can keep favorites of their own, everything else is read only
+
+// Token of the share link, every request for the gallery goes through it
+function shareToken() {
+    return document.body.dataset.token
+}
+
+// Sends the password for a protected link, then reloads to show the photos
+function unlock() {
+    let status = document.getElementById("unlock_status")
+    let xhr = new XMLHttpRequest();
+    xhr.open("POST", "/s/" + shareToken() + "/unlock");
+    xhr.setRequestHeader("Content-Type", "application/json");
+    xhr.setRequestHeader("X-CSRF-Token", csrfToken());
+    xhr.setRequestHeader("Accept", "application/json");
+
+    xhr.onreadystatechange = function () {
+        if (xhr.readyState !== 4) {
+            return
+        }
+        if (xhr.status === 200) {
+            window.location.reload()
+        } else if (xhr.status === 429) {
+            status.innerHTML = "Too many attempts, please try again later"
+        } else {
+            status.innerHTML = "That password is not right"
+        }
+    };
+    xhr.send(JSON.stringify({password: document.getElementById("share_password").value}));
+}
+
+window.addEventListener("load", function () {
+    if (document.getElementById("gallery") === null) {
+        return
+    }
+    updatePhotoCount()
+    watchGalleryEnd()
+    loadFavorites()
+});
+
+// Shows how many of the shoot's photos have been loaded so far
+function updatePhotoCount() {
+    let pageNum = document.getElementById("page_num")
+    let shown = document.getElementById("gallery").children.length
+    pageNum.innerHTML = shown + " of " + pageNum.dataset.total + " Photos"
+}
+
+// Adds a photo from a batch to the end of the gallery
+function addPhoto(gallery, photo) {
+    let anchor = document.createElement("a")
+    anchor.id = photo.key
+    anchor.onclick = function () { openLightbox(this.id) }
+    anchor.dataset.preview = photo.renditions.preview
+
+    let img = document.createElement("img")
+    img.loading = "lazy"
+    img.src = photo.renditions.thumbnail
+    anchor.appendChild(img)
+
+    if (favoritesAllowed()) {
+        let heart = document.createElement("span")
+        heart.className = "favorite"
+        heart.onclick = function (event) { toggleFavorite(event, anchor.id) }
+        anchor.appendChild(heart)
+    }
+    gallery.appendChild(anchor)
+    showFavorite(anchor)
+}
+
+let loadingBatch = false
+
+// Gets the next batch of photos and adds it to the gallery
+function loadMore() {
+    let gallery = document.getElementById("gallery")
+    let cursor = gallery.dataset.cursor
+    if (loadingBatch || !cursor) {
+        return
+    }
+    loadingBatch = true
+
+    let xhr = new XMLHttpRequest();
+    xhr.open("GET", "/s/" + shareToken() + "/photos?cursor=" + encodeURIComponent(cursor));
+    xhr.setRequestHeader("Accept", "application/json");
+
+    xhr.onreadystatechange = function () {
+        if (xhr.readyState !== 4) {
+            return
+        }
+        loadingBatch = false
+        if (xhr.status !== 200) {
+            console.log("Something went wrong loading more photos: " + xhr.status)
+            return
+        }
+
+        let batch = JSON.parse(xhr.responseText)
+        batch.photos.forEach(photo => addPhoto(gallery, photo))
+        gallery.dataset.cursor = batch.nextCursor
+        document.getElementById("page_num").dataset.total = batch.total
+        updatePhotoCount()
+
+        // Observing again checks straight away, so short batches keep loading until the screen is full
+        let end = document.getElementById("gallery-end")
+        galleryObserver.unobserve(end)
+        galleryObserver.observe(end)
+    };
+    xhr.send();
+}
+
+let galleryObserver = null
+
+// Loads more photos as the end of the gallery gets close to scrolling into view
+function watchGalleryEnd() {
+    galleryObserver = new IntersectionObserver((entries) => {
+        if (entries.some(entry => entry.isIntersecting)) {
+            loadMore()
+        }
+    }, {rootMargin: "1000px"})
+    galleryObserver.observe(document.getElementById("gallery-end"))
+}
+
+// Index in the gallery of the photo in the lightbox, -1 when it is closed
+let lightboxIndex = -1
+
+// Photos that have not had a preview made yet fall back to their thumbnail
+function previewUrl(anchor) {
+    return anchor.dataset.preview || anchor.childNodes[0].src
+}
+
+function openLightbox(id) {
+    let photos = Array.from(document.getElementById("gallery").children)
+    document.getElementById("lightbox").hidden = false
+    showLightboxPhoto(photos.findIndex(anchor => anchor.id === id))
+}
+
+function closeLightbox() {
+    document.getElementById("lightbox").hidden = true
+    lightboxIndex = -1
+}
+
+function showLightboxPhoto(index) {
+    let photos = document.getElementById("gallery").children
+    if (index < 0 || index >= photos.length) {
+        return
+    }
+    lightboxIndex = index
+
+    let anchor = photos[index]
+    let img = document.getElementById("lightbox-image")
+    img.onerror = () => {
+        img.onerror = null
+        img.src = anchor.childNodes[0].src
+    }
+    img.src = previewUrl(anchor)
+    document.getElementById("lightbox-position").innerHTML = (index + 1) + " of " + document.getElementById("page_num").dataset.total
+    updateLightboxFavorite()
+
+    if (index >= photos.length - 3) {
+        loadMore()
+    }
+}
+
+document.addEventListener("keydown", (event) => {
+    if (lightboxIndex < 0) {
+        return
+    }
+
+    switch (event.key) {
+        case "ArrowRight":
+            showLightboxPhoto(lightboxIndex + 1)
+            break
+        case "ArrowLeft":
+            showLightboxPhoto(lightboxIndex - 1)
+            break
+        case "Escape":
+            closeLightbox()
+            break
+        case "f":
+        case "F":
+            if (!favoritesAllowed()) {
+                return
+            }
+            toggleFavorite(event, document.getElementById("gallery").children[lightboxIndex].id)
+            break
+        default:
+            return
+    }
+    event.preventDefault()
+});
+
+// Keys of the photos the guest favorited, null until the guest has signed in
+let favorites = null
+
+// Photo to favorite once the guest has signed in, so the click that asked them to is not lost
+let pendingFavorite = null
+
+function favoritesAllowed() {
+    return document.body.dataset.favorites === "true"
+}
+
+// Gets the favorites of the guest signed in on this link, if there is one
+function loadFavorites() {
+    if (!favoritesAllowed()) {
+        return
+    }
+
+    let xhr = new XMLHttpRequest();
+    xhr.open("GET", "/s/" + shareToken() + "/favorites");
+    xhr.setRequestHeader("Accept", "application/json");
+
+    xhr.onreadystatechange = function () {
+        if (xhr.readyState === 4 && xhr.status === 200) {
+            let response = JSON.parse(xhr.responseText)
+            signedIn(response.name, response.favorites)
+        }
+    };
+    xhr.send();
+}
+
+function signedIn(name, list) {
+    favorites = list
+    document.getElementById("guest_button").textContent = "Favorites of " + name
+    Array.from(document.getElementById("gallery").children).forEach(showFavorite)
+    if (lightboxIndex >= 0) {
+        updateLightboxFavorite()
+    }
+}
+
+// Fills in the heart on a photo
+function showFavorite(anchor) {
+    let heart = anchor.querySelector(".favorite")
+    if (heart === null) {
+        return
+    }
+    let favorite = favorites !== null && favorites.includes(anchor.id)
+    heart.innerHTML = favorite ? "&#x2665;" : "&#x2661;"
+    anchor.classList.toggle("favorited", favorite)
+}
+
+function updateLightboxFavorite() {
+    let label = document.getElementById("lightbox-favorite")
+    if (label === null) {
+        return
+    }
+    let anchor = document.getElementById("gallery").children[lightboxIndex]
+    label.innerHTML = favorites !== null && favorites.includes(anchor.id) ? "&#x2665; Favorite" : "&#x2661;"
+}
+
+function openGuestForm() {
+    document.getElementById("guest").hidden = false
+    document.getElementById("guest_name").focus()
+}
+
+function closeGuestForm() {
+    document.getElementById("guest").hidden = true
+    pendingFavorite = null
+}
+
+// Signs the guest in with their name and email
+function startGuest() {
+    let status = document.getElementById("guest_status")
+    let xhr = new XMLHttpRequest();
+    xhr.open("POST", "/s/" + shareToken() + "/guest");
+    xhr.setRequestHeader("Content-Type", "application/json");
+    xhr.setRequestHeader("X-CSRF-Token", csrfToken());
+    xhr.setRequestHeader("Accept", "application/json");
+
+    xhr.onreadystatechange = function () {
+        if (xhr.readyState !== 4) {
+            return
+        }
+        let response = {}
+        try {
+            response = JSON.parse(xhr.responseText)
+        } catch (e) {}
+        if (xhr.status !== 200) {
+            status.textContent = response.status || "Something went wrong, please try again"
+            return
+        }
+
+        let pending = pendingFavorite
+        closeGuestForm()
+        signedIn(response.name, response.favorites)
+        if (pending !== null && !favorites.includes(pending)) {
+            saveFavorite(pending, true)
+        }
+    };
+    xhr.send(JSON.stringify({
+        name: document.getElementById("guest_name").value,
+        email: document.getElementById("guest_email").value,
+    }));
+}
+
+function toggleFavorite(event, id) {
+    event.stopPropagation() // Favoriting should not also open the lightbox
+    if (favorites === null) {
+        pendingFavorite = id
+        openGuestForm()
+        return
+    }
+    saveFavorite(id, !favorites.includes(id))
+}
+
+// Saves one photo as a favorite or takes it off
+function saveFavorite(id, favorite) {
+    let xhr = new XMLHttpRequest();
+    xhr.open("POST", "/s/" + shareToken() + "/favorite");
+    xhr.setRequestHeader("Content-Type", "application/json");
+    xhr.setRequestHeader("X-CSRF-Token", csrfToken());
+    xhr.setRequestHeader("Accept", "application/json");
+
+    xhr.onreadystatechange = function () {
+        if (xhr.readyState !== 4) {
+            return
+        }
+        let response = {}
+        try {
+            response = JSON.parse(xhr.responseText)
+        } catch (e) {}
+        if (xhr.status !== 200) {
+            alert(response.status || "Something went wrong saving your favorite")
+            return
+        }
+        favorites = response.favorites
+        showFavorite(document.getElementById(id))
+        if (lightboxIndex >= 0) {
+            updateLightboxFavorite()
+        }
+    };
+    xhr.send(JSON.stringify({key: id, favorite: favorite}));
+}
+
+// Shows just the guest's favorites, or every photo again
+function toggleOnlyFavorites() {
+    if (favorites === null) {
+        openGuestForm()
+        return
+    }
+    let gallery = document.getElementById("gallery")
+    let only = gallery.classList.toggle("only-favorites")
+    document.getElementById("only_favorites").textContent = only ? "Show All Photos" : "Show My Favorites"
+}
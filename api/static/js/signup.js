@@ -1,5 +1,6 @@
 function submit_new_user(){
     var user = {};
+    user.invite = document.getElementById("invite").value;
     user.username = document.getElementById("username").value;
     user.password = document.getElementById("password").value;
     user.email = document.getElementById("email").value;
@@ -11,10 +12,9 @@ function submit_new_user(){
     user.zip = document.getElementById("zip").value;
     user.phone = document.getElementById("phone number").value;
 
-    let currentLink = window.location.href;
-    console.log(window.location.href.substring(0,currentLink.lastIndexOf("/")) + "/createUser")
     let xhr = new XMLHttpRequest();
-    xhr.open("POST", window.location.href.substring(0,currentLink.lastIndexOf("/")) + "/createUser");
+    xhr.open("POST", window.location.origin + "/createUser");
+    xhr.setRequestHeader("X-CSRF-Token", csrfToken());
     xhr.setRequestHeader("Accept", "application/json");
     xhr.setRequestHeader("Content-Type", "application/json");
 
@@ -23,21 +23,27 @@ function submit_new_user(){
             console.log(xhr.status);
             console.log(xhr.responseText);
             if (xhr.status === 200){
-                alert("User Created!")
+                if (JSON.parse(xhr.responseText).verified) {
+                    alert("User Created!")
+                } else {
+                    alert("User Created! Check your email for a link to verify your email address before logging in.")
+                }
+                window.location.href = window.location.origin + "/login"
+            } else if (xhr.status === 403 || xhr.status === 409){
+                alert(JSON.parse(xhr.responseText).status)
             } else{
                 alert("Something went wrong :(")
             }
         }
     };
     xhr.send(JSON.stringify(user));
-
-    
-    let newLink = currentLink.substring(0,currentLink.lastIndexOf("/"))
-    newLink += "/login"
-    window.location.href = newLink
 }
 
 window.addEventListener("load", function () {
+    if (document.getElementById("invite") === null) {
+        return // Nothing to submit without an invite
+    }
+
     document.addEventListener("keypress", function (event) {
         if (event.key === "Enter") {
             submit_new_user(); // Call the login function when Enter is pressed
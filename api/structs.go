@@ -1,39 +1,207 @@
 package main
 
+import "main/models"
+
+// Types sent over the JSON API are shared with the Go client in the client package and live in models
+type (
+	Comment          = models.Comment
+	RejectedPick     = models.RejectedPick
+	Picks            = models.Picks
+	ShootResource    = models.ShootResource
+	ShootRequest     = models.ShootRequest
+	ShootUpdate      = models.ShootUpdate
+	ShootOrder       = models.ShootOrder
+	PhotoResource    = models.PhotoResource
+	PhotoRating      = models.PhotoRating
+	ShareResource    = models.ShareResource
+	ShareRequest     = models.ShareRequest
+	FavoriteResource = models.FavoriteResource
+	CommentRequest   = models.CommentRequest
+	UserResource     = models.UserResource
+	Pagination       = models.Pagination
+	APIErrorResponse = models.APIErrorResponse
+	APIError         = models.APIError
+	CSRFResource     = models.CSRFResource
+)
+
 type User struct {
-	Username   string           `json:"username"`
-	First_name string           `json:"first"`
-	Last_name  string           `json:"last"`
-	Email      string           `json:"email"`
-	Phone      string           `json:"phone"`
-	Address    string           `json:"address"`
-	City       string           `json:"city"`
-	State      string           `json:"state"`
-	Password   string           `json:"password"`
-	Salt       string           `json:"salt"`
-	Shoots     map[string]Shoot `json:"shoots"`
-	Zip        string           `json:"zip"`
+	Username      string              `json:"username"`
+	First_name    string              `json:"first"`
+	Last_name     string              `json:"last"`
+	Email         string              `json:"email"`
+	Phone         string              `json:"phone"`
+	Address       string              `json:"address"`
+	City          string              `json:"city"`
+	State         string              `json:"state"`
+	Password      string              `json:"password"`
+	Salt          string              `json:"salt"`
+	Shoots        map[string]Shoot    `json:"shoots"`
+	Zip           string              `json:"zip"`
+	Role          string              `json:"role"`
+	Unverified    bool                `json:"unverified"` // Set until the user follows the link in their verification email
+	TOTPSecret    string              `json:"totpSecret"`
+	TOTPEnabled   bool                `json:"totpEnabled"`
+	RecoveryCodes []string            `json:"recoveryCodes"`        // bcrypt hashes of the unused recovery codes
+	APITokens     map[string]APIToken `json:"apiTokens,omitempty"`  // Personal access tokens by id, left out when empty so the map can be created on first use
+	TileOrder     string              `json:"tileOrder,omitempty"`  // How the home page orders shoots, one of "date", "name" or "custom"
+	ShootOrder    []string            `json:"shootOrder,omitempty"` // Shoot ids in the order the user arranged them, used when TileOrder is "custom"
 }
 
-type Thumbnail struct {
-	Key string
-	Url string
+// A personal access token scripts use to call the API as a user
+// Only a hash of the secret is stored, the token itself is shown once when it is created
+type APIToken struct {
+	Name     string   `json:"name"`
+	Hash     string   `json:"hash"` // Hex SHA-256 of the token's secret
+	Scopes   []string `json:"scopes"`
+	Created  string   `json:"created"`
+	LastUsed string   `json:"lastUsed"`
 }
 
 type Shoot struct {
-	Files     []string `json:"files"`
-	Picks     Picks    `json:"picks"`
-	Prefix    string   `json:"prefix"`
-	Date      string   `json:"date"`
-	Thumbnail string   `json:"thumbnail"`
+	Name          string                 `json:"name"` // Display name, the shoot's key in the shoots map is its generated id
+	Files         []string               `json:"files"`
+	Picks         Picks                  `json:"picks"`
+	Prefix        string                 `json:"prefix"`
+	Date          string                 `json:"date"`
+	Thumbnail     string                 `json:"thumbnail"`
+	Deadline      string                 `json:"deadline"` // RFC 3339 time the picks are due by
+	Locked        bool                   `json:"locked"`
+	RemindersSent []string               `json:"remindersSent"`
+	SubmittedAt   string                 `json:"submittedAt"`
+	DeliveredAt   string                 `json:"deliveredAt"`
+	MaxPicks      int                    `json:"maxPicks"`           // Most photos the client can pick, 0 means only the site wide limit applies
+	Downloads     bool                   `json:"downloads"`          // Whether the client can download zips of the originals
+	Comments      []Comment              `json:"comments,omitempty"` // Left out when empty so new comments can be appended with list_append
+	Ratings       map[string]PhotoRating `json:"ratings,omitempty"`  // Ratings by photo key, left out when empty so the map can be created on first use
+	Shares        map[string]ShareLink   `json:"shares,omitempty"`   // Share links by id, left out when empty so the map can be created on first use
+}
+
+// The photos one guest on a share link marked as favorites
+// Guests do not have accounts, they are known by a random id kept in a cookie
+// Stored as its own item rather than on the shoot, so guests can not grow the client's user item
+type GuestFavorites struct {
+	Name    string   `json:"name"`
+	Email   string   `json:"email"`
+	Share   string   `json:"share"`                                          // Id of the share link the guest first used
+	Photos  []string `json:"photos" dynamodbav:"photos,omitempty,stringset"` // Photo keys, stored as a set so one can be added or taken off without rewriting the rest
+	Created string   `json:"created"`
+	Updated string   `json:"updated"`
+}
+
+// A link that lets people without an account look at a shoot
+// Only a hash of the link's secret is stored, the link itself is shown once when it is made
+type ShareLink struct {
+	Name         string   `json:"name"` // Who the link is for. Example: "Grandparents"
+	Hash         string   `json:"hash"` // Hex SHA-256 of the link's secret
+	Permissions  []string `json:"permissions"`
+	PasswordHash string   `json:"passwordHash,omitempty"` // Empty when the link does not need a password
+	Salt         string   `json:"salt,omitempty"`
+	UnlockNonce  string   `json:"unlockNonce,omitempty"` // Random value the unlock cookie holds, replaced whenever the password is
+	Expires      string   `json:"expires,omitempty"`     // RFC 3339 time the link stops working, empty for never
+	Views        int      `json:"views"`
+	Created      string   `json:"created"`
+	CreatedBy    string   `json:"createdBy"`
+	LastViewed   string   `json:"lastViewed,omitempty"`
+}
+
+type Invite struct {
+	Email     string           `json:"email"`
+	Role      string           `json:"role"`
+	Shoots    map[string]Shoot `json:"shoots"`
+	InvitedBy string           `json:"invitedBy"`
+	Created   string           `json:"created"`
+}
+
+type Thumbnail struct {
+	Key     string
+	Url     string
+	Preview string // Pre-signed url of the larger rendition shown in the lightbox
+}
+
+// A photo in a batch of the gallery's infinite scroll
+type GalleryPhoto struct {
+	Key        string            `json:"key"`
+	Position   int               `json:"position"`   // Place in the shoot, starting at 0
+	Renditions map[string]string `json:"renditions"` // Pre-signed urls by rendition. Example: {"thumbnail": "https://..."}
+	Picked     bool              `json:"picked"`     // Whether the photo is in the saved picks
+	Comments   int               `json:"comments"`   // Number of comments on the photo
+	Rating     int               `json:"rating"`     // Stars from 1 to 5, 0 for none
+	Label      string            `json:"label"`      // Color label, empty for none
 }
 
-type Picks struct {
-	Count int      `json:"count"`
-	Picks []string `json:"picks"`
+// One batch of the gallery's infinite scroll
+type GalleryBatch struct {
+	Photos     []GalleryPhoto `json:"photos"`
+	NextCursor string         `json:"nextCursor"` // Pass as cursor to get the next batch, empty at the end of the shoot
+	Total      int            `json:"total"`
+	Expires    string         `json:"expires"` // RFC 3339 time the first of the urls stops working
 }
 
 type HomePageTile struct {
+	ID        string
 	Name      string
+	Date      string
 	Thumbnail string
+	Deadline  string
+	Locked    bool
+}
+
+type GalleryPage struct {
+	Thumbnails []Thumbnail
+	Deadline   string
+	Locked     bool
+	CSRFToken  string
+	ShootID    string
+	Page       int    // Page the gallery starts on, starting at 0
+	Offset     int    // Position in the view of the first photo on the page, starting at 0
+	NextCursor string // Where the infinite scroll carries on from, empty when the page is the end of the shoot
+	Total      int    // Number of photos in the shoot
+	Downloads  bool   // Whether the client can download zips of the originals
+	Delivered  bool
+	View       GalleryView // How the photos are sorted and filtered
+	Categories []string    // Folders of the shoot, for choosing one to show
+}
+
+// The gallery people see through a share link
+type SharePage struct {
+	Token      string
+	Name       string // Display name of the shoot
+	Locked     bool   // Whether the password has to be entered before the photos are shown
+	Thumbnails []Thumbnail
+	NextCursor string
+	Total      int
+	Download   bool // Whether the delivered originals can be downloaded through the link
+	Favorites  bool // Whether guests can keep favorites through the link
+	CSRFToken  string
+}
+
+type HomePage struct {
+	Tiles        []HomePageTile
+	Order        string // How the tiles are ordered, one of tileOrderDate, tileOrderName or tileOrderCustom
+	Photographer bool   // Only photographers can delete shoots
+	CSRFToken    string
+}
+
+type SignupPage struct {
+	Token     string
+	Email     string
+	Bootstrap bool
+	CSRFToken string
+}
+
+type LockoutEvent struct {
+	Username string `json:"username"`
+	IP       string `json:"ip"`
+	Failures int    `json:"failures"`
+	Time     string `json:"time"`
+	Until    string `json:"until"`
+}
+
+// An API token as listed on the account page, without its hash
+type APITokenResource struct {
+	ID       string   `json:"id"`
+	Name     string   `json:"name"`
+	Scopes   []string `json:"scopes"`
+	Created  string   `json:"created"`
+	LastUsed string   `json:"lastUsed"`
 }
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// Generates a random token that is safe to put in a url path
+func generateURLToken() (string, error) {
+	token := make([]byte, 32)
+	_, err := rand.Read(token)
+	if err != nil {
+		return "", fmt.Errorf("could not generate token: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(token), nil
+}
+
+// Creates a token that expires and stores it in Redis along with a value
+// Used for links that are emailed to users such as password resets and invites
+// prefix namespaces the Redis key. Example: "reset:"
+// value is what the token should resolve to, usually a username
+// ttl is how long the token is good for
+func createOneTimeToken(r *redis.Client, prefix string, value string, ttl time.Duration) (string, error) {
+
+	token, err := generateURLToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = r.Set(prefix+token, value, ttl).Err()
+	if err != nil {
+		return "", fmt.Errorf("could not store token: %v", err)
+	}
+
+	return token, nil
+}
+
+// Returns the value a token resolves to without using it up
+func checkOneTimeToken(r *redis.Client, prefix string, token string) (string, error) {
+
+	value, err := r.Get(prefix + token).Result()
+	if err != nil {
+		return "", errors.New("link is invalid or has expired")
+	}
+
+	return value, nil
+}
+
+// Returns the value a token resolves to and deletes the token so it can not be used again
+// If two requests race for the same token only the one that deletes it wins
+func consumeOneTimeToken(r *redis.Client, prefix string, token string) (string, error) {
+
+	value, err := checkOneTimeToken(r, prefix, token)
+	if err != nil {
+		return "", err
+	}
+
+	deleted, err := r.Del(prefix + token).Result()
+	if err != nil || deleted != 1 {
+		return "", errors.New("link is invalid or has expired")
+	}
+
+	return value, nil
+}
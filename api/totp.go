@@ -0,0 +1,350 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+	"golang.org/x/crypto/bcrypt"
+	"rsc.io/qr"
+)
+
+// TOTP parameters from RFC 6238. These are the defaults every authenticator app supports
+const (
+	totpDigits = 6
+	totpPeriod = 30 // Seconds each code is good for
+	totpSkew   = 1  // Number of periods either side of now that are still accepted to allow for clock drift
+)
+
+// Number of recovery codes generated when 2FA is turned on
+const recoveryCodeCount = 10
+
+// How long a user has between entering their password and entering their 2FA code
+const twoFactorChallengeMinutes = 5
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Generates a new random TOTP secret, base32 encoded for authenticator apps
+func generateTOTPSecret() (string, error) {
+	secret := make([]byte, 20)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", fmt.Errorf("could not generate TOTP secret: %v", err)
+	}
+	return totpEncoding.EncodeToString(secret), nil
+}
+
+// Calculates the TOTP code for a secret at a given counter value
+// counter is the number of periods since the unix epoch
+func totpCode(secret string, counter uint64) (string, error) {
+
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", fmt.Errorf("invalid TOTP secret: %v", err)
+	}
+
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, counter)
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation from RFC 4226
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		mod *= 10
+	}
+
+	return fmt.Sprintf("%0*d", totpDigits, value%mod), nil
+}
+
+// Checks a code against a secret, allowing for a little clock drift
+// Returns the counter the code matched so the caller can stop it being used twice
+func validateTOTP(secret string, code string, now time.Time) (uint64, bool) {
+
+	code = strings.TrimSpace(code)
+	if len(code) != totpDigits {
+		return 0, false
+	}
+
+	current := uint64(now.Unix()) / totpPeriod
+	for i := -totpSkew; i <= totpSkew; i++ {
+		counter := current + uint64(i)
+		expected, err := totpCode(secret, counter)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return counter, true
+		}
+	}
+
+	return 0, false
+}
+
+// Checks a TOTP code and makes sure the same code can not be used twice
+func verifyTOTP(r *redis.Client, username string, secret string, code string) bool {
+
+	counter, ok := validateTOTP(secret, code, time.Now())
+	if !ok {
+		return false
+	}
+
+	// A code is good for up to three periods, remember it for that long
+	key := fmt.Sprintf("totp-used:%v:%v", username, counter)
+	fresh, err := r.SetNX(key, "1", time.Second*totpPeriod*(2*totpSkew+1)).Result()
+	if err != nil {
+		return false
+	}
+
+	return fresh
+}
+
+// Builds the otpauth:// uri authenticator apps read from the QR code
+// issuer is the name shown in the authenticator app. Example: "Client Photos"
+func totpURI(secret string, username string, issuer string) string {
+
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(totpDigits))
+	params.Set("period", fmt.Sprint(totpPeriod))
+
+	label := url.PathEscape(issuer + ":" + username)
+
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// Renders a uri as a QR code PNG
+func qrCodePNG(uri string) ([]byte, error) {
+
+	code, err := qr.Encode(uri, qr.M)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode QR code: %v", err)
+	}
+
+	return code.PNG(), nil
+}
+
+// Generates a set of single use recovery codes
+// Returns the codes to show the user once and the bcrypt hashes to store
+func generateRecoveryCodes() ([]string, []string, error) {
+
+	var codes []string
+	var hashes []string
+
+	for i := 0; i < recoveryCodeCount; i++ {
+		raw := make([]byte, 5)
+		_, err := rand.Read(raw)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not generate recovery code: %v", err)
+		}
+		code := strings.ToLower(totpEncoding.EncodeToString(raw))
+
+		hash, err := bcrypt.GenerateFromPassword([]byte(code), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not hash recovery code: %v", err)
+		}
+
+		codes = append(codes, code)
+		hashes = append(hashes, string(hash))
+	}
+
+	return codes, hashes, nil
+}
+
+// Checks a recovery code against the stored hashes
+// Returns the hashes that are left once the used code is removed
+func useRecoveryCode(hashes []string, code string) ([]string, bool) {
+
+	code = strings.ToLower(strings.TrimSpace(code))
+
+	for i, hash := range hashes {
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(code)) == nil {
+			remaining := append([]string{}, hashes[:i]...)
+			return append(remaining, hashes[i+1:]...), true
+		}
+	}
+
+	return hashes, false
+}
+
+// Checks the second factor a user entered, either a TOTP code or a recovery code
+// Used up recovery codes are removed from the user's account
+func verifySecondFactor(tableName string, user User, code string, r *redis.Client, svc *dynamodb.DynamoDB) bool {
+
+	if verifyTOTP(r, user.Username, user.TOTPSecret, code) {
+		return true
+	}
+
+	remaining, ok := useRecoveryCode(user.RecoveryCodes, code)
+	if !ok {
+		return false
+	}
+
+	err := setRecoveryCodes(tableName, user.Username, remaining, svc)
+	if err != nil {
+		// Do not let the code be used if it could not be crossed off
+		return false
+	}
+
+	return true
+}
+
+// Reports whether a user has to use 2FA
+// staffRequired is set when photographers and admins must have 2FA turned on
+func twoFactorRequired(user User, staffRequired bool) bool {
+	return user.TOTPEnabled || (staffRequired && isPhotographer(user))
+}
+
+// Returned when 2FA is turned on for a user who already has it
+// Swapping the secret needs 2FA turned off first, which takes a current code
+var errTwoFactorEnabled = errors.New("2FA is already turned on, turn it off first to use a new authenticator")
+
+// Turns on 2FA for a user with the given secret and recovery code hashes
+// Returns errTwoFactorEnabled if the user already has 2FA on
+func enableTwoFactor(tableName string, username string, secret string, hashes []string, svc *dynamodb.DynamoDB) error {
+
+	codes, err := dynamodbattribute.Marshal(hashes)
+	if err != nil {
+		return err
+	}
+
+	updateInput := &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"username": {
+				S: aws.String(username),
+			},
+		},
+		UpdateExpression:    aws.String("SET totpSecret = :secret, totpEnabled = :enabled, recoveryCodes = :codes"),
+		ConditionExpression: aws.String("attribute_not_exists(totpEnabled) OR totpEnabled = :disabled"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":secret":   {S: aws.String(secret)},
+			":enabled":  {BOOL: aws.Bool(true)},
+			":disabled": {BOOL: aws.Bool(false)},
+			":codes":    codes,
+		},
+	}
+
+	_, err = svc.UpdateItem(updateInput)
+	if conditionFailed(err) {
+		return errTwoFactorEnabled
+	}
+	if err != nil {
+		return fmt.Errorf("could not enable 2FA: %v", err)
+	}
+
+	return nil
+}
+
+// Replaces the recovery code hashes for a user
+func setRecoveryCodes(tableName string, username string, hashes []string, svc *dynamodb.DynamoDB) error {
+
+	codes, err := dynamodbattribute.Marshal(hashes)
+	if err != nil {
+		return err
+	}
+
+	updateInput := &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"username": {
+				S: aws.String(username),
+			},
+		},
+		UpdateExpression: aws.String("SET recoveryCodes = :codes"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":codes": codes,
+		},
+	}
+
+	_, err = svc.UpdateItem(updateInput)
+	if err != nil {
+		return fmt.Errorf("could not update recovery codes: %v", err)
+	}
+
+	return nil
+}
+
+// Turns off 2FA for a user and removes their secret and recovery codes
+func disableTwoFactor(tableName string, username string, svc *dynamodb.DynamoDB) error {
+
+	updateInput := &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"username": {
+				S: aws.String(username),
+			},
+		},
+		UpdateExpression: aws.String("REMOVE totpSecret, totpEnabled, recoveryCodes"),
+	}
+
+	_, err := svc.UpdateItem(updateInput)
+	if err != nil {
+		return fmt.Errorf("could not disable 2FA: %v", err)
+	}
+
+	return nil
+}
+
+// Creates the token a user holds between entering their password and their 2FA code
+// purpose is either "2fa:" for users with 2FA or "2fa-enroll:" for users who must set it up first
+func createTwoFactorChallenge(r *redis.Client, purpose string, username string) (string, error) {
+	return createOneTimeToken(r, purpose, username, time.Minute*twoFactorChallengeMinutes)
+}
+
+// Counts a wrong 2FA code against a sign in challenge
+// Returns true once the challenge has had too many wrong codes and should be thrown away
+func twoFactorAttemptsExceeded(r *redis.Client, challenge string) bool {
+
+	key := "2fa-attempts:" + challenge
+	attempts, err := r.Incr(key).Result()
+	if err != nil {
+		return true
+	}
+	r.Expire(key, time.Minute*twoFactorChallengeMinutes)
+
+	return attempts >= 5
+}
+
+// Works out who is setting up 2FA
+// Users are either logged in or are part way through signing in and were told to enroll,
+// in which case the challenge from /signin is passed in the challenge query parameter
+// Returns the username and whether it came from an enrollment challenge
+func twoFactorUsername(c *gin.Context, r *redis.Client, cookies *CookieCodec) (string, bool, bool) {
+
+	if auth, username := checkToken(c, r, cookies); auth {
+		return username, false, true
+	}
+
+	challenge := c.Query("challenge")
+	if challenge == "" {
+		return "", false, false
+	}
+
+	username, err := checkOneTimeToken(r, "2fa-enroll:", challenge)
+	if err != nil {
+		return "", false, false
+	}
+
+	return username, true, true
+}
@@ -0,0 +1,440 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/go-redis/redis"
+)
+
+// Orders a gallery can be sorted in
+const (
+	sortShoot     = ""          // The order the photos are in the shoot, by key or as the shoot's file list has them
+	sortName      = "name"      // By file name
+	sortCaptured  = "captured"  // By when the photo was taken, oldest first
+	sortRating    = "rating"    // Most stars first
+	sortFavorites = "favorites" // Most favorited by guests on share links first
+)
+
+// Filters that narrow a gallery down to some of its photos
+const (
+	filterPicked    = "picked"
+	filterUnpicked  = "unpicked"
+	filterCommented = "commented"
+	filterFavorited = "favorited" // Favorited by at least one guest on a share link
+)
+
+// Frames taken this close together are stacked, like the bursts Lightroom's auto-stack groups
+const stackGap = 2 * time.Second
+
+// Most originals read at once when working out capture times
+const captureWorkers = 16
+
+// How long capture times are kept in Redis. Reading them again after this is cheap next to keeping
+// the times of deleted photos around for good
+const captureTimeTTL = 30 * 24 * time.Hour
+
+// How long a photo whose capture time could not be read waits before it is tried again
+const captureFailureTTL = 6 * time.Hour
+
+// Longest a server can hold the claim on reading a photo's capture time
+const captureClaimTTL = 5 * time.Minute
+
+// How a gallery is sorted and filtered
+// It is read from the query string so a view of the gallery can be shared as a link
+type GalleryView struct {
+	Sort      string // One of the sort constants
+	Filter    string // One of the filter constants, empty for every photo
+	Category  string // Folder of the shoot to show, empty for every folder. Example: "ceremony"
+	Stack     string // Key of a photo, shows just the frames stacked with it. Example: "IMG_0001"
+	MinRating int    // Fewest stars a photo needs to be shown, 0 shows every photo
+}
+
+// Reads a gallery view from the sort, filter, category, stack and rating query parameters
+func parseGalleryView(query url.Values) (GalleryView, error) {
+
+	view := GalleryView{
+		Sort:     query.Get("sort"),
+		Filter:   query.Get("filter"),
+		Category: query.Get("category"),
+		Stack:    query.Get("stack"),
+	}
+
+	switch view.Sort {
+	case sortShoot, sortName, sortCaptured, sortRating, sortFavorites:
+	default:
+		return GalleryView{}, fmt.Errorf("sort must be one of %v, %v, %v or %v", sortName, sortCaptured, sortRating, sortFavorites)
+	}
+
+	switch view.Filter {
+	case "", filterPicked, filterUnpicked, filterCommented, filterFavorited:
+	default:
+		return GalleryView{}, fmt.Errorf("filter must be one of %v, %v, %v or %v", filterPicked, filterUnpicked, filterCommented, filterFavorited)
+	}
+
+	if strings.ContainsAny(view.Category, "/\\") || view.Category == "." || view.Category == ".." {
+		return GalleryView{}, fmt.Errorf("category must be the name of a folder in the shoot")
+	}
+
+	minRating, err := parseMinRating(query.Get("rating"))
+	if err != nil {
+		return GalleryView{}, err
+	}
+	view.MinRating = minRating
+
+	return view, nil
+}
+
+// Reports whether the photos in a view change as the client picks, rates and comments, or guests favorite
+// Pages of these views can not be cached by the browser
+func (v GalleryView) changesWithSelections() bool {
+	return v.Filter != "" || v.MinRating > 0 || v.Sort == sortRating || v.Sort == sortFavorites
+}
+
+// Reports whether a view needs to know when each photo was taken
+func (v GalleryView) needsCaptureTimes() bool {
+	return v.Sort == sortCaptured || v.Stack != ""
+}
+
+// Returns the folder of the shoot a photo is in, empty if it is at the top of the shoot
+// Example: "smith/ceremony/IMG_0001_thumb.jpg" in the shoot with prefix "smith" is in "ceremony"
+func photoCategory(shoot Shoot, thumbnail string) string {
+
+	name := thumbnail
+	if shoot.Prefix != "" {
+		name = strings.TrimPrefix(name, strings.TrimSuffix(shoot.Prefix, "/")+"/")
+	}
+
+	if i := strings.Index(name, "/"); i >= 0 {
+		return name[:i]
+	}
+	return ""
+}
+
+// Lists the folders the photos in a shoot are in, in name order
+func shootCategories(shoot Shoot, thumbnails []string) []string {
+
+	seen := make(map[string]bool)
+	final := []string{}
+
+	for _, thumbnail := range thumbnails {
+		category := photoCategory(shoot, thumbnail)
+		if category != "" && !seen[category] {
+			seen[category] = true
+			final = append(final, category)
+		}
+	}
+	sort.Strings(final)
+
+	return final
+}
+
+// Sorts and filters a shoot's thumbnails the way a view asks for
+// favorites is how many guests favorited each photo, from viewFavorites
+func applyView(r *redis.Client, client *s3.S3, bucket string, shoot Shoot, thumbnails []string, favorites map[string]int, view GalleryView) []string {
+
+	var times map[string]time.Time
+	if view.needsCaptureTimes() {
+		times = captureTimes(r, client, bucket, shoot, thumbnails)
+	}
+
+	if view.Stack != "" {
+		thumbnails = photoStack(thumbnails, times, view.Stack)
+	}
+
+	picked := make(map[string]bool)
+	for _, key := range shoot.Picks.Picks {
+		picked[key] = true
+	}
+	commented := make(map[string]bool)
+	for _, comment := range shoot.Comments {
+		commented[comment.Photo] = true
+	}
+
+	final := []string{}
+	for _, thumbnail := range thumbnails {
+		key := photoKey(thumbnail)
+		switch {
+		case view.Category != "" && photoCategory(shoot, thumbnail) != view.Category:
+		case view.Filter == filterPicked && !picked[key]:
+		case view.Filter == filterUnpicked && picked[key]:
+		case view.Filter == filterCommented && !commented[key]:
+		case view.Filter == filterFavorited && favorites[key] == 0:
+		default:
+			final = append(final, thumbnail)
+		}
+	}
+	final = filterByRating(final, shoot.Ratings, view.MinRating)
+
+	switch view.Sort {
+	case sortName:
+		sort.SliceStable(final, func(i, j int) bool {
+			if photoKey(final[i]) != photoKey(final[j]) {
+				return photoKey(final[i]) < photoKey(final[j])
+			}
+			return final[i] < final[j]
+		})
+	case sortCaptured:
+		sortByCaptureTime(final, times)
+	case sortRating:
+		// Photos with the same rating stay in shoot order
+		sort.SliceStable(final, func(i, j int) bool {
+			return shoot.Ratings[photoKey(final[i])].Rating > shoot.Ratings[photoKey(final[j])].Rating
+		})
+	case sortFavorites:
+		sort.SliceStable(final, func(i, j int) bool {
+			return favorites[photoKey(final[i])] > favorites[photoKey(final[j])]
+		})
+	}
+
+	return final
+}
+
+// Sorts thumbnails oldest first, with photos whose capture time is not known at the end
+func sortByCaptureTime(thumbnails []string, times map[string]time.Time) {
+	sort.SliceStable(thumbnails, func(i, j int) bool {
+		a, b := times[thumbnails[i]], times[thumbnails[j]]
+		switch {
+		case a.IsZero() || b.IsZero():
+			return !a.IsZero() && b.IsZero()
+		case !a.Equal(b):
+			return a.Before(b)
+		default:
+			return thumbnails[i] < thumbnails[j]
+		}
+	})
+}
+
+// Returns the thumbnails of the frames stacked with a photo, oldest first
+// Frames are stacked when each was taken within stackGap of the one before
+func photoStack(thumbnails []string, times map[string]time.Time, key string) []string {
+
+	ordered := append([]string{}, thumbnails...)
+	sortByCaptureTime(ordered, times)
+
+	var stack []string
+	for i, thumbnail := range ordered {
+		taken := times[thumbnail]
+		if i == 0 || taken.IsZero() || times[ordered[i-1]].IsZero() || taken.Sub(times[ordered[i-1]]) > stackGap {
+			// A new stack starts here, so the last one is finished
+			if containsPhoto(stack, key) {
+				return stack
+			}
+			stack = nil
+		}
+		stack = append(stack, thumbnail)
+	}
+
+	if containsPhoto(stack, key) {
+		return stack
+	}
+	return []string{}
+}
+
+// Reports whether a photo is one of some thumbnails
+func containsPhoto(thumbnails []string, key string) bool {
+	for _, thumbnail := range thumbnails {
+		if photoKey(thumbnail) == key {
+			return true
+		}
+	}
+	return false
+}
+
+// Redis key the capture time of one photo is kept under, by its thumbnail key
+// Each photo has its own key so times expire with the photos they belong to instead of piling up
+func captureTimeKey(bucket string, thumbnail string) string {
+	return "captured:" + bucket + "/" + thumbnail
+}
+
+// Redis key a background read of a photo's capture time holds, so it is only read by one server at a time
+func captureClaimKey(bucket string, thumbnail string) string {
+	return "capturing:" + bucket + "/" + thumbnail
+}
+
+// Keeps when a photo was taken. ok is false when it could not be read, which is kept for less time
+// so a photo that can not be read is tried again now and then rather than on every request
+func rememberCaptureTime(r *redis.Client, bucket string, thumbnail string, taken time.Time, ok bool) {
+
+	value, ttl := "", captureFailureTTL
+	if ok {
+		value, ttl = taken.Format(time.RFC3339), captureTimeTTL
+	}
+
+	err := r.Set(captureTimeKey(bucket, thumbnail), value, ttl).Err()
+	if err != nil {
+		log.Printf("could not cache capture time of %v: %v", thumbnail, err)
+	}
+}
+
+// Returns when the photos in a shoot were taken, as far as is known yet
+// Times are read from the EXIF data of the originals when photos are uploaded through the API, or in the
+// background the first time a view needs them. Photos whose time is not known yet are left out
+func captureTimes(r *redis.Client, client *s3.S3, bucket string, shoot Shoot, thumbnails []string) map[string]time.Time {
+
+	final := make(map[string]time.Time)
+	if len(thumbnails) == 0 {
+		return final
+	}
+
+	keys := make([]string, len(thumbnails))
+	for i, thumbnail := range thumbnails {
+		keys[i] = captureTimeKey(bucket, thumbnail)
+	}
+
+	cached, err := r.MGet(keys...).Result()
+	if err != nil {
+		log.Printf("could not get cached capture times: %v", err)
+		return final
+	}
+
+	var missing []string
+	for i, thumbnail := range thumbnails {
+		value, ok := cached[i].(string)
+		if !ok {
+			missing = append(missing, thumbnail)
+			continue
+		}
+		if taken, err := time.Parse(time.RFC3339, value); err == nil {
+			final[thumbnail] = taken
+		}
+	}
+
+	if len(missing) > 0 {
+		go fillCaptureTimes(r, client, bucket, shoot, missing)
+	}
+
+	return final
+}
+
+// Reads the capture times of photos from their originals and keeps them in Redis
+// Runs in the background. Photos another server is already reading are skipped
+func fillCaptureTimes(r *redis.Client, client *s3.S3, bucket string, shoot Shoot, thumbnails []string) {
+
+	pipe := r.Pipeline()
+	claims := make([]*redis.BoolCmd, len(thumbnails))
+	for i, thumbnail := range thumbnails {
+		claims[i] = pipe.SetNX(captureClaimKey(bucket, thumbnail), "1", captureClaimTTL)
+	}
+	_, err := pipe.Exec()
+	if err != nil {
+		log.Printf("could not claim capture times to read: %v", err)
+		return
+	}
+
+	var claimed []string
+	for i, claim := range claims {
+		if claim.Val() {
+			claimed = append(claimed, thumbnails[i])
+		}
+	}
+	if len(claimed) == 0 {
+		return
+	}
+
+	originals, err := shootOriginals(client, bucket, shoot)
+	if err != nil {
+		log.Printf("could not read capture times: %v", err)
+		return
+	}
+
+	var wg sync.WaitGroup
+	jobs := make(chan string)
+
+	workers := captureWorkers
+	if len(claimed) < workers {
+		workers = len(claimed)
+	}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for thumbnail := range jobs {
+				// Thumbnails do not keep the EXIF data, so read the original when there is one
+				source := thumbnail
+				if original, exists := originals[thumbnail]; exists {
+					source = original
+				}
+				taken, err := readCaptureTime(client, bucket, source)
+				if err != nil {
+					log.Printf("could not read capture time of %v: %v", source, err)
+				}
+				rememberCaptureTime(r, bucket, thumbnail, taken, err == nil)
+			}
+		}()
+	}
+	for _, thumbnail := range claimed {
+		jobs <- thumbnail
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// Maps the thumbnails of a shoot to the originals they were made from
+func shootOriginals(client *s3.S3, bucket string, shoot Shoot) (map[string]string, error) {
+
+	final := make(map[string]string)
+
+	if len(shoot.Files) > 0 {
+		for _, file := range shoot.Files {
+			if !isRendition(file) {
+				final[thumbnailKey(file)] = file
+			}
+		}
+		return final, nil
+	}
+
+	objects, err := listOriginals(client, bucket, strings.TrimSuffix(shoot.Prefix, "/")+"/")
+	if err != nil {
+		return nil, fmt.Errorf("could not list originals: %v", err)
+	}
+	for _, object := range objects {
+		final[thumbnailKey(*object.Key)] = *object.Key
+	}
+
+	return final, nil
+}
+
+// Reads when a photo was taken from the start of it, falling back to when it was uploaded
+func readCaptureTime(client *s3.S3, bucket string, key string) (time.Time, error) {
+
+	object, err := client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Range:  aws.String(fmt.Sprintf("bytes=0-%v", exifReadBytes-1)),
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer object.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(object.Body, exifReadBytes))
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if taken, ok := exifCaptureTime(data); ok {
+		return taken, nil
+	}
+	if object.LastModified != nil {
+		return object.LastModified.UTC(), nil
+	}
+
+	return time.Time{}, fmt.Errorf("%v does not say when it was taken", key)
+}
+
+// Drops the capture time kept for a photo, for when the photo is deleted
+func forgetCaptureTime(r *redis.Client, bucket string, thumbnail string) {
+	err := r.Del(captureTimeKey(bucket, thumbnail)).Err()
+	if err != nil {
+		log.Printf("could not clear capture time of %v: %v", thumbnail, err)
+	}
+}
@@ -0,0 +1,564 @@
+// Package client is a Go client for the Client Photos JSON API
+// It logs in the same way the browser does, with a session cookie and a CSRF token, and returns the
+// same API types the server uses from the models package
+//
+// Example:
+//
+//	c, err := client.New("https://photos.example.com", nil)
+//	err = c.Login(ctx, "photographer", "password")
+//	shoots, _, err := c.As("smith").ListShoots(ctx, 1, 50)
+//
+// Scripts should use an API token from the account page instead of a password:
+//
+//	c, err := client.New("https://photos.example.com", nil)
+//	c = c.WithToken(os.Getenv("CLIENT_PHOTOS_TOKEN"))
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"main/export"
+	"main/models"
+)
+
+// Header the server expects the CSRF token in
+const csrfHeader = "X-CSRF-Token"
+
+// Path the JSON API is mounted at
+const apiPath = "/api/v1"
+
+// Returned by Login when the account has 2FA turned on
+// Finish logging in by passing Challenge and a code to LoginTwoFactor
+type TwoFactorRequiredError struct {
+	Challenge string
+	Enroll    bool // The account has to set up 2FA in a browser before it can log in
+}
+
+func (e *TwoFactorRequiredError) Error() string {
+	if e.Enroll {
+		return "account must set up two-factor authentication in a browser before logging in"
+	}
+	return "two-factor code required"
+}
+
+// An error response from the server
+// For the JSON API Code is the error code from the error envelope. Example: "not_found"
+type Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+	Details    json.RawMessage // Extra information some errors carry. Example: the rejected keys when setting picks
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%v %v: %v", e.StatusCode, e.Code, e.Message)
+}
+
+// Reports whether an error from the client is a 404 from the server
+func IsNotFound(err error) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// State shared between a Client and the copies As makes of it
+type session struct {
+	mu        sync.Mutex
+	csrfToken string
+}
+
+// Talks to one server as one logged in user
+// Safe for use by multiple goroutines
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	owner      string // Username whose shoots requests are about, empty for the logged in user
+	token      string // API token sent as a bearer token, empty to use the session cookie
+	session    *session
+}
+
+// Creates a client for the server at baseURL. Example: "https://photos.example.com"
+// httpClient may be nil to use a default client. If it does not have a cookie jar one is added
+// because the session lives in a cookie
+func New(baseURL string, httpClient *http.Client) (*Client, error) {
+
+	if _, err := url.Parse(baseURL); err != nil {
+		return nil, fmt.Errorf("invalid base url: %v", err)
+	}
+
+	if httpClient == nil {
+		httpClient = &http.Client{}
+	}
+	if httpClient.Jar == nil {
+		jar, err := cookiejar.New(nil)
+		if err != nil {
+			return nil, fmt.Errorf("could not create cookie jar: %v", err)
+		}
+		copied := *httpClient
+		copied.Jar = jar
+		httpClient = &copied
+	}
+
+	return &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		httpClient: httpClient,
+		session:    &session{},
+	}, nil
+}
+
+// Returns a copy of the client that works on another user's shoots
+// Only photographers can do this. The copy shares the login of the original
+func (c *Client) As(owner string) *Client {
+	copied := *c
+	copied.owner = owner
+	return &copied
+}
+
+// Returns a copy of the client that authenticates with an API token instead of logging in
+// Tokens are made on the account page. Requests can only do what the token's scopes allow
+func (c *Client) WithToken(token string) *Client {
+	copied := *c
+	copied.token = token
+	return &copied
+}
+
+// Gets a CSRF token from the server if the client does not have one yet
+func (c *Client) csrf(ctx context.Context) (string, error) {
+
+	c.session.mu.Lock()
+	token := c.session.csrfToken
+	c.session.mu.Unlock()
+	if token != "" {
+		return token, nil
+	}
+
+	var resource models.CSRFResource
+	err := c.do(ctx, http.MethodGet, apiPath+"/csrf", nil, nil, "", &resource)
+	if err != nil {
+		return "", fmt.Errorf("could not get CSRF token: %v", err)
+	}
+
+	c.session.mu.Lock()
+	c.session.csrfToken = resource.Token
+	c.session.mu.Unlock()
+
+	return resource.Token, nil
+}
+
+// Sends a request and decodes the response into out, which may be nil
+// For JSON API paths out is filled from the data field of the response
+func (c *Client) do(ctx context.Context, method string, path string, query url.Values, body any, contentType string, out any) error {
+
+	raw, err := c.send(ctx, method, path, query, body, contentType)
+	if err != nil || out == nil {
+		return err
+	}
+
+	if !strings.HasPrefix(path, apiPath) {
+		return json.Unmarshal(raw, out)
+	}
+
+	var envelope struct {
+		Data json.RawMessage `json:"data"`
+	}
+	err = json.Unmarshal(raw, &envelope)
+	if err != nil {
+		return fmt.Errorf("could not unmarshal response: %v", err)
+	}
+
+	return json.Unmarshal(envelope.Data, out)
+}
+
+// Sends a request and returns the response body
+// body is sent as JSON unless contentType is set, in which case it must be an io.Reader
+// Responses with an error status are returned as an *Error
+func (c *Client) send(ctx context.Context, method string, path string, query url.Values, body any, contentType string) ([]byte, error) {
+
+	response, err := c.open(ctx, method, path, query, body, contentType)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	raw, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("could not read response: %v", err)
+	}
+
+	return raw, nil
+}
+
+// Sends a request and returns the response with its body still to be read, for large downloads
+// The caller has to close the body. Responses with an error status are returned as an *Error
+func (c *Client) open(ctx context.Context, method string, path string, query url.Values, body any, contentType string) (*http.Response, error) {
+
+	var reader io.Reader
+	if body != nil {
+		if contentType == "" {
+			encoded, err := json.Marshal(body)
+			if err != nil {
+				return nil, fmt.Errorf("could not marshal request: %v", err)
+			}
+			reader = bytes.NewReader(encoded)
+			contentType = "application/json"
+		} else {
+			reader = body.(io.Reader)
+		}
+	}
+
+	if c.owner != "" && strings.HasPrefix(path, apiPath+"/shoots") {
+		if query == nil {
+			query = url.Values{}
+		}
+		query.Set("owner", c.owner)
+	}
+
+	target := c.baseURL + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Accept", "application/json")
+	if contentType != "" {
+		request.Header.Set("Content-Type", contentType)
+	}
+	if c.token != "" && strings.HasPrefix(path, apiPath) {
+		// Token requests do not use cookies so they do not need a CSRF token either
+		request.Header.Set("Authorization", "Bearer "+c.token)
+	} else if method != http.MethodGet && method != http.MethodHead {
+		token, err := c.csrf(ctx)
+		if err != nil {
+			return nil, err
+		}
+		request.Header.Set(csrfHeader, token)
+	}
+
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode >= 300 {
+		defer response.Body.Close()
+		raw, err := io.ReadAll(response.Body)
+		if err != nil {
+			return nil, fmt.Errorf("could not read response: %v", err)
+		}
+		return nil, decodeError(response.StatusCode, raw)
+	}
+
+	return response, nil
+}
+
+// Turns an error response into an *Error
+// Understands both the JSON API's error envelope and the {"status": message} errors of the page routes
+func decodeError(status int, raw []byte) error {
+
+	apiErr := &Error{StatusCode: status, Code: strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")}
+
+	var envelope struct {
+		Error *struct {
+			Code    string          `json:"code"`
+			Message string          `json:"message"`
+			Details json.RawMessage `json:"details"`
+		} `json:"error"`
+		Status string `json:"status"`
+	}
+	if json.Unmarshal(raw, &envelope) == nil {
+		if envelope.Error != nil {
+			apiErr.Code = envelope.Error.Code
+			apiErr.Message = envelope.Error.Message
+			apiErr.Details = envelope.Error.Details
+		} else {
+			apiErr.Message = envelope.Status
+		}
+	}
+	if apiErr.Message == "" {
+		apiErr.Message = http.StatusText(status)
+	}
+
+	return apiErr
+}
+
+// Logs in with a username and password
+// Returns a *TwoFactorRequiredError if the account uses 2FA
+func (c *Client) Login(ctx context.Context, username string, password string) error {
+
+	var response struct {
+		Accepted  bool   `json:"accepted"`
+		TwoFactor bool   `json:"twoFactor"`
+		Enroll    bool   `json:"enroll"`
+		Challenge string `json:"challenge"`
+	}
+
+	err := c.do(ctx, http.MethodPost, "/signin", nil, map[string]string{
+		"username": username,
+		"password": password,
+	}, "", &response)
+	if err != nil {
+		return err
+	}
+
+	if response.Challenge != "" {
+		return &TwoFactorRequiredError{Challenge: response.Challenge, Enroll: response.Enroll}
+	}
+	if !response.Accepted {
+		return errors.New("login was not accepted")
+	}
+
+	return nil
+}
+
+// Finishes logging in to an account with 2FA
+// challenge comes from the *TwoFactorRequiredError Login returned, code is a TOTP or recovery code
+func (c *Client) LoginTwoFactor(ctx context.Context, challenge string, code string) error {
+	return c.do(ctx, http.MethodPost, "/signin/2fa", nil, map[string]string{
+		"challenge": challenge,
+		"code":      code,
+	}, "", nil)
+}
+
+// Returns the logged in user
+func (c *Client) CurrentUser(ctx context.Context) (models.UserResource, error) {
+	var user models.UserResource
+	err := c.do(ctx, http.MethodGet, apiPath+"/users/me", nil, nil, "", &user)
+	return user, err
+}
+
+// Query string for a paginated request
+func pageQuery(page int, perPage int) url.Values {
+	query := url.Values{}
+	if page > 0 {
+		query.Set("page", strconv.Itoa(page))
+	}
+	if perPage > 0 {
+		query.Set("per_page", strconv.Itoa(perPage))
+	}
+	return query
+}
+
+// Gets one page of a list from the JSON API
+func (c *Client) list(ctx context.Context, path string, page int, perPage int, out any) (models.Pagination, error) {
+
+	var response struct {
+		Data       json.RawMessage   `json:"data"`
+		Pagination models.Pagination `json:"pagination"`
+	}
+
+	raw, err := c.send(ctx, http.MethodGet, path, pageQuery(page, perPage), nil, "")
+	if err != nil {
+		return models.Pagination{}, err
+	}
+
+	err = json.Unmarshal(raw, &response)
+	if err != nil {
+		return models.Pagination{}, fmt.Errorf("could not unmarshal response: %v", err)
+	}
+
+	return response.Pagination, json.Unmarshal(response.Data, out)
+}
+
+// Lists one page of shoots. page starts at 1, 0 for either argument uses the server's default
+func (c *Client) ListShoots(ctx context.Context, page int, perPage int) ([]models.ShootResource, models.Pagination, error) {
+	var shoots []models.ShootResource
+	pagination, err := c.list(ctx, apiPath+"/shoots", page, perPage, &shoots)
+	return shoots, pagination, err
+}
+
+// Lists every shoot, fetching as many pages as it takes
+func (c *Client) AllShoots(ctx context.Context) ([]models.ShootResource, error) {
+
+	var final []models.ShootResource
+
+	for page := 1; ; page++ {
+		shoots, pagination, err := c.ListShoots(ctx, page, 200)
+		if err != nil {
+			return nil, err
+		}
+		final = append(final, shoots...)
+		if page >= pagination.TotalPages {
+			return final, nil
+		}
+	}
+}
+
+// Gets one shoot by id
+func (c *Client) GetShoot(ctx context.Context, id string) (models.ShootResource, error) {
+	var shoot models.ShootResource
+	err := c.do(ctx, http.MethodGet, apiPath+"/shoots/"+url.PathEscape(id), nil, nil, "", &shoot)
+	return shoot, err
+}
+
+// Creates a shoot. The server picks the id from the name and returns it on the shoot
+func (c *Client) CreateShoot(ctx context.Context, shoot models.ShootRequest) (models.ShootResource, error) {
+	var created models.ShootResource
+	err := c.do(ctx, http.MethodPost, apiPath+"/shoots", nil, shoot, "", &created)
+	return created, err
+}
+
+// Renames a shoot, changes its date or sets its cover photo. Fields left nil are not changed
+func (c *Client) UpdateShoot(ctx context.Context, id string, update models.ShootUpdate) (models.ShootResource, error) {
+	var shoot models.ShootResource
+	err := c.do(ctx, http.MethodPatch, apiPath+"/shoots/"+url.PathEscape(id), nil, update, "", &shoot)
+	return shoot, err
+}
+
+// Deletes a shoot. With purge set the photos stored for it are deleted too, which can not be undone
+func (c *Client) DeleteShoot(ctx context.Context, id string, purge bool) error {
+	query := url.Values{"purge": {strconv.FormatBool(purge)}}
+	return c.do(ctx, http.MethodDelete, apiPath+"/shoots/"+url.PathEscape(id), query, nil, "", nil)
+}
+
+// Sets how shoots are ordered on the home page and in ListShoots
+func (c *Client) SetShootOrder(ctx context.Context, order models.ShootOrder) (models.ShootOrder, error) {
+	var saved models.ShootOrder
+	err := c.do(ctx, http.MethodPut, apiPath+"/shoots/order", nil, order, "", &saved)
+	return saved, err
+}
+
+// Lists one page of the photos in a shoot, with pre-signed urls
+func (c *Client) ListPhotos(ctx context.Context, id string, page int, perPage int) ([]models.PhotoResource, models.Pagination, error) {
+	var photos []models.PhotoResource
+	pagination, err := c.list(ctx, apiPath+"/shoots/"+url.PathEscape(id)+"/photos", page, perPage, &photos)
+	return photos, pagination, err
+}
+
+// Uploads a JPEG to a shoot
+// name is the file name to store it under. Example: "IMG_0001.jpg"
+func (c *Client) UploadPhoto(ctx context.Context, id string, name string, photo io.Reader) (models.PhotoResource, error) {
+
+	// Stream the form so large photos are not held in memory
+	pipeReader, pipeWriter := io.Pipe()
+	form := multipart.NewWriter(pipeWriter)
+
+	go func() {
+		part, err := form.CreateFormFile("file", name)
+		if err == nil {
+			_, err = io.Copy(part, photo)
+		}
+		if err == nil {
+			err = form.Close()
+		}
+		pipeWriter.CloseWithError(err)
+	}()
+
+	var uploaded models.PhotoResource
+	err := c.do(ctx, http.MethodPost, apiPath+"/shoots/"+url.PathEscape(id)+"/photos", nil, pipeReader, form.FormDataContentType(), &uploaded)
+	pipeReader.Close()
+
+	return uploaded, err
+}
+
+// Sets the star rating and color label of a photo. A rating of 0 with no label clears them
+// Returns the rating as the server saved it, with the label spelled the way Lightroom does
+func (c *Client) SetRating(ctx context.Context, id string, key string, rating models.PhotoRating) (models.PhotoRating, error) {
+	var saved models.PhotoRating
+	err := c.do(ctx, http.MethodPut, apiPath+"/shoots/"+url.PathEscape(id)+"/photos/"+url.PathEscape(key)+"/rating", nil, rating, "", &saved)
+	return saved, err
+}
+
+// Gets the picks for a shoot
+func (c *Client) GetPicks(ctx context.Context, id string) (models.Picks, error) {
+	var picks models.Picks
+	err := c.do(ctx, http.MethodGet, apiPath+"/shoots/"+url.PathEscape(id)+"/picks", nil, nil, "", &picks)
+	return picks, err
+}
+
+// Replaces the picks for a shoot
+// Returns the picks as the server saved them. If some keys are not in the shoot the *Error's
+// Details lists them and nothing is saved
+func (c *Client) SetPicks(ctx context.Context, id string, keys []string) (models.Picks, error) {
+	var picks models.Picks
+	err := c.do(ctx, http.MethodPut, apiPath+"/shoots/"+url.PathEscape(id)+"/picks", nil, models.Picks{Count: len(keys), Picks: keys}, "", &picks)
+	return picks, err
+}
+
+// Exports the picks for a shoot in one of the export package's formats, ready to save to a file
+// options only matter for export.FormatXMP, which returns a zip of sidecars
+func (c *Client) ExportPicks(ctx context.Context, id string, format string, options export.Options) ([]byte, error) {
+	query := url.Values{
+		"format": {format},
+		"rating": {strconv.Itoa(options.Rating)},
+		"label":  {options.Label},
+	}
+	return c.send(ctx, http.MethodGet, apiPath+"/shoots/"+url.PathEscape(id)+"/export", query, nil, "")
+}
+
+// Downloads a zip of the originals in a shoot to w without holding it in memory
+// scope is "picks", "delivered" or "category", in which case category names the folder. Returns the bytes written
+func (c *Client) DownloadPhotos(ctx context.Context, id string, scope string, category string, w io.Writer) (int64, error) {
+
+	query := url.Values{"scope": {scope}}
+	if category != "" {
+		query.Set("category", category)
+	}
+
+	response, err := c.open(ctx, http.MethodGet, apiPath+"/shoots/"+url.PathEscape(id)+"/download", query, nil, "")
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	written, err := io.Copy(w, response.Body)
+	if err == nil && response.ContentLength >= 0 && written != response.ContentLength {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return written, err
+}
+
+// Lists one page of the comments on a shoot, oldest first
+func (c *Client) ListComments(ctx context.Context, id string, page int, perPage int) ([]models.Comment, models.Pagination, error) {
+	var comments []models.Comment
+	pagination, err := c.list(ctx, apiPath+"/shoots/"+url.PathEscape(id)+"/comments", page, perPage, &comments)
+	return comments, pagination, err
+}
+
+// Comments on a shoot, or on one photo in it if photo is not empty
+func (c *Client) AddComment(ctx context.Context, id string, photo string, text string) (models.Comment, error) {
+	var comment models.Comment
+	err := c.do(ctx, http.MethodPost, apiPath+"/shoots/"+url.PathEscape(id)+"/comments", nil, models.CommentRequest{Photo: photo, Text: text}, "", &comment)
+	return comment, err
+}
+
+// Lists one page of the photos guests on share links favorited, most favorited first
+func (c *Client) ListFavorites(ctx context.Context, id string, page int, perPage int) ([]models.FavoriteResource, models.Pagination, error) {
+	var favorites []models.FavoriteResource
+	pagination, err := c.list(ctx, apiPath+"/shoots/"+url.PathEscape(id)+"/favorites", page, perPage, &favorites)
+	return favorites, pagination, err
+}
+
+// Lists one page of the share links on a shoot
+func (c *Client) ListShares(ctx context.Context, id string, page int, perPage int) ([]models.ShareResource, models.Pagination, error) {
+	var shares []models.ShareResource
+	pagination, err := c.list(ctx, apiPath+"/shoots/"+url.PathEscape(id)+"/shares", page, perPage, &shares)
+	return shares, pagination, err
+}
+
+// Makes a link that lets people without an account look at a shoot
+// The link is in the URL of the returned share and can not be got again
+func (c *Client) CreateShare(ctx context.Context, id string, share models.ShareRequest) (models.ShareResource, error) {
+	var created models.ShareResource
+	err := c.do(ctx, http.MethodPost, apiPath+"/shoots/"+url.PathEscape(id)+"/shares", nil, share, "", &created)
+	return created, err
+}
+
+// Revokes a share link so it stops working straight away
+func (c *Client) RevokeShare(ctx context.Context, id string, shareID string) error {
+	return c.do(ctx, http.MethodDelete, apiPath+"/shoots/"+url.PathEscape(id)+"/shares/"+url.PathEscape(shareID), nil, nil, "", nil)
+}
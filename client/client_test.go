@@ -0,0 +1,333 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"main/models"
+)
+
+// A stand in for the server that only knows the routes a test registers
+// Requests that change something must carry the session's CSRF token, like on the real server
+type fakeServer struct {
+	t      *testing.T
+	mux    *http.ServeMux
+	server *httptest.Server
+	csrf   int // Number of times a CSRF token was handed out
+}
+
+const (
+	fakeCSRFToken = "csrf-token"
+	fakeSession   = "session-value"
+)
+
+func newFakeServer(t *testing.T) *fakeServer {
+
+	f := &fakeServer{t: t, mux: http.NewServeMux()}
+
+	f.mux.HandleFunc(apiPath+"/csrf", func(w http.ResponseWriter, r *http.Request) {
+		f.csrf++
+		writeJSON(w, http.StatusOK, map[string]any{"data": models.CSRFResource{Token: fakeCSRFToken}})
+	})
+
+	f.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Header.Get("Authorization") == "" && r.Header.Get(csrfHeader) != fakeCSRFToken {
+			writeJSON(w, http.StatusForbidden, map[string]string{"status": "invalid CSRF token"})
+			return
+		}
+		f.mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(f.server.Close)
+
+	return f
+}
+
+func (f *fakeServer) client() *Client {
+	c, err := New(f.server.URL, nil)
+	if err != nil {
+		f.t.Fatalf("New: %v", err)
+	}
+	return c
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, code string, message string, details any) {
+	writeJSON(w, status, map[string]any{"error": map[string]any{"code": code, "message": message, "details": details}})
+}
+
+// Only lets requests through that carry the session cookie from /signin
+func requireSession(w http.ResponseWriter, r *http.Request) bool {
+	cookie, err := r.Cookie("session")
+	if err != nil || cookie.Value != fakeSession {
+		writeAPIError(w, http.StatusUnauthorized, "unauthorized", "not logged in", nil)
+		return false
+	}
+	return true
+}
+
+func TestLoginKeepsSession(t *testing.T) {
+
+	f := newFakeServer(t)
+	f.mux.HandleFunc("/signin", func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		if body["username"] != "smith" || body["password"] != "secret" {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"status": "wrong username or password"})
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: fakeSession, Path: "/"})
+		writeJSON(w, http.StatusOK, map[string]bool{"accepted": true})
+	})
+	f.mux.HandleFunc(apiPath+"/users/me", func(w http.ResponseWriter, r *http.Request) {
+		if !requireSession(w, r) {
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"data": models.UserResource{Username: "smith", Role: "client"}})
+	})
+
+	c := f.client()
+	ctx := context.Background()
+
+	err := c.Login(ctx, "smith", "wrong")
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized || apiErr.Message != "wrong username or password" {
+		t.Fatalf("Login with the wrong password returned %v", err)
+	}
+
+	err = c.Login(ctx, "smith", "secret")
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	if f.csrf != 1 {
+		t.Errorf("CSRF token was fetched %v times, want 1", f.csrf)
+	}
+
+	user, err := c.CurrentUser(ctx)
+	if err != nil {
+		t.Fatalf("CurrentUser: %v", err)
+	}
+	if user.Username != "smith" || user.Role != "client" {
+		t.Errorf("CurrentUser = %+v", user)
+	}
+}
+
+func TestLoginTwoFactor(t *testing.T) {
+
+	f := newFakeServer(t)
+	f.mux.HandleFunc("/signin", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{"twoFactor": true, "challenge": "challenge-token"})
+	})
+	f.mux.HandleFunc("/signin/2fa", func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		if body["challenge"] != "challenge-token" || body["code"] != "123456" {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"status": "invalid code"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]bool{"accepted": true})
+	})
+
+	c := f.client()
+	ctx := context.Background()
+
+	err := c.Login(ctx, "smith", "secret")
+	var required *TwoFactorRequiredError
+	if !errors.As(err, &required) {
+		t.Fatalf("Login returned %v, want a *TwoFactorRequiredError", err)
+	}
+	if required.Enroll {
+		t.Errorf("Enroll is set for an account that already has 2FA")
+	}
+
+	err = c.LoginTwoFactor(ctx, required.Challenge, "123456")
+	if err != nil {
+		t.Fatalf("LoginTwoFactor: %v", err)
+	}
+}
+
+func TestWithTokenSkipsCSRF(t *testing.T) {
+
+	f := newFakeServer(t)
+	f.mux.HandleFunc(apiPath+"/shoots/wedding/picks", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer cpt.token" {
+			writeAPIError(w, http.StatusUnauthorized, "unauthorized", "missing token", nil)
+			return
+		}
+		var picks models.Picks
+		json.NewDecoder(r.Body).Decode(&picks)
+		writeJSON(w, http.StatusOK, map[string]any{"data": picks})
+	})
+
+	c := f.client().WithToken("cpt.token")
+
+	picks, err := c.SetPicks(context.Background(), "wedding", []string{"IMG_0001", "IMG_0002"})
+	if err != nil {
+		t.Fatalf("SetPicks: %v", err)
+	}
+	if picks.Count != 2 {
+		t.Errorf("SetPicks saved %v picks, want 2", picks.Count)
+	}
+	if f.csrf != 0 {
+		t.Errorf("CSRF token was fetched for a token request")
+	}
+}
+
+func TestErrorEnvelope(t *testing.T) {
+
+	f := newFakeServer(t)
+	f.mux.HandleFunc(apiPath+"/shoots/missing", func(w http.ResponseWriter, r *http.Request) {
+		writeAPIError(w, http.StatusNotFound, "not_found", "shoot not found", nil)
+	})
+	f.mux.HandleFunc(apiPath+"/shoots/wedding/picks", func(w http.ResponseWriter, r *http.Request) {
+		writeAPIError(w, http.StatusUnprocessableEntity, "invalid_picks", "some picks are not in the shoot",
+			[]models.RejectedPick{{Key: "IMG_9999", Reason: "not in shoot"}})
+	})
+
+	c := f.client().WithToken("cpt.token")
+	ctx := context.Background()
+
+	_, err := c.GetShoot(ctx, "missing")
+	if !IsNotFound(err) {
+		t.Errorf("GetShoot of a missing shoot returned %v, want a 404", err)
+	}
+
+	_, err = c.SetPicks(ctx, "wedding", []string{"IMG_9999"})
+	var apiErr *Error
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("SetPicks returned %v, want an *Error", err)
+	}
+	if apiErr.Code != "invalid_picks" {
+		t.Errorf("Code = %q, want invalid_picks", apiErr.Code)
+	}
+	var rejected []models.RejectedPick
+	if json.Unmarshal(apiErr.Details, &rejected) != nil || len(rejected) != 1 || rejected[0].Key != "IMG_9999" {
+		t.Errorf("Details = %s", apiErr.Details)
+	}
+}
+
+func TestAsAddsOwner(t *testing.T) {
+
+	f := newFakeServer(t)
+	f.mux.HandleFunc(apiPath+"/shoots/wedding", func(w http.ResponseWriter, r *http.Request) {
+		// Echo the owner back in the name so the test can see what was sent
+		writeJSON(w, http.StatusOK, map[string]any{"data": models.ShootResource{ID: "wedding", Name: r.URL.Query().Get("owner")}})
+	})
+
+	c := f.client().WithToken("cpt.token")
+	ctx := context.Background()
+
+	shoot, err := c.As("smith").GetShoot(ctx, "wedding")
+	if err != nil {
+		t.Fatalf("GetShoot: %v", err)
+	}
+	if shoot.Name != "smith" {
+		t.Errorf("owner sent as %q, want smith", shoot.Name)
+	}
+
+	shoot, err = c.GetShoot(ctx, "wedding")
+	if err != nil {
+		t.Fatalf("GetShoot: %v", err)
+	}
+	if shoot.Name != "" {
+		t.Errorf("As changed the original client, owner sent as %q", shoot.Name)
+	}
+}
+
+func TestAllShootsPages(t *testing.T) {
+
+	const total = 5
+	const perPage = 2
+
+	f := newFakeServer(t)
+	f.mux.HandleFunc(apiPath+"/shoots", func(w http.ResponseWriter, r *http.Request) {
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		var shoots []models.ShootResource
+		for i := (page - 1) * perPage; i < page*perPage && i < total; i++ {
+			shoots = append(shoots, models.ShootResource{ID: "shoot-" + strconv.Itoa(i)})
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"data":       shoots,
+			"pagination": models.Pagination{Page: page, PerPage: perPage, Total: total, TotalPages: (total + perPage - 1) / perPage},
+		})
+	})
+
+	shoots, err := f.client().WithToken("cpt.token").AllShoots(context.Background())
+	if err != nil {
+		t.Fatalf("AllShoots: %v", err)
+	}
+	if len(shoots) != total {
+		t.Fatalf("AllShoots returned %v shoots, want %v", len(shoots), total)
+	}
+	for i, shoot := range shoots {
+		if shoot.ID != "shoot-"+strconv.Itoa(i) {
+			t.Errorf("shoot %v is %v", i, shoot.ID)
+		}
+	}
+}
+
+func TestUploadPhoto(t *testing.T) {
+
+	photo := bytes.Repeat([]byte{0xff, 0xd8}, 1024)
+
+	f := newFakeServer(t)
+	f.mux.HandleFunc(apiPath+"/shoots/wedding/photos", func(w http.ResponseWriter, r *http.Request) {
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "bad_request", err.Error(), nil)
+			return
+		}
+		defer file.Close()
+		uploaded, _ := io.ReadAll(file)
+		if !bytes.Equal(uploaded, photo) {
+			writeAPIError(w, http.StatusBadRequest, "bad_request", "photo was changed in transit", nil)
+			return
+		}
+		writeJSON(w, http.StatusCreated, map[string]any{"data": models.PhotoResource{Key: strings.TrimSuffix(header.Filename, ".jpg")}})
+	})
+
+	uploaded, err := f.client().WithToken("cpt.token").UploadPhoto(context.Background(), "wedding", "IMG_0001.jpg", bytes.NewReader(photo))
+	if err != nil {
+		t.Fatalf("UploadPhoto: %v", err)
+	}
+	if uploaded.Key != "IMG_0001" {
+		t.Errorf("uploaded key = %q", uploaded.Key)
+	}
+}
+
+func TestDownloadPhotos(t *testing.T) {
+
+	archive := bytes.Repeat([]byte("zip"), 4096)
+
+	f := newFakeServer(t)
+	f.mux.HandleFunc(apiPath+"/shoots/wedding/download", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("scope") != "picks" {
+			writeAPIError(w, http.StatusBadRequest, "bad_request", "unknown scope", nil)
+			return
+		}
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Length", strconv.Itoa(len(archive)))
+		w.Write(archive)
+	})
+
+	var out bytes.Buffer
+	written, err := f.client().WithToken("cpt.token").DownloadPhotos(context.Background(), "wedding", "picks", "", &out)
+	if err != nil {
+		t.Fatalf("DownloadPhotos: %v", err)
+	}
+	if written != int64(len(archive)) || !bytes.Equal(out.Bytes(), archive) {
+		t.Errorf("DownloadPhotos wrote %v bytes, want %v", written, len(archive))
+	}
+}
@@ -0,0 +1,272 @@
+// Package export turns a shoot's picks into files Lightroom can use
+// The server and the exporter CLI share it so an export looks the same wherever it is made
+package export
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+
+	"main/models"
+)
+
+// Formats a shoot's picks can be exported in
+const (
+	FormatFilenames = "filenames" // One line to paste into Lightroom's Filename contains filter
+	FormatCSV       = "csv"       // One row per pick with the shoot details and any comments on the photo
+	FormatXMP       = "xmp"       // Zip of XMP sidecars to drop next to the RAW files
+)
+
+// Every format, in the order they are offered
+var Formats = []string{FormatFilenames, FormatCSV, FormatXMP}
+
+// Color labels Lightroom's default label set understands
+var Labels = []string{"Red", "Yellow", "Green", "Blue", "Purple"}
+
+// Highest star rating Lightroom shows
+const MaxRating = 5
+
+// Rating and label the sidecars give picked photos when none are asked for
+const (
+	DefaultRating = 5
+	DefaultLabel  = "Green"
+)
+
+// Everything an export needs to know about a shoot
+type Shoot struct {
+	ID          string
+	Name        string
+	SubmittedAt string
+	Picks       []string                      // Photo keys in the order they were picked. Example: "IMG_0001"
+	Comments    []models.Comment              // Comments on photos are added to their CSV row, comments on the whole shoot are left out
+	Ratings     map[string]models.PhotoRating // Stars and labels the client gave photos, by key
+}
+
+// How picked photos are marked in the XMP sidecars
+// A photo the client rated or labelled keeps its own rating or label, these fill in the rest
+type Options struct {
+	Rating int    // Stars from 0 to 5, 0 leaves the rating out
+	Label  string // One of Labels, empty leaves the label out
+}
+
+// Reports whether a format is one the picks can be exported in
+func ValidFormat(format string) bool {
+	for _, each := range Formats {
+		if format == each {
+			return true
+		}
+	}
+	return false
+}
+
+// Checks the rating and label are ones Lightroom will show, and that at least one of them is set
+// Labels are matched without caring about case and returned the way Lightroom spells them
+func (o Options) Validate() (Options, error) {
+
+	if o.Rating < 0 || o.Rating > MaxRating {
+		return Options{}, fmt.Errorf("rating must be from 0 to %v", MaxRating)
+	}
+
+	if o.Label == "" {
+		if o.Rating == 0 {
+			return Options{}, errors.New("a rating or a label is needed to mark the picks with")
+		}
+		return o, nil
+	}
+
+	label, ok := ValidLabel(o.Label)
+	if !ok {
+		return Options{}, fmt.Errorf("label must be one of %v", strings.Join(Labels, ", "))
+	}
+	o.Label = label
+
+	return o, nil
+}
+
+// Matches a color label against Labels without caring about case
+// Returns the label the way Lightroom spells it and whether it is one of Labels
+func ValidLabel(label string) (string, bool) {
+	for _, each := range Labels {
+		if strings.EqualFold(label, each) {
+			return each, true
+		}
+	}
+	return "", false
+}
+
+// Turns a photo key into the file name Lightroom knows it by, without the extension
+// so it matches the RAW file and any JPEG made from it. Example: "IMG_0001.jpg" becomes "IMG_0001"
+func BaseName(key string) string {
+	return strings.TrimSuffix(key, path.Ext(key))
+}
+
+// File name of the XMP sidecar for a photo. Example: "IMG_0001.xmp"
+func SidecarName(key string) string {
+	return BaseName(key) + ".xmp"
+}
+
+// Name to save an export as. Example: "smith-wedding-picks.csv"
+func FileName(shootID string, format string) string {
+	switch format {
+	case FormatCSV:
+		return shootID + "-picks.csv"
+	case FormatXMP:
+		return shootID + "-xmp.zip"
+	default:
+		return shootID + "-picks.txt"
+	}
+}
+
+// Content type of an export
+func ContentType(format string) string {
+	switch format {
+	case FormatCSV:
+		return "text/csv; charset=utf-8"
+	case FormatXMP:
+		return "application/zip"
+	default:
+		return "text/plain; charset=utf-8"
+	}
+}
+
+// Writes a shoot's picks in one of the export formats
+func Write(w io.Writer, format string, shoot Shoot, options Options) error {
+	switch format {
+	case FormatFilenames:
+		return WriteFilenames(w, shoot)
+	case FormatCSV:
+		return WriteCSV(w, shoot)
+	case FormatXMP:
+		return WriteSidecars(w, shoot, options)
+	default:
+		return fmt.Errorf("unknown export format %q", format)
+	}
+}
+
+// Writes the picks as one comma separated line
+// Pasted into Lightroom's Library Filter as Filename contains, it shows just the picked photos
+// Example: "IMG_0001, IMG_0004, IMG_0010"
+func WriteFilenames(w io.Writer, shoot Shoot) error {
+
+	names := make([]string, 0, len(shoot.Picks))
+	for _, key := range shoot.Picks {
+		names = append(names, BaseName(key))
+	}
+
+	_, err := io.WriteString(w, strings.Join(names, ", ")+"\n")
+	return err
+}
+
+// Writes one CSV row per pick
+// Rating and label come last so spreadsheets made from older exports still line up
+func WriteCSV(w io.Writer, shoot Shoot) error {
+
+	comments := make(map[string][]string)
+	for _, comment := range shoot.Comments {
+		if comment.Photo != "" {
+			comments[comment.Photo] = append(comments[comment.Photo], comment.Author+": "+comment.Text)
+		}
+	}
+
+	writer := csv.NewWriter(w)
+
+	err := writer.Write([]string{"position", "filename", "key", "shoot_id", "shoot_name", "submitted_at", "comments", "rating", "label"})
+	if err != nil {
+		return err
+	}
+
+	for i, key := range shoot.Picks {
+		rating := ""
+		if stars := shoot.Ratings[key].Rating; stars > 0 {
+			rating = strconv.Itoa(stars)
+		}
+		err = writer.Write([]string{
+			strconv.Itoa(i + 1),
+			BaseName(key),
+			key,
+			shoot.ID,
+			csvSafe(shoot.Name),
+			shoot.SubmittedAt,
+			csvSafe(strings.Join(comments[key], " | ")),
+			rating,
+			shoot.Ratings[key].Label,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// Stops text clients typed from being run as a formula when the CSV is opened in a spreadsheet
+func csvSafe(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// Builds an XMP sidecar marking a photo with a rating and color label
+// Lightroom reads it when Metadata > Read Metadata from Files is used on the RAW next to it
+func Sidecar(options Options) []byte {
+
+	var attributes strings.Builder
+	if options.Rating > 0 {
+		fmt.Fprintf(&attributes, "\n    xmp:Rating=\"%v\"", options.Rating)
+	}
+	if options.Label != "" {
+		fmt.Fprintf(&attributes, "\n    xmp:Label=\"%v\"", options.Label)
+	}
+
+	return []byte(`<?xpacket begin="` + "\ufeff" + `" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about=""
+    xmlns:xmp="http://ns.adobe.com/xap/1.0/"` + attributes.String() + `/>
+ </rdf:RDF>
+</x:xmpmeta>
+<?xpacket end="w"?>
+`)
+}
+
+// Works out how a pick is marked, using the client's own rating and label over the options
+func photoOptions(shoot Shoot, key string, options Options) Options {
+	rating := shoot.Ratings[key]
+	if rating.Rating > 0 {
+		options.Rating = rating.Rating
+	}
+	if rating.Label != "" {
+		options.Label = rating.Label
+	}
+	return options
+}
+
+// Writes a zip with an XMP sidecar for every pick
+func WriteSidecars(w io.Writer, shoot Shoot, options Options) error {
+
+	if options.Rating == 0 && options.Label == "" {
+		return errors.New("a rating or a label is needed to mark the picks with")
+	}
+
+	archive := zip.NewWriter(w)
+
+	for _, key := range shoot.Picks {
+		file, err := archive.Create(SidecarName(key))
+		if err != nil {
+			return err
+		}
+		_, err = file.Write(Sidecar(photoOptions(shoot, key, options)))
+		if err != nil {
+			return err
+		}
+	}
+
+	return archive.Close()
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"main/client"
+	"main/export"
+)
+
+// Writes XMP sidecars from an export zip into dir
+// Existing sidecars may hold Lightroom edits, so they are only replaced when force is set
+// Returns how many sidecars were written and how many were skipped
+func writeSidecars(archive []byte, dir string, force bool) (int, int, error) {
+
+	reader, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		return 0, 0, fmt.Errorf("could not read sidecar zip: %v", err)
+	}
+
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	written, skipped := 0, 0
+	for _, file := range reader.File {
+
+		// Names come from the server, so make sure none of them can write outside dir
+		name := filepath.Base(file.Name)
+		if name != file.Name || !strings.HasSuffix(name, ".xmp") {
+			return written, skipped, fmt.Errorf("unexpected file %q in sidecar zip", file.Name)
+		}
+
+		dst := filepath.Join(dir, name)
+		if _, err := os.Stat(dst); err == nil && !force {
+			log.Printf("skipping %v, it already exists. Use -force to replace it", dst)
+			skipped++
+			continue
+		} else if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return written, skipped, err
+		}
+
+		src, err := file.Open()
+		if err != nil {
+			return written, skipped, err
+		}
+		data := new(bytes.Buffer)
+		_, err = data.ReadFrom(src)
+		src.Close()
+		if err != nil {
+			return written, skipped, err
+		}
+
+		err = os.WriteFile(dst, data.Bytes(), 0644)
+		if err != nil {
+			return written, skipped, err
+		}
+		written++
+	}
+
+	return written, skipped, nil
+}
+
+// Prints the shoots a user has so the right id can be passed to -shoot
+func listShoots(ctx context.Context, api *client.Client) error {
+
+	shoots, err := api.AllShoots(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, shoot := range shoots {
+		submitted := "not submitted"
+		if shoot.SubmittedAt != "" {
+			submitted = "submitted " + shoot.SubmittedAt
+		}
+		fmt.Printf("%v\t%v\t%v picks, %v\n", shoot.ID, shoot.Name, shoot.PickCount, submitted)
+	}
+
+	return nil
+}
+
+func main() {
+
+	server := flag.String("server", os.Getenv("CLIENT_PHOTOS_URL"), "URL of the site. Defaults to $CLIENT_PHOTOS_URL")
+	token := flag.String("token", os.Getenv("CLIENT_PHOTOS_TOKEN"), "API token with the shoots:read scope. Defaults to $CLIENT_PHOTOS_TOKEN")
+	owner := flag.String("owner", "", "Username of the client whose shoot to export. Leave empty for your own shoots")
+	shoot := flag.String("shoot", "", "Id of the shoot to export. Leave empty to list the shoots")
+	format := flag.String("format", export.FormatFilenames, "One of "+strings.Join(export.Formats, ", "))
+	rating := flag.Int("rating", export.DefaultRating, "Stars the xmp sidecars give picks the client did not rate, 0 to leave it out")
+	label := flag.String("label", export.DefaultLabel, "Color label the xmp sidecars give picks the client did not label, empty to leave it out. One of "+strings.Join(export.Labels, ", "))
+	out := flag.String("out", "", "File to save filenames or csv to, defaults to stdout. Folder to save xmp sidecars to, defaults to the current folder")
+	force := flag.Bool("force", false, "Replace xmp sidecars that already exist")
+	flag.Parse()
+
+	if *server == "" || *token == "" {
+		log.Fatal("Usage: exporter -server URL -token TOKEN [-owner USER] [-shoot ID -format FORMAT -out PATH]")
+	}
+	if !export.ValidFormat(*format) {
+		log.Fatalf("format must be one of %v", strings.Join(export.Formats, ", "))
+	}
+	options, err := export.Options{Rating: *rating, Label: *label}.Validate()
+	if err != nil && *format == export.FormatXMP {
+		log.Fatal(err)
+	}
+
+	api, err := client.New(*server, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	api = api.WithToken(*token)
+	if *owner != "" {
+		api = api.As(*owner)
+	}
+
+	ctx := context.Background()
+
+	if *shoot == "" {
+		err = listShoots(ctx, api)
+		if err != nil {
+			log.Fatalf("could not list shoots: %v", err)
+		}
+		return
+	}
+
+	data, err := api.ExportPicks(ctx, *shoot, *format, options)
+	if err != nil {
+		log.Fatalf("could not export picks: %v", err)
+	}
+
+	switch {
+	case *format == export.FormatXMP:
+		dir := *out
+		if dir == "" {
+			dir = "."
+		}
+		written, skipped, err := writeSidecars(data, dir, *force)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("wrote %v sidecars to %v, skipped %v\n", written, dir, skipped)
+	case *out == "":
+		os.Stdout.Write(data)
+	default:
+		err = os.WriteFile(*out, data, 0644)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+}
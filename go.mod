@@ -10,6 +10,7 @@ require (
 	github.com/joho/godotenv v1.5.1
 	github.com/things-go/gin-contrib v0.2.2
 	golang.org/x/crypto v0.11.0
+	rsc.io/qr v0.2.0
 )
 
 require (
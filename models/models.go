@@ -0,0 +1,152 @@
+// Package models holds the types the server sends over its JSON API
+// They are shared by the server and the Go client so both always agree on the wire format
+// What the server stores stays in the server, so password hashes and 2FA secrets never reach the client
+package models
+
+// A star rating and color label on one photo, the way Lightroom marks them
+type PhotoRating struct {
+	Rating int    `json:"rating"`          // Stars from 1 to 5, 0 for none
+	Label  string `json:"label,omitempty"` // One of Red, Yellow, Green, Blue or Purple, empty for none
+}
+
+// A comment on a shoot, or on one photo in it
+type Comment struct {
+	ID      string `json:"id"`
+	Author  string `json:"author"`
+	Photo   string `json:"photo,omitempty"` // Key of the photo the comment is about, empty for the whole shoot
+	Text    string `json:"text"`
+	Created string `json:"created"`
+}
+
+// A pick the server would not save and why. Example: {"key": "IMG_0001", "reason": "not in shoot"}
+type RejectedPick struct {
+	Key    string `json:"key"`
+	Reason string `json:"reason"`
+}
+
+type Picks struct {
+	Count int      `json:"count"`
+	Picks []string `json:"picks"`
+}
+
+// A shoot as returned by the JSON API
+type ShootResource struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Date        string `json:"date"`
+	Deadline    string `json:"deadline"`
+	Locked      bool   `json:"locked"`
+	PickCount   int    `json:"pickCount"`
+	MaxPicks    int    `json:"maxPicks"`
+	Downloads   bool   `json:"downloads"`
+	SubmittedAt string `json:"submittedAt"`
+	DeliveredAt string `json:"deliveredAt"`
+	Cover       string `json:"cover"` // Key of the photo shown on the shoot's tile, empty for none
+}
+
+// Body for creating a shoot through the JSON API
+type ShootRequest struct {
+	Name      string   `json:"name"`
+	Prefix    string   `json:"prefix"`
+	Date      string   `json:"date"`
+	Thumbnail string   `json:"thumbnail"`
+	Deadline  string   `json:"deadline"`
+	MaxPicks  int      `json:"maxPicks"`
+	Downloads bool     `json:"downloads"`
+	Files     []string `json:"files"`
+}
+
+// A share link as returned by the JSON API, without its secrets
+type ShareResource struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	Permissions []string `json:"permissions"`
+	Password    bool     `json:"password"` // Whether the link needs a password
+	Expires     string   `json:"expires"`
+	Views       int      `json:"views"`
+	Created     string   `json:"created"`
+	CreatedBy   string   `json:"createdBy"`
+	LastViewed  string   `json:"lastViewed"`
+	URL         string   `json:"url,omitempty"` // Only sent when the link is made, it can not be shown again
+}
+
+// Changes to a shoot. Fields left out are not changed
+type ShootUpdate struct {
+	Name  *string `json:"name,omitempty"`
+	Date  *string `json:"date,omitempty"`  // Used to order shoots by date. Example: "2024-06-14"
+	Cover *string `json:"cover,omitempty"` // Key of the photo to show on the shoot's tile
+
+	// Photographers only
+	Deadline *string `json:"deadline,omitempty"` // RFC 3339 time the picks are due by, empty to remove the deadline
+	Locked   *bool   `json:"locked,omitempty"`   // false lets the client change their picks again
+}
+
+// How the shoots on the home page are ordered
+type ShootOrder struct {
+	Order string   `json:"order"` // One of "date" for newest first, "name" or "custom"
+	IDs   []string `json:"ids"`   // Shoot ids in order, only needed for "custom". Shoots left out go after the rest, newest first
+}
+
+// How many guests favorited a photo, as returned by the JSON API
+type FavoriteResource struct {
+	Key    string   `json:"key"`
+	Count  int      `json:"count"`
+	Guests []string `json:"guests"` // Names of the guests who favorited it
+}
+
+// Body for making a share link
+type ShareRequest struct {
+	Name        string   `json:"name"`
+	Permissions []string `json:"permissions"` // Any of "favorite" and "download", every link can view
+	Password    string   `json:"password"`    // Leave empty for a link that does not need one
+	Expires     string   `json:"expires"`     // RFC 3339 time the link stops working, leave empty for never
+}
+
+// A photo in a shoot as returned by the JSON API
+type PhotoResource struct {
+	Key    string `json:"key"`
+	URL    string `json:"url"`             // Pre-signed url of the thumbnail, only good for a limited time
+	Rating int    `json:"rating"`          // Stars from 1 to 5, 0 for none
+	Label  string `json:"label,omitempty"` // Color label, empty for none
+}
+
+// Body for leaving a comment through the JSON API
+type CommentRequest struct {
+	Photo string `json:"photo"`
+	Text  string `json:"text"`
+}
+
+// A user as returned by the JSON API, without any of the secrets
+type UserResource struct {
+	Username  string `json:"username"`
+	First     string `json:"first"`
+	Last      string `json:"last"`
+	Email     string `json:"email"`
+	Role      string `json:"role"`
+	Verified  bool   `json:"verified"`
+	TwoFactor bool   `json:"twoFactor"`
+}
+
+// Where a page of a list sits in the whole list
+type Pagination struct {
+	Page       int `json:"page"`
+	PerPage    int `json:"perPage"`
+	Total      int `json:"total"`
+	TotalPages int `json:"totalPages"`
+}
+
+// Every error from the JSON API looks like {"error": {"code": ..., "message": ...}}
+type APIErrorResponse struct {
+	Error APIError `json:"error"`
+}
+
+type APIError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Details any    `json:"details,omitempty"`
+}
+
+// The token state changing requests have to send in the X-CSRF-Token header
+type CSRFResource struct {
+	Token string `json:"token"`
+}
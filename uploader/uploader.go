@@ -92,17 +92,45 @@ func createThumbnail(src string, dst string, height int, width int, quality int,
 }
 
 // Creates thumbnails of all the jpg files in a directory
-// Saves them as <filename>_thumb.jpg
+// Saves them as <filename>_thumb.jpg, and previews for the gallery's lightbox as <filename>_preview.jpg
 // dir is the directory to target
 // height is the desired height for the jpg to be resized to
 // width is the desired width for the jpg to be resized to
+// previewHeight and previewWidth are the size of the previews. Previews are not made when they are 0
 // quality is the percentage of quality the jpg should be taken down to. Should be between 1 and 99. Example: 80
 // maxRoutines is the max number of concurrent goroutines you would like at a time. Higher = higher CPU and Memory usage
-func thumbnailDir(dir string, height int, width int, quality int, maxRoutines int, respChan chan string) {
+func thumbnailDir(dir string, height int, width int, previewHeight int, previewWidth int, quality int, maxRoutines int, respChan chan string) {
 
 	routines := 0           // Number of goroutines
 	startTime := time.Now() // Timer start time
 
+	// Starts a goroutine to resize photoPath into dst unless dst already exists
+	// If the number of active goroutines reaches the max desired concurrent goroutines, wait for one to finish
+	resize := func(photoPath string, dst string, height int, width int) {
+
+		_, err := os.Stat(dst)
+		if !errors.Is(err, os.ErrNotExist) {
+			return
+		}
+
+		wg.Add(1)                                                            // Add a Go routine to the wait list
+		routines += 1                                                        // Add one to the number of active goroutines
+		go createThumbnail(photoPath, dst, height, width, quality, respChan) // Start goroutine to create a thumbnail of the jpg
+
+		if routines >= maxRoutines {
+			for {
+
+				chanLen := len(respChan)
+				if chanLen > 0 {
+					_ = fmt.Sprint(<-respChan)
+					routines -= 1
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		}
+	}
+
 	// Get all files in the provided directory
 	// Store it in variable photos
 	photos, err := os.ReadDir(dir)
@@ -111,44 +139,26 @@ func thumbnailDir(dir string, height int, width int, quality int, maxRoutines in
 	}
 
 	// Iterate through the photos
-	// If there is not a thumbnail already existing, create one
+	// If there is not a thumbnail or preview already existing, create one
 	for _, photo := range photos {
 
-		// If the file is not a thumbnail
-		if !(strings.Contains(photo.Name(), "_thumb")) {
-
-			// Generate certain variables to be used on each photo
-			photoPath := fmt.Sprintf("%v/%v", dir, photo.Name())                      // Absolute filepath to the photo
-			noSuffixName := strings.TrimSuffix(photo.Name(), filepath.Ext(photoPath)) // Name of the photo without the file extension
-			thumbnailName := fmt.Sprintf("%v/%v_thumb.jpg", dir, noSuffixName)        // Absolute filepath for the thumbnail file. Used for save path
-
-			// Check to see if thumbnail of the file already exists
-			_, err := os.OpenFile(thumbnailName, os.O_RDWR, 0644)
-			if errors.Is(err, os.ErrNotExist) {
-
-				// Only execute on files that are jpg
-				if strings.ToLower(filepath.Ext(photoPath)) == ".jpg" || strings.ToLower(filepath.Ext(photoPath)) == ".jpeg" {
-
-					wg.Add(1)                                                                      // Add a Go routine to the wait list
-					routines += 1                                                                  // Add one to the number of active goroutines
-					go createThumbnail(photoPath, thumbnailName, height, width, quality, respChan) // Start goroutine to create a thumbnail of the jpg
-
-					// If the number of active goroutines reaches the max desired concurrent goroutines, wait for them to finish
-					// Reset counter to zero then continue
-					if routines >= maxRoutines {
-						for {
-
-							chanLen := len(respChan)
-							if chanLen > 0 {
-								_ = fmt.Sprint(<-respChan)
-								routines -= 1
-								break
-							}
-							time.Sleep(10 * time.Millisecond)
-						}
-					}
-				}
-			}
+		// Skip the thumbnails and previews themselves
+		if strings.Contains(photo.Name(), "_thumb") || strings.Contains(photo.Name(), "_preview") {
+			continue
+		}
+
+		// Generate certain variables to be used on each photo
+		photoPath := fmt.Sprintf("%v/%v", dir, photo.Name())                      // Absolute filepath to the photo
+		noSuffixName := strings.TrimSuffix(photo.Name(), filepath.Ext(photoPath)) // Name of the photo without the file extension
+
+		// Only execute on files that are jpg
+		if strings.ToLower(filepath.Ext(photoPath)) != ".jpg" && strings.ToLower(filepath.Ext(photoPath)) != ".jpeg" {
+			continue
+		}
+
+		resize(photoPath, fmt.Sprintf("%v/%v_thumb.jpg", dir, noSuffixName), height, width)
+		if previewHeight > 0 && previewWidth > 0 {
+			resize(photoPath, fmt.Sprintf("%v/%v_preview.jpg", dir, noSuffixName), previewHeight, previewWidth)
 		}
 	}
 	wg.Wait()                         // Wait for all the goroutines to finish
@@ -159,16 +169,21 @@ func thumbnailDir(dir string, height int, width int, quality int, maxRoutines in
 func main() {
 
 	args := os.Args
-	goodArgLen := 6
 	respChan := make(chan string, 100)
 	defer close(respChan)
 
-	if len(args) < goodArgLen || len(args) > goodArgLen {
-		log.Fatal("Usage: dir, height, width, quality, maxRoutines")
+	// The preview size is optional so existing scripts keep working
+	if len(args) != 6 && len(args) != 8 {
+		log.Fatal("Usage: dir, height, width, quality, maxRoutines, [previewHeight, previewWidth]")
 	}
 	height, _ := strconv.Atoi(args[2])
 	width, _ := strconv.Atoi(args[3])
 	quality, _ := strconv.Atoi(args[4])
 	maxRoutines, _ := strconv.Atoi(args[5])
-	thumbnailDir(args[1], height, width, quality, maxRoutines, respChan)
+	previewHeight, previewWidth := 0, 0
+	if len(args) == 8 {
+		previewHeight, _ = strconv.Atoi(args[6])
+		previewWidth, _ = strconv.Atoi(args[7])
+	}
+	thumbnailDir(args[1], height, width, previewHeight, previewWidth, quality, maxRoutines, respChan)
 }